@@ -5,8 +5,15 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/antnsn/BastionBuddy/internal/azure"
+	"github.com/antnsn/BastionBuddy/internal/config"
+	"github.com/antnsn/BastionBuddy/internal/tunnels"
+	"github.com/antnsn/BastionBuddy/internal/utils"
 	"github.com/antnsn/BastionBuddy/internal/welcome"
 )
 
@@ -14,6 +21,23 @@ import (
 var Version string
 
 func main() {
+	// When ssh invokes us as its askpass helper, only answer the prompt
+	if handled, err := utils.HandleAskPass(); handled {
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "bastionbuddy askpass: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Apply global flags such as --profile before anything else
+	args, err := parseGlobalFlags(os.Args[1:])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	os.Args = append(os.Args[:1], args...)
+
 	// Check for command line arguments
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
@@ -50,16 +74,104 @@ func main() {
 				os.Exit(1)
 			}
 			os.Exit(0)
-		case "--list":
-			var connectionType string
-			if len(os.Args) > 2 {
-				connectionType = os.Args[2]
+		case "--list", "list":
+			filter, err := parseFilterArgs(os.Args[2:])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
 			}
-			if err := azure.ListConfigurations(connectionType); err != nil {
+			if err := azure.ListConfigurations(filter); err != nil {
 				fmt.Printf("Error listing configurations: %v\n", err)
 				os.Exit(1)
 			}
 			os.Exit(0)
+		case "start", "stop", "export":
+			filter, err := parseFilterArgs(os.Args[2:])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			switch os.Args[1] {
+			case "start":
+				err = azure.StartConfigurations(filter)
+			case "stop":
+				err = azure.StopConfigurations(filter)
+			case "export":
+				err = azure.ExportConfigurations(filter, os.Stdout)
+			}
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "tenants":
+			if err := azure.ListTenants(os.Stdout); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "paths":
+			if err := runPathsCommand(); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "secret":
+			if err := runSecretCommand(os.Args[2:]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "cache":
+			if err := runCacheCommand(os.Args[2:]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "profile":
+			if err := runProfileCommand(os.Args[2:]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "config":
+			if err := runConfigCommand(os.Args[2:]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "settings":
+			if err := runSettingsCommand(os.Args[2:]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "sessions":
+			if err := runSessionsCommand(os.Args[2:]); err != nil {
+				if strings.Contains(err.Error(), "cancelled by user") {
+					fmt.Println("\nOperation cancelled by user")
+					os.Exit(0)
+				}
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "pim":
+			if err := runPIMCommand(os.Args[2:]); err != nil {
+				if strings.Contains(err.Error(), "cancelled by user") {
+					fmt.Println("\nOperation cancelled by user")
+					os.Exit(0)
+				}
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "doctor":
+			if err := azure.RunDoctor(os.Stdout); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
 		}
 	}
 
@@ -98,3 +210,629 @@ func main() {
 		welcome.ShowWelcome()
 	}
 }
+
+// parseGlobalFlags applies the flags that precede the command and returns
+// the command with its arguments. Parsing stops at the first other argument,
+// or after "--", so a command's own arguments are never taken as global flags.
+func parseGlobalFlags(args []string) ([]string, error) {
+	var rest []string
+	var vmFilter azure.VMFilter
+parse:
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case isVMFilterFlag(arg):
+			name, value, hasValue := strings.Cut(arg, "=")
+			if !hasValue {
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("%s requires a value", name)
+				}
+				i++
+				value = args[i]
+			}
+			if err := setVMFilterFlag(&vmFilter, name, value); err != nil {
+				return nil, err
+			}
+		case arg == "--group-by-rg":
+			vmFilter.GroupByResourceGroup = true
+		case arg == "--profile":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--profile requires a profile name")
+			}
+			i++
+			config.SetProfileName(args[i])
+		case strings.HasPrefix(arg, "--profile="):
+			config.SetProfileName(strings.TrimPrefix(arg, "--profile="))
+		case arg == "--tenant":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--tenant requires a tenant ID")
+			}
+			i++
+			azure.SetTenant(args[i])
+		case strings.HasPrefix(arg, "--tenant="):
+			azure.SetTenant(strings.TrimPrefix(arg, "--tenant="))
+		case arg == "--auth":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--auth requires a credential source")
+			}
+			i++
+			if err := azure.SetAuthSource(args[i]); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, "--auth="):
+			if err := azure.SetAuthSource(strings.TrimPrefix(arg, "--auth=")); err != nil {
+				return nil, err
+			}
+		case arg == "--cloud":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--cloud requires a cloud name or endpoint")
+			}
+			i++
+			if err := azure.SetCloud(args[i]); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, "--cloud="):
+			if err := azure.SetCloud(strings.TrimPrefix(arg, "--cloud=")); err != nil {
+				return nil, err
+			}
+		case arg == "--refresh":
+			azure.SetForceRefresh(true)
+		case arg == "--offline":
+			azure.SetOfflineMode(true)
+		case arg == "--pim":
+			azure.SetPIMDiscovery(true)
+		case arg == "--set":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--set requires a name=value argument")
+			}
+			i++
+			if err := setVariable(args[i]); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, "--set="):
+			if err := setVariable(strings.TrimPrefix(arg, "--set=")); err != nil {
+				return nil, err
+			}
+		case arg == "--config-dir":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--config-dir requires a directory")
+			}
+			i++
+			config.SetConfigDir(args[i])
+		case strings.HasPrefix(arg, "--config-dir="):
+			config.SetConfigDir(strings.TrimPrefix(arg, "--config-dir="))
+		case arg == "--":
+			rest = args[i+1:]
+			break parse
+		default:
+			rest = args[i:]
+			break parse
+		}
+	}
+	azure.SetVMFilter(vmFilter)
+	return rest, nil
+}
+
+// isVMFilterFlag reports whether arg is one of the VM picker filter flags
+func isVMFilterFlag(arg string) bool {
+	name, _, _ := strings.Cut(arg, "=")
+	switch name {
+	case "--vm-group", "--vm-tag", "--vm-os", "--vm-state":
+		return true
+	}
+	return false
+}
+
+// vmPowerStates are the power states accepted by --vm-state
+var vmPowerStates = map[string]bool{
+	"running":      true,
+	"stopped":      true,
+	"deallocated":  true,
+	"starting":     true,
+	"stopping":     true,
+	"deallocating": true,
+}
+
+// setVMFilterFlag applies a VM picker filter flag such as --vm-tag env=prod
+func setVMFilterFlag(filter *azure.VMFilter, name string, value string) error {
+	switch name {
+	case "--vm-group":
+		filter.ResourceGroup = value
+	case "--vm-tag":
+		key, tagValue, err := tunnels.ParseTag(value)
+		if err != nil {
+			return err
+		}
+		if filter.Tags == nil {
+			filter.Tags = make(map[string]string)
+		}
+		filter.Tags[key] = tagValue
+	case "--vm-os":
+		switch strings.ToLower(value) {
+		case "linux":
+			filter.OSType = "Linux"
+		case "windows":
+			filter.OSType = "Windows"
+		default:
+			return fmt.Errorf("invalid --vm-os %q: expected linux or windows", value)
+		}
+	case "--vm-state":
+		state := strings.ToLower(value)
+		if !vmPowerStates[state] {
+			return fmt.Errorf("invalid --vm-state %q: expected running, stopped, deallocated, starting, stopping or deallocating", value)
+		}
+		filter.PowerState = state
+	}
+	return nil
+}
+
+// runCacheCommand handles "cache clear" and "cache path"
+func runCacheCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: cache clear | cache path")
+	}
+
+	switch args[0] {
+	case "clear":
+		dir, err := azure.ClearCache()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Cleared discovery cache in %s\n", dir)
+		return nil
+	case "path":
+		dir, err := azure.CacheDir()
+		if err != nil {
+			return err
+		}
+		fmt.Println(dir)
+		return nil
+	default:
+		return fmt.Errorf("usage: cache clear | cache path")
+	}
+}
+
+// setVariable applies a "name=value" template variable override
+func setVariable(assignment string) error {
+	name, value, ok := strings.Cut(assignment, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid variable %q: expected name=value", assignment)
+	}
+	config.SetVariable(name, value)
+	return nil
+}
+
+// parseFilterArgs parses "[type] [--tag key=value]... [--search text]" into a filter
+func parseFilterArgs(args []string) (tunnels.Filter, error) {
+	filter := tunnels.Filter{Tags: make(map[string]string)}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--tag":
+			if i+1 >= len(args) {
+				return filter, fmt.Errorf("--tag requires a key=value argument")
+			}
+			i++
+			key, value, err := tunnels.ParseTag(args[i])
+			if err != nil {
+				return filter, err
+			}
+			filter.Tags[key] = value
+		case "--search":
+			if i+1 >= len(args) {
+				return filter, fmt.Errorf("--search requires an argument")
+			}
+			i++
+			filter.Search = args[i]
+		default:
+			if strings.HasPrefix(args[i], "--") || filter.ConnectionType != "" {
+				return filter, fmt.Errorf("unexpected argument: %s", args[i])
+			}
+			connectionType, err := tunnels.ParseConnectionType(args[i])
+			if err != nil {
+				return filter, err
+			}
+			filter.ConnectionType = connectionType
+		}
+	}
+	return filter, nil
+}
+
+// runConfigCommand handles "config tag|untag|describe <name> ..."
+func runConfigCommand(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: config show <name> [--resolved] | config tag <name> key=value... | config untag <name> key... | config describe <name> <text> | config secret <name> [secret-name] | config keyvault <name> [<vault>/<secret> [--kind password|ssh-key] [--subscription id] [--url uri]] | config stop-vm <name> on|off | config jit <name> on|off [--ports 22,8080] [--duration 2h] [--justification text] | config pim-role <name> <role> <scope>|off")
+	}
+
+	name := args[1]
+	switch args[0] {
+	case "tag":
+		tags := make(map[string]string)
+		for _, arg := range args[2:] {
+			key, value, err := tunnels.ParseTag(arg)
+			if err != nil {
+				return err
+			}
+			tags[key] = value
+		}
+		return azure.TagConfiguration(name, tags, nil)
+	case "untag":
+		return azure.TagConfiguration(name, nil, args[2:])
+	case "describe":
+		return azure.DescribeConfiguration(name, strings.Join(args[2:], " "))
+	case "show":
+		resolve := len(args) > 2 && args[2] == "--resolved"
+		return azure.ShowConfiguration(name, resolve, os.Stdout)
+	case "secret":
+		var secretName string
+		if len(args) > 2 {
+			secretName = args[2]
+		}
+		return azure.SetConfigurationSecret(name, secretName)
+	case "keyvault":
+		if len(args) == 2 {
+			return azure.SetConfigurationKeyVaultSecret(name, nil)
+		}
+		return runKeyVaultConfig(name, args[2:])
+	case "stop-vm":
+		if len(args) != 3 || (args[2] != "on" && args[2] != "off") {
+			return fmt.Errorf("usage: config stop-vm <name> on|off")
+		}
+		return azure.SetStopVMOnDisconnect(name, args[2] == "on")
+	case "jit":
+		if len(args) < 3 || (args[2] != "on" && args[2] != "off") {
+			return fmt.Errorf("usage: config jit <name> on|off [--ports 22,8080] [--duration 2h] [--justification text]")
+		}
+		if args[2] == "off" {
+			return azure.SetConfigurationJIT(name, nil)
+		}
+		return runJITConfig(name, args[3:])
+	case "pim-role":
+		if len(args) == 3 && args[2] == "off" {
+			return azure.SetConfigurationPIMRole(name, nil)
+		}
+		if len(args) != 4 {
+			return fmt.Errorf("usage: config pim-role <name> <role> <scope>|off")
+		}
+		return azure.SetConfigurationPIMRole(name, &tunnels.PIMRole{Role: args[2], Scope: args[3]})
+	default:
+		return fmt.Errorf("unknown config command: %s", args[0])
+	}
+}
+
+// runProfileCommand handles "profile list|set|remove"
+func runProfileCommand(args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		profiles, err := config.LoadProfiles()
+		if err != nil {
+			return err
+		}
+		if len(profiles) == 0 {
+			fmt.Println("No profiles defined")
+			return nil
+		}
+		for _, profile := range profiles {
+			marker := " "
+			if profile.Name == config.ProfileName() {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, profile.Name)
+			if profile.TenantID != "" {
+				fmt.Printf("    Tenant: %s\n", profile.TenantID)
+			}
+			if profile.SubscriptionID != "" {
+				fmt.Printf("    Subscription: %s\n", profile.SubscriptionID)
+			}
+			if profile.Color != "" {
+				fmt.Printf("    Color: %s\n", profile.Color)
+			}
+			if profile.SeparateAzLogin {
+				fmt.Printf("    Separate az login: yes\n")
+			}
+			if profile.Auth != "" {
+				fmt.Printf("    Auth: %s\n", profile.Auth)
+			}
+			if profile.Cloud != "" {
+				fmt.Printf("    Cloud: %s\n", profile.Cloud)
+			}
+			if profile.Proxy != "" {
+				fmt.Printf("    Proxy: %s\n", profile.Proxy)
+			}
+			if profile.CABundle != "" {
+				fmt.Printf("    CA bundle: %s\n", profile.CABundle)
+			}
+			if profile.PIM {
+				fmt.Printf("    PIM subscriptions: yes\n")
+			}
+			if len(profile.Variables) > 0 {
+				fmt.Printf("    Variables: %s\n", tunnels.FormatTags(profile.Variables))
+			}
+		}
+		return nil
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf("usage: profile list | profile set <name> key=value... | profile remove <name>")
+	}
+
+	name := args[1]
+	switch args[0] {
+	case "set":
+		profiles, err := config.LoadProfiles()
+		if err != nil {
+			return err
+		}
+		profile := config.Profile{Name: name}
+		for _, existing := range profiles {
+			if existing.Name == name {
+				profile = existing
+			}
+		}
+		for _, arg := range args[2:] {
+			key, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				return fmt.Errorf("invalid setting %q: expected key=value", arg)
+			}
+			switch key {
+			case "tenant":
+				profile.TenantID = value
+			case "subscription":
+				profile.SubscriptionID = value
+			case "color":
+				profile.Color = value
+			case "banner":
+				profile.Banner = value
+			case "separate-az-login":
+				profile.SeparateAzLogin = value == "true" || value == "yes"
+			case "auth":
+				if value != "" {
+					if err := azure.ValidateAuthSource(value); err != nil {
+						return err
+					}
+				}
+				profile.Auth = value
+			case "cloud":
+				if value != "" {
+					if err := azure.ValidateCloud(value); err != nil {
+						return err
+					}
+				}
+				profile.Cloud = value
+			case "proxy":
+				profile.Proxy = value
+			case "ca-bundle":
+				profile.CABundle = value
+			case "pim":
+				profile.PIM = value == "true" || value == "yes"
+			default:
+				if name, ok := strings.CutPrefix(key, "var."); ok && name != "" {
+					if profile.Variables == nil {
+						profile.Variables = make(map[string]string)
+					}
+					if value == "" {
+						delete(profile.Variables, name)
+					} else {
+						profile.Variables[name] = value
+					}
+					continue
+				}
+				return fmt.Errorf("unknown profile setting: %s", key)
+			}
+		}
+		return config.SaveProfile(profile)
+	case "remove":
+		return config.RemoveProfile(name)
+	default:
+		return fmt.Errorf("unknown profile command: %s", args[0])
+	}
+}
+
+// runSettingsCommand shows or changes the global settings
+func runSettingsCommand(args []string) error {
+	settings, err := config.LoadSettings()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 || args[0] == "show" {
+		fmt.Printf("proxy: %s\n", settings.Proxy)
+		fmt.Printf("no-proxy: %s\n", settings.NoProxy)
+		fmt.Printf("ca-bundle: %s\n", settings.CABundle)
+		return nil
+	}
+	if args[0] != "set" {
+		return fmt.Errorf("usage: settings [show] | settings set key=value...")
+	}
+
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("invalid setting %q: expected key=value", arg)
+		}
+		switch key {
+		case "proxy":
+			settings.Proxy = value
+		case "no-proxy":
+			settings.NoProxy = value
+		case "ca-bundle":
+			if value != "" {
+				if value, err = filepath.Abs(value); err != nil {
+					return err
+				}
+				if _, err := os.Stat(value); err != nil {
+					return fmt.Errorf("CA bundle not found: %v", err)
+				}
+			}
+			settings.CABundle = value
+		default:
+			return fmt.Errorf("unknown setting: %s", key)
+		}
+	}
+	return config.SaveSettings(settings)
+}
+
+// runPathsCommand prints the resolved configuration and state locations
+func runPathsCommand() error {
+	baseDir, err := config.BaseDir()
+	if err != nil {
+		return err
+	}
+	configDir, err := config.ConfigDir()
+	if err != nil {
+		return err
+	}
+	stateDir, err := config.StateDir()
+	if err != nil {
+		return err
+	}
+
+	profile := config.ProfileName()
+	if profile == "" {
+		profile = "(default)"
+	}
+
+	fmt.Printf("Profile:          %s\n", profile)
+	fmt.Printf("Base config dir:  %s (from %s)\n", baseDir, config.BaseDirSource())
+	fmt.Printf("Profiles file:    %s\n", filepath.Join(baseDir, "profiles.json"))
+	fmt.Printf("Config dir:       %s\n", configDir)
+	fmt.Printf("  SSH configs:    %s\n", filepath.Join(configDir, "ssh.json"))
+	fmt.Printf("  RDP configs:    %s\n", filepath.Join(configDir, "rdp.json"))
+	fmt.Printf("  Tunnel configs: %s\n", filepath.Join(configDir, "tunnels.json"))
+	fmt.Printf("  Cache:          %s\n", filepath.Join(configDir, "cache"))
+	fmt.Printf("State dir:        %s\n", stateDir)
+	fmt.Printf("  Active tunnels: %s\n", filepath.Join(stateDir, "active.json"))
+	fmt.Println("Team config sources:")
+	for _, source := range tunnels.TeamConfigDirs() {
+		status := ""
+		if _, err := os.Stat(source); err != nil {
+			status = " (not present)"
+		}
+		fmt.Printf("  %s%s\n", source, status)
+	}
+	return nil
+}
+
+// runKeyVaultConfig parses "<vault>/<secret> [--kind k] [--subscription id] [--url uri]"
+func runKeyVaultConfig(name string, args []string) error {
+	vault, secret, ok := strings.Cut(args[0], "/")
+	if !ok || vault == "" || secret == "" {
+		return fmt.Errorf("invalid Key Vault reference %q: expected <vault>/<secret>", args[0])
+	}
+
+	ref := &tunnels.KeyVaultSecret{Vault: vault, Secret: secret}
+	for i := 1; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return fmt.Errorf("%s requires a value", args[i])
+		}
+		switch args[i] {
+		case "--kind":
+			ref.Kind = args[i+1]
+		case "--subscription":
+			ref.SubscriptionID = args[i+1]
+		case "--url":
+			ref.VaultURL = args[i+1]
+		default:
+			return fmt.Errorf("unexpected argument: %s", args[i])
+		}
+		i++
+	}
+	return azure.SetConfigurationKeyVaultSecret(name, ref)
+}
+
+// runSessionsCommand handles "sessions <bastion>" and "sessions kill <id>...
+// [--bastion <bastion>]"
+func runSessionsCommand(args []string) error {
+	const usage = "usage: sessions <bastion> | sessions kill <session-id>... [--bastion <bastion>]"
+	if len(args) == 0 {
+		return fmt.Errorf(usage)
+	}
+	if args[0] != "kill" {
+		if len(args) != 1 {
+			return fmt.Errorf(usage)
+		}
+		return azure.ListBastionSessions(args[0], os.Stdout)
+	}
+
+	var bastion string
+	var sessionIDs []string
+	for i := 1; i < len(args); i++ {
+		if args[i] == "--bastion" {
+			if i+1 >= len(args) {
+				return fmt.Errorf("--bastion requires a value")
+			}
+			bastion = args[i+1]
+			i++
+			continue
+		}
+		sessionIDs = append(sessionIDs, args[i])
+	}
+	if len(sessionIDs) == 0 {
+		return fmt.Errorf(usage)
+	}
+	return azure.DisconnectBastionSessions(bastion, sessionIDs)
+}
+
+// runPIMCommand handles "pim [list]" and "pim activate [--subscription id]
+// [--role name] [--duration d] [--justification text]"
+func runPIMCommand(args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		return azure.ListPIMRoles(os.Stdout)
+	}
+	if args[0] != "activate" {
+		return fmt.Errorf("usage: pim [list] | pim activate [--subscription id] [--role name] [--duration 2h] [--justification text]")
+	}
+
+	var activation azure.PIMActivation
+	for i := 1; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return fmt.Errorf("%s requires a value", args[i])
+		}
+		switch args[i] {
+		case "--subscription":
+			activation.SubscriptionID = args[i+1]
+		case "--role":
+			activation.Role = args[i+1]
+		case "--duration":
+			if d, err := time.ParseDuration(args[i+1]); err != nil || d <= 0 {
+				return fmt.Errorf("invalid duration %q: expected e.g. 30m or 4h", args[i+1])
+			}
+			activation.Duration = args[i+1]
+		case "--justification":
+			activation.Justification = args[i+1]
+		default:
+			return fmt.Errorf("unexpected argument: %s", args[i])
+		}
+		i++
+	}
+	return azure.ActivatePIMRole(activation)
+}
+
+// runJITConfig handles "config jit <name> on [--ports p,...] [--duration d] [--justification text]"
+func runJITConfig(name string, args []string) error {
+	jit := &tunnels.JITAccess{}
+	for i := 0; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return fmt.Errorf("%s requires a value", args[i])
+		}
+		switch args[i] {
+		case "--ports":
+			for _, field := range strings.Split(args[i+1], ",") {
+				port, err := strconv.Atoi(strings.TrimSpace(field))
+				if err != nil || port < 1 || port > 65535 {
+					return fmt.Errorf("invalid port %q", field)
+				}
+				jit.Ports = append(jit.Ports, port)
+			}
+		case "--duration":
+			if d, err := time.ParseDuration(args[i+1]); err != nil || d <= 0 {
+				return fmt.Errorf("invalid duration %q: expected e.g. 30m or 2h", args[i+1])
+			}
+			jit.Duration = args[i+1]
+		case "--justification":
+			jit.Justification = args[i+1]
+		default:
+			return fmt.Errorf("unexpected argument: %s", args[i])
+		}
+		i++
+	}
+	return azure.SetConfigurationJIT(name, jit)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/antnsn/BastionBuddy/internal/azure"
+	"github.com/antnsn/BastionBuddy/internal/config"
+	"github.com/antnsn/BastionBuddy/internal/tunnels"
+)
+
+func TestParseGlobalFlags(t *testing.T) {
+	t.Setenv(config.ProfileEnv, "")
+	t.Cleanup(func() { config.SetProfileName("") })
+
+	tests := []struct {
+		args    []string
+		want    []string
+		profile string
+	}{
+		{args: nil, want: nil},
+		{args: []string{"--profile", "prod"}, want: nil, profile: "prod"},
+		{args: []string{"--profile=prod", "list", "--tag", "env=prod"}, want: []string{"list", "--tag", "env=prod"}, profile: "prod"},
+		// A command's arguments are its own, even when they look like global flags
+		{args: []string{"config", "describe", "db", "--profile", "prod"}, want: []string{"config", "describe", "db", "--profile", "prod"}},
+		{args: []string{"--ssh", "prod-vm", "--profile", "prod"}, want: []string{"--ssh", "prod-vm", "--profile", "prod"}},
+		{args: []string{"--profile", "prod", "--", "--profile", "x"}, want: []string{"--profile", "x"}, profile: "prod"},
+		{args: []string{"--"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		config.SetProfileName("")
+		got, err := parseGlobalFlags(tt.args)
+		if err != nil {
+			t.Errorf("parseGlobalFlags(%q): %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseGlobalFlags(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+		if profile := config.ProfileName(); profile != tt.profile {
+			t.Errorf("parseGlobalFlags(%q) selected profile %q, want %q", tt.args, profile, tt.profile)
+		}
+	}
+}
+
+func TestParseGlobalFlagsMissingValue(t *testing.T) {
+	for _, args := range [][]string{{"--profile"}, {"--tenant"}, {"--vm-tag"}} {
+		if _, err := parseGlobalFlags(args); err == nil {
+			t.Errorf("parseGlobalFlags(%q) succeeded, want an error", args)
+		}
+	}
+}
+
+func TestSetVMFilterFlag(t *testing.T) {
+	var filter azure.VMFilter
+	if err := setVMFilterFlag(&filter, "--vm-state", "Deallocated"); err != nil {
+		t.Fatalf("setVMFilterFlag(--vm-state Deallocated) failed: %v", err)
+	}
+	if filter.PowerState != "deallocated" {
+		t.Errorf("PowerState = %q, want %q", filter.PowerState, "deallocated")
+	}
+
+	for _, tt := range [][2]string{{"--vm-state", "runing"}, {"--vm-state", ""}, {"--vm-os", "bsd"}} {
+		if err := setVMFilterFlag(&filter, tt[0], tt[1]); err == nil {
+			t.Errorf("setVMFilterFlag(%s %q) succeeded, want an error", tt[0], tt[1])
+		}
+	}
+}
+
+func TestParseFilterArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    tunnels.Filter
+		wantErr bool
+	}{
+		{args: nil, want: tunnels.Filter{Tags: map[string]string{}}},
+		{args: []string{"tunnels"}, want: tunnels.Filter{ConnectionType: "tunnel", Tags: map[string]string{}}},
+		{args: []string{"ssh", "--tag", "env=prod", "--tag", "team", "--search", "pg"},
+			want: tunnels.Filter{ConnectionType: "ssh", Tags: map[string]string{"env": "prod", "team": ""}, Search: "pg"}},
+		{args: []string{"foo"}, wantErr: true},
+		{args: []string{"ssh", "rdp"}, wantErr: true},
+		{args: []string{"--tag"}, wantErr: true},
+		{args: []string{"--tag", "=prod"}, wantErr: true},
+		{args: []string{"--unknown"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseFilterArgs(tt.args)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseFilterArgs(%q) = %+v, want an error", tt.args, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFilterArgs(%q) = %+v, %v, want %+v", tt.args, got, err, tt.want)
+		}
+	}
+}
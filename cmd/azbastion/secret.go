@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/antnsn/BastionBuddy/internal/config"
+	"github.com/antnsn/BastionBuddy/internal/secrets"
+	"github.com/antnsn/BastionBuddy/internal/utils"
+)
+
+// defaultAgentTTL is how long "secret unlock" keeps the vault key available
+const defaultAgentTTL = 15 * time.Minute
+
+// runSecretCommand handles "secret set|list|delete|unlock|lock"
+func runSecretCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: secret set <name> | secret list | secret delete <name> | secret unlock [--ttl 15m] | secret lock")
+	}
+
+	switch args[0] {
+	case "set":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: secret set <name>")
+		}
+		vault, err := secrets.OpenUnlocked(utils.ReadPassword)
+		if err != nil {
+			return err
+		}
+		value, err := utils.ReadPassword(fmt.Sprintf("Value for %s", args[1]))
+		if err != nil {
+			return err
+		}
+		if err := vault.Set(args[1], value); err != nil {
+			return err
+		}
+		if err := vault.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("Secret '%s' saved\n", args[1])
+		return nil
+	case "list":
+		vault, err := secrets.OpenUnlocked(utils.ReadPassword)
+		if err != nil {
+			return err
+		}
+		names := vault.Names()
+		if len(names) == 0 {
+			fmt.Println("No secrets stored")
+			return nil
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	case "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: secret delete <name>")
+		}
+		vault, err := secrets.OpenUnlocked(utils.ReadPassword)
+		if err != nil {
+			return err
+		}
+		if err := vault.Delete(args[1]); err != nil {
+			return err
+		}
+		if err := vault.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("Secret '%s' deleted\n", args[1])
+		return nil
+	case "unlock":
+		ttl, err := parseTTL(args[1:])
+		if err != nil {
+			return err
+		}
+		return unlockVault(ttl)
+	case "lock":
+		infoFile, err := secrets.AgentInfoPath()
+		if err != nil {
+			return err
+		}
+		if err := secrets.StopAgent(infoFile); err != nil {
+			return err
+		}
+		fmt.Println("Vault locked")
+		return nil
+	case "agent":
+		ttl, err := parseTTL(args[1:])
+		if err != nil {
+			return err
+		}
+		return serveAgent(ttl)
+	default:
+		return fmt.Errorf("unknown secret command: %s", args[0])
+	}
+}
+
+// parseTTL parses an optional "--ttl <duration>" argument
+func parseTTL(args []string) (time.Duration, error) {
+	if len(args) == 0 {
+		return defaultAgentTTL, nil
+	}
+	if len(args) != 2 || args[0] != "--ttl" {
+		return 0, fmt.Errorf("expected --ttl <duration>")
+	}
+	ttl, err := time.ParseDuration(args[1])
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid duration: %s", args[1])
+	}
+	return ttl, nil
+}
+
+// unlockVault unlocks the vault and starts a background agent holding the key
+func unlockVault(ttl time.Duration) error {
+	vault, err := secrets.OpenUnlocked(utils.ReadPassword)
+	if err != nil {
+		return err
+	}
+	if vault.IsNew() {
+		return fmt.Errorf("the vault is empty; add a secret with 'secret set <name>' first")
+	}
+
+	infoFile, err := secrets.AgentInfoPath()
+	if err != nil {
+		return err
+	}
+	// Replace any agent that is already running
+	_ = secrets.StopAgent(infoFile)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %v", err)
+	}
+
+	// The agent must advertise itself where this profile's clients look for it
+	stateDir, err := config.BaseStateDir()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(exe, "secret", "agent", "--ttl", ttl.String())
+	cmd.Env = append(os.Environ(),
+		config.StateDirEnv+"="+stateDir,
+		config.ProfileEnv+"="+config.ProfileName())
+	cmd.SysProcAttr = utils.GetSysProcAttr()
+	cmd.Stdin = strings.NewReader(hex.EncodeToString(secrets.SessionKey(vault)) + "\n")
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start secret agent: %v", err)
+	}
+	if err := cmd.Process.Release(); err != nil {
+		return fmt.Errorf("failed to detach secret agent: %v", err)
+	}
+
+	// Wait until the agent is reachable so the next command can use it
+	for i := 0; i < 20; i++ {
+		if _, err := secrets.AgentKey(infoFile); err == nil {
+			fmt.Printf("Vault unlocked for %s\n", ttl)
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("secret agent did not start")
+}
+
+// serveAgent reads the vault key from stdin and serves it until ttl elapses
+func serveAgent(ttl time.Duration) error {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read key: %v", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(line))
+	if err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+
+	infoFile, err := secrets.AgentInfoPath()
+	if err != nil {
+		return err
+	}
+	return secrets.ServeAgent(infoFile, key, ttl)
+}
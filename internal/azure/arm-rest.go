@@ -0,0 +1,174 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// armHTTPClient is used for Azure Resource Manager REST calls that have no
+// SDK client in this module
+var armHTTPClient = &http.Client{Timeout: 60 * time.Second}
+
+// armEndpoint returns the Azure Resource Manager endpoint of the cloud in use
+func armEndpoint() string {
+	return strings.TrimSuffix(activeCloud().ResourceManager, "/")
+}
+
+// armError is the error body returned by Azure Resource Manager
+type armError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// armResponseError is returned by armRequest for a non-2xx response
+type armResponseError struct {
+	StatusCode int
+	Status     string
+	Code       string
+	Message    string
+}
+
+func (e *armResponseError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("azure returned %s", e.Status)
+	}
+	return fmt.Sprintf("azure returned %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// armRequest sends an authenticated request to Azure Resource Manager. path
+// is relative to the endpoint and includes the api-version. A non-nil body is
+// sent as JSON and a non-nil out receives the decoded response.
+func armRequest(ctx context.Context, cred azcore.TokenCredential, method string, path string, body interface{}, out interface{}) error {
+	_, err := armSend(ctx, cred, method, armEndpoint()+path, body, out)
+	return err
+}
+
+// armLongRunning is armRequest for operations that may run asynchronously:
+// while Azure answers 202 Accepted, the Location header is polled until it
+// returns the result
+func armLongRunning(ctx context.Context, cred azcore.TokenCredential, method string, path string, body interface{}, out interface{}) error {
+	resp, err := armSend(ctx, cred, method, armEndpoint()+path, body, out)
+	for err == nil && resp.StatusCode == http.StatusAccepted {
+		location := resp.Header.Get("Location")
+		if location == "" {
+			return fmt.Errorf("azure accepted the request but returned no Location to poll")
+		}
+		delay := 2 * time.Second
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 && seconds <= 60 {
+			delay = time.Duration(seconds) * time.Second
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		resp, err = armSend(ctx, cred, http.MethodGet, location, nil, out)
+	}
+	return err
+}
+
+// armSend sends an authenticated request to an Azure Resource Manager URL
+// and returns the response, whose body has been read into out
+func armSend(ctx context.Context, cred azcore.TokenCredential, method string, url string, body interface{}, out interface{}) (*http.Response, error) {
+	token, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{activeCloud().scope()}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Azure Resource Manager token: %v", err)
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	debugPrintf("%s %s\n", method, url)
+	resp, err := armHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Azure Resource Manager: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respErr := &armResponseError{StatusCode: resp.StatusCode, Status: resp.Status}
+		var armErr armError
+		if json.Unmarshal(data, &armErr) == nil {
+			respErr.Code = armErr.Error.Code
+			respErr.Message = armErr.Error.Message
+		}
+		return nil, respErr
+	}
+
+	if out != nil && len(data) > 0 && resp.StatusCode != http.StatusAccepted {
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, fmt.Errorf("failed to parse response: %v", err)
+		}
+	}
+	return resp, nil
+}
+
+// armList reads every page of an ARM list operation, following nextLink
+func armList[T any](ctx context.Context, cred azcore.TokenCredential, path string) ([]T, error) {
+	var items []T
+	for path != "" {
+		var page struct {
+			Value    []T    `json:"value"`
+			NextLink string `json:"nextLink"`
+		}
+		if err := armRequest(ctx, cred, http.MethodGet, path, nil, &page); err != nil {
+			return nil, err
+		}
+		items = append(items, page.Value...)
+		path = strings.TrimPrefix(page.NextLink, armEndpoint())
+	}
+	return items, nil
+}
+
+// armPostList sends a POST whose response is a list, following nextLink
+func armPostList[T any](ctx context.Context, cred azcore.TokenCredential, path string, body interface{}) ([]T, error) {
+	var page struct {
+		Value    []T    `json:"value"`
+		NextLink string `json:"nextLink"`
+	}
+	if err := armRequest(ctx, cred, http.MethodPost, path, body, &page); err != nil {
+		return nil, err
+	}
+	if page.NextLink == "" {
+		return page.Value, nil
+	}
+	more, err := armList[T](ctx, cred, strings.TrimPrefix(page.NextLink, armEndpoint()))
+	if err != nil {
+		return nil, err
+	}
+	return append(page.Value, more...), nil
+}
@@ -0,0 +1,399 @@
+package azure
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/antnsn/BastionBuddy/internal/utils"
+)
+
+// authErrorKind classifies why Azure rejected a sign-in
+type authErrorKind int
+
+const (
+	// authNotSignedIn means there is no usable sign-in at all
+	authNotSignedIn authErrorKind = iota
+	// authExpired means the sign-in or its refresh token aged out
+	authExpired
+	// authInteractionRequired means MFA or a conditional access policy
+	// requires signing in again interactively
+	authInteractionRequired
+	// authWrongTenant means the token is for a different tenant
+	authWrongTenant
+)
+
+// authErrorMarkers maps text found in SDK errors and az output to the kind of
+// failure. AADSTS codes are listed at https://aka.ms/AADSTS.
+var authErrorMarkers = []struct {
+	marker string
+	kind   authErrorKind
+}{
+	{"AADSTS700082", authExpired}, // refresh token expired due to inactivity
+	{"AADSTS70043", authExpired},  // refresh token expired by sign-in frequency
+	{"AADSTS50173", authExpired},  // grant revoked, e.g. after a password change
+	{"AADSTS50133", authExpired},  // session invalid after a password change
+	{"ExpiredAuthenticationToken", authExpired},
+	{"token is expired", authExpired},
+	{"AADSTS50076", authInteractionRequired}, // MFA required
+	{"AADSTS50079", authInteractionRequired}, // MFA registration required
+	{"AADSTS50078", authInteractionRequired}, // MFA claim expired
+	{"AADSTS50158", authInteractionRequired}, // external security challenge
+	{"AADSTS53003", authInteractionRequired}, // blocked by conditional access
+	{"interaction_required", authInteractionRequired},
+	{"InvalidAuthenticationTokenTenant", authWrongTenant},
+	{"AADSTS90072", authWrongTenant}, // user doesn't exist in the tenant
+	{"Please run 'az login'", authNotSignedIn},
+	{"Please run \"az login\"", authNotSignedIn},
+	{"Please run `az login`", authNotSignedIn},
+	{"Run `az login`", authNotSignedIn}, // account missing from the MSAL token cache
+	{"DefaultAzureCredential: failed to acquire a token", authNotSignedIn},
+}
+
+// AuthError is an authentication failure from an SDK call or the Azure CLI
+type AuthError struct {
+	Kind authErrorKind
+	// Source is "sdk" or "az"
+	Source string
+	Err    error
+}
+
+// Error describes the failure and what to do about it
+func (e *AuthError) Error() string {
+	var reason string
+	switch e.Kind {
+	case authExpired:
+		reason = "your Azure sign-in has expired"
+	case authInteractionRequired:
+		reason = "Azure requires you to sign in again (MFA or conditional access)"
+	case authWrongTenant:
+		reason = "you are signed in to a different tenant than the resource belongs to"
+	default:
+		reason = "you are not signed in to Azure"
+	}
+	if e.Source == "az" {
+		reason += " in the Azure CLI"
+	}
+	return fmt.Sprintf("%s: %v", reason, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// classifyAuthError reports whether err from an SDK call is an
+// authentication failure, and of which kind. It returns nil otherwise.
+func classifyAuthError(err error) *AuthError {
+	if err == nil {
+		return nil
+	}
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return authErr
+	}
+	if kind, ok := authErrorKindOf(err.Error()); ok {
+		return &AuthError{Kind: kind, Source: "sdk", Err: err}
+	}
+	var failed *azidentity.AuthenticationFailedError
+	if errors.As(err, &failed) {
+		return &AuthError{Kind: authNotSignedIn, Source: "sdk", Err: err}
+	}
+	return nil
+}
+
+// classifyAzOutput reports whether the output of a failed az command shows
+// an authentication failure. It returns nil otherwise.
+func classifyAzOutput(output string) *AuthError {
+	kind, ok := authErrorKindOf(output)
+	if !ok {
+		return nil
+	}
+	return &AuthError{Kind: kind, Source: "az", Err: errors.New(strings.TrimSpace(output))}
+}
+
+// authErrorKindOf finds the first known authentication failure in text
+func authErrorKindOf(text string) (authErrorKind, bool) {
+	for _, m := range authErrorMarkers {
+		if strings.Contains(text, m.marker) {
+			return m.kind, true
+		}
+	}
+	return 0, false
+}
+
+// reauthContextKey marks contexts in which no sign-in prompt may be shown
+type reauthContextKey struct{}
+
+// withoutReauthPrompt returns a context whose token requests fail instead of
+// prompting to sign in again, for work running behind the user's back
+func withoutReauthPrompt(ctx context.Context) context.Context {
+	return context.WithValue(ctx, reauthContextKey{}, true)
+}
+
+var (
+	// reauthMu serializes sign-in prompts across concurrent requests
+	reauthMu sync.Mutex
+	// reauthGeneration counts successful re-authentications, so requests
+	// that failed together only prompt once
+	reauthGeneration int
+)
+
+// reauthCredential wraps a credential so that an expired sign-in is renewed
+// where the request is made. The wizard keeps its progress: the request
+// that failed is retried once the user has signed in again.
+type reauthCredential struct {
+	tenantID string
+
+	mu   sync.Mutex
+	cred azcore.TokenCredential
+}
+
+// GetToken implements azcore.TokenCredential
+func (c *reauthCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.mu.Lock()
+	cred := c.cred
+	c.mu.Unlock()
+	reauthMu.Lock()
+	generation := reauthGeneration
+	reauthMu.Unlock()
+
+	token, err := cred.GetToken(ctx, options)
+	if err == nil {
+		rememberIdentity(token)
+		return token, nil
+	}
+	authErr := classifyAuthError(err)
+	if authErr == nil {
+		return token, err
+	}
+
+	reauthMu.Lock()
+	defer reauthMu.Unlock()
+
+	// Another request signed in again meanwhile; a new credential picks it up
+	if reauthGeneration == generation {
+		if ctx.Value(reauthContextKey{}) != nil {
+			return token, authErr
+		}
+		// First try a fresh credential silently, which is all workload and
+		// managed identities need. Interactive sources would prompt here.
+		if source := currentAuthSource(); source != authDeviceCode && source != authBrowser {
+			if token, err := c.renew(ctx, options); err == nil {
+				reauthGeneration++
+				return token, nil
+			}
+		}
+		if err := promptReauthentication(authErr, c.tenantID); err != nil {
+			return token, err
+		}
+		reauthGeneration++
+	}
+
+	return c.renew(ctx, options)
+}
+
+// renew replaces the wrapped credential and requests a token with it
+func (c *reauthCredential) renew(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	cred, err := newSourceCredential(c.tenantID)
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	c.mu.Lock()
+	c.cred = cred
+	c.mu.Unlock()
+
+	token, err := cred.GetToken(ctx, options)
+	if err != nil {
+		if authErr := classifyAuthError(err); authErr != nil {
+			return token, authErr
+		}
+		return token, err
+	}
+	rememberIdentity(token)
+	return token, nil
+}
+
+// promptReauthentication explains an authentication failure and lets the
+// user sign in again. Sources that sign in through the Azure CLI run
+// az login; device code and browser sign-ins happen on the next request.
+func promptReauthentication(authErr *AuthError, tenantID string) error {
+	source := currentAuthSource()
+	if source == authEnvironment || source == authWorkloadIdentity || source == authManagedIdentity {
+		return authErr
+	}
+
+	fmt.Printf("\n%v\n", authErr)
+	const signIn = "Sign in again"
+	const giveUp = "Cancel"
+	selected, err := utils.SelectWithMenu([]string{signIn, giveUp}, "Azure sign-in required")
+	if err != nil || selected == giveUp {
+		return authErr
+	}
+
+	if authErr.Source == "az" || source == authDefault || source == authAzureCLI {
+		if err := azLogin(tenantID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// azLogin runs az login for the credential source in use, showing its output
+func azLogin(tenantID string) error {
+	args, cleanup, err := azLoginArgs(tenantID)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	cmd := utils.PrepareAzureCommand(args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("azure login failed: %v", err)
+	}
+	return nil
+}
+
+// azAuthFailureWindow is how soon after starting an az connection a failure
+// is checked for an expired sign-in; later failures end a session that worked
+const azAuthFailureWindow = 30 * time.Second
+
+// runAzConnection runs an az-backed connection. When it fails right away
+// because the Azure CLI sign-in expired, the user can sign in again and the
+// connection is retried once.
+func runAzConnection(tenantID string, run func() error) error {
+	started := time.Now()
+	err := run()
+	if err == nil || time.Since(started) > azAuthFailureWindow {
+		return err
+	}
+
+	// az writes straight to the terminal, so rather than parsing its output
+	// ask it for a token to find out whether the sign-in is the problem
+	authErr := checkAzToken(tenantID)
+	if authErr == nil {
+		return err
+	}
+
+	reauthMu.Lock()
+	promptErr := promptReauthentication(authErr, tenantID)
+	if promptErr == nil {
+		reauthGeneration++
+	}
+	reauthMu.Unlock()
+	if promptErr != nil {
+		return promptErr
+	}
+	return run()
+}
+
+// checkAzToken asks the Azure CLI for a token and classifies a failure
+func checkAzToken(tenantID string) *AuthError {
+	args := []string{"account", "get-access-token", "--output", "none"}
+	if tenantID != "" {
+		args = append(args, "--tenant", tenantID)
+	}
+	output, err := utils.PrepareAzureCommand(args...).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if authErr := classifyAzOutput(string(output)); authErr != nil {
+		return authErr
+	}
+	return &AuthError{Kind: authNotSignedIn, Source: "az", Err: fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))}
+}
+
+// Identity is the signed-in identity and when its current token expires
+type Identity struct {
+	// Name is the user principal name, or the application of a service principal
+	Name      string
+	TenantID  string
+	ObjectID  string
+	ExpiresOn time.Time
+}
+
+var (
+	identityMu   sync.Mutex
+	lastIdentity *Identity
+)
+
+// rememberIdentity records who a token was issued to, read from its claims
+func rememberIdentity(token azcore.AccessToken) {
+	parts := strings.Split(token.Token, ".")
+	if len(parts) != 3 {
+		return
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return
+	}
+	var claims struct {
+		UPN               string `json:"upn"`
+		PreferredUsername string `json:"preferred_username"`
+		UniqueName        string `json:"unique_name"`
+		AppDisplayName    string `json:"app_displayname"`
+		AppID             string `json:"appid"`
+		ObjectID          string `json:"oid"`
+		TenantID          string `json:"tid"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return
+	}
+
+	identity := &Identity{TenantID: claims.TenantID, ObjectID: claims.ObjectID, ExpiresOn: token.ExpiresOn}
+	for _, name := range []string{claims.UPN, claims.PreferredUsername, claims.UniqueName, claims.AppDisplayName, claims.AppID, claims.ObjectID} {
+		if name != "" {
+			identity.Name = name
+			break
+		}
+	}
+
+	identityMu.Lock()
+	lastIdentity = identity
+	identityMu.Unlock()
+}
+
+// CurrentIdentity returns the signed-in identity for the welcome screen. A
+// token is only requested when the last one is about to expire, and never
+// with a prompt: sources that sign in interactively report nothing until
+// they have been used.
+func CurrentIdentity() (*Identity, error) {
+	identityMu.Lock()
+	identity := lastIdentity
+	identityMu.Unlock()
+	if identity != nil && time.Until(identity.ExpiresOn) > 5*time.Minute {
+		return identity, nil
+	}
+	if offlineMode {
+		return identity, nil
+	}
+	if source := currentAuthSource(); source == authDeviceCode || source == authBrowser {
+		return identity, nil
+	}
+
+	cred, err := GetAzureCredential()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(withoutReauthPrompt(context.Background()), 30*time.Second)
+	defer cancel()
+	if _, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{activeCloud().scope()}}); err != nil {
+		return nil, err
+	}
+
+	identityMu.Lock()
+	defer identityMu.Unlock()
+	return lastIdentity, nil
+}
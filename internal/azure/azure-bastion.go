@@ -6,15 +6,39 @@ import (
 	"os"
 	"strings"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/antnsn/BastionBuddy/internal/config"
 	"github.com/antnsn/BastionBuddy/internal/utils"
 )
 
 // GetBastionDetails retrieves the Bastion host details either through
-// manual input or by selecting from available hosts.
-func GetBastionDetails(ctx context.Context, cred *azidentity.DefaultAzureCredential, subscriptionID string) (*config.BastionHost, error) {
+// manual input or by selecting from available hosts. With a target virtual
+// machine, the host named by its bastionbuddy-bastion tag and then the hosts
+// that can reach it through its network are offered first.
+// Hosts whose SKU or features can't serve the connection type are hidden.
+// When subscriptionID is empty, it is asked for only if needed.
+func GetBastionDetails(ctx context.Context, cred azcore.TokenCredential, subscriptionID string, target *config.TargetResource, connectionType ConnectionType) (*config.BastionHost, error) {
+	host, err := selectBastionHost(ctx, cred, subscriptionID, target, connectionType)
+	if host != nil && host.TenantID == "" {
+		host.TenantID = currentTenantID()
+	}
+	return host, err
+}
+
+// selectBastionHost offers the ways to pick a Bastion host described at GetBastionDetails
+func selectBastionHost(ctx context.Context, cred azcore.TokenCredential, subscriptionID string, target *config.TargetResource, connectionType ConnectionType) (*config.BastionHost, error) {
+	host, err := selectTaggedBastion(target, connectionType)
+	if err != nil || host != nil {
+		return host, err
+	}
+
+	if target != nil && strings.EqualFold(target.Type, virtualMachineType) {
+		host, err = selectReachableBastion(ctx, cred, target, connectionType)
+		if err != nil || host != nil {
+			return host, err
+		}
+	}
+
 	selectionMethod, err := utils.SelectWithMenu([]string{"auto-select-host", "manual-input(resource-id)"}, "How would you like to specify the Bastion host?")
 	if err != nil {
 		if strings.Contains(err.Error(), "cancelled by user") {
@@ -26,120 +50,173 @@ func GetBastionDetails(ctx context.Context, cred *azidentity.DefaultAzureCredent
 
 	switch selectionMethod {
 	case "auto-select-host":
-		return GetBastionSelection(ctx, cred, subscriptionID)
+		if subscriptionID == "" {
+			subscriptionID, err = getSubscriptionID(ctx, cred, "Select Azure subscription for Bastion host")
+			if err != nil {
+				return nil, err
+			}
+		}
+		return GetBastionSelection(ctx, cred, subscriptionID, connectionType, target != nil && target.IsIP())
 	case "manual-input(resource-id)":
-		return GetBastionManualInput()
+		return GetBastionManualInput(ctx, cred)
 	default:
 		return nil, fmt.Errorf("invalid selection method: %s", selectionMethod)
 	}
 }
 
-// GetBastionManualInput prompts the user to manually input Bastion host details.
-func GetBastionManualInput() (*config.BastionHost, error) {
-	name, err := utils.ReadInput("Enter Bastion host name")
+// selectReachableBastion offers the Bastion hosts that can reach the target
+// through its virtual network or a peered one. It returns nil without an
+// error when the user wants to pick a host another way.
+func selectReachableBastion(ctx context.Context, cred azcore.TokenCredential, target *config.TargetResource, connectionType ConnectionType) (*config.BastionHost, error) {
+	fmt.Println("Looking for Bastion hosts that can reach the target...")
+	candidates, explanation, err := reachableBastions(ctx, cred, target)
+	if err != nil {
+		fmt.Printf("Could not resolve the network of %s: %v\n", target.Name, err)
+		return nil, nil
+	}
+	if len(candidates) == 0 {
+		fmt.Printf("Note: %s\n", explanation)
+		return nil, nil
+	}
+
+	var choices []bastionChoice
+	for _, candidate := range candidates {
+		host := candidate.Host
+		choices = append(choices, bastionChoice{
+			label:       candidate.label(),
+			host:        &host,
+			unsupported: candidate.Record.features().supports(connectionType, false),
+		})
+	}
+
+	const otherHost = "Choose another Bastion host"
+	return selectBastionChoice(choices, connectionType, fmt.Sprintf("Select Bastion host that can reach %s", target.Name), otherHost)
+}
+
+// bastionChoice is an entry in a Bastion picker
+type bastionChoice struct {
+	label string
+	host  *config.BastionHost
+	// unsupported explains why the host can't serve the connection, if it can't
+	unsupported error
+}
+
+// selectBastionChoice shows the hosts that support the connection type,
+// mentioning the hidden ones. If none does, all hosts are shown with a
+// warning. Selecting one of the extra items returns nil.
+func selectBastionChoice(choices []bastionChoice, connectionType ConnectionType, prompt string, extra ...string) (*config.BastionHost, error) {
+	var supported, unsupported []bastionChoice
+	for _, choice := range choices {
+		if choice.unsupported == nil {
+			supported = append(supported, choice)
+		} else {
+			unsupported = append(unsupported, choice)
+		}
+	}
+
+	shown := supported
+	if len(supported) == 0 {
+		fmt.Printf("Warning: none of the Bastion hosts supports %s connections; the connection will likely fail\n", connectionName(connectionType))
+		shown = choices
+	} else {
+		for _, choice := range unsupported {
+			fmt.Printf("Hiding %s: %v\n", choice.host.Name, choice.unsupported)
+		}
+	}
+
+	var items []string
+	hostMap := make(map[string]*config.BastionHost)
+	for _, choice := range shown {
+		item := choice.label
+		if choice.unsupported != nil {
+			item += " [unsupported]"
+		}
+		items = append(items, item)
+		hostMap[item] = choice.host
+	}
+	items = append(items, extra...)
+
+	selected, err := utils.SelectWithMenu(items, prompt)
 	if err != nil {
 		if strings.Contains(err.Error(), "cancelled by user") {
 			fmt.Println("\nOperation cancelled by user")
 			os.Exit(0)
 		}
-		return nil, fmt.Errorf("failed to read Bastion host name: %v", err)
-	}
-	if name == "" {
-		return nil, fmt.Errorf("bastion host name cannot be empty")
+		return nil, fmt.Errorf("failed to select Bastion host: %v", err)
 	}
+	return hostMap[selected], nil
+}
 
-	resourceGroup, err := utils.ReadInput("Enter Bastion resource group")
+// GetBastionManualInput prompts for a Bastion host resource ID or portal URL
+// and fills in the host's name, resource group and subscription from it.
+func GetBastionManualInput(ctx context.Context, cred azcore.TokenCredential) (*config.BastionHost, error) {
+	input, err := utils.ReadInput("Enter Bastion host resource ID or portal URL")
 	if err != nil {
 		if strings.Contains(err.Error(), "cancelled by user") {
 			fmt.Println("\nOperation cancelled by user")
 			os.Exit(0)
 		}
-		return nil, fmt.Errorf("failed to read resource group: %v", err)
+		return nil, fmt.Errorf("failed to read Bastion host resource ID: %v", err)
+	}
+
+	id, err := parseResourceIDOfType(input, bastionHostType)
+	if err != nil {
+		return nil, err
 	}
-	if resourceGroup == "" {
-		return nil, fmt.Errorf("resource group cannot be empty")
+	if err := confirmResourceExists(ctx, cred, id, networkAPIVersion); err != nil {
+		return nil, err
 	}
 
 	return &config.BastionHost{
-		Name:          name,
-		ResourceGroup: resourceGroup,
+		Name:           id.Name,
+		ResourceGroup:  id.ResourceGroup,
+		SubscriptionID: id.SubscriptionID,
 	}, nil
 }
 
-// GetBastionSelection retrieves available Bastion hosts and lets the user select one.
-func GetBastionSelection(ctx context.Context, cred *azidentity.DefaultAzureCredential, subscriptionID string) (*config.BastionHost, error) {
+// GetBastionSelection retrieves available Bastion hosts with their SKU and
+// features and lets the user select one that supports the connection type
+// and, for IP targets, IP-based connection.
+func GetBastionSelection(ctx context.Context, cred azcore.TokenCredential, subscriptionID string, connectionType ConnectionType, ipConnect bool) (*config.BastionHost, error) {
 	debugPrintf("Fetching Bastion hosts...")
 
-	// Create resources client
-	resourceClient, err := armresources.NewClient(subscriptionID, cred, nil)
+	resources, err := listResources(ctx, cred, subscriptionID, bastionHostType)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create resources client: %v", err)
-	}
-
-	// List all Bastion hosts using resource client
-	filter := "resourceType eq 'Microsoft.Network/bastionHosts'"
-	debugPrintf("Using filter: %s\n", filter)
-	pager := resourceClient.NewListPager(&armresources.ClientListOptions{
-		Filter: &filter,
-	})
-
-	var resources []*armresources.GenericResourceExpanded
-	pageCount := 0
-	for pager.More() {
-		pageCount++
-		debugPrintf("Fetching page %d...\n", pageCount)
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to list Bastion hosts: %v", err)
-		}
-
-		debugPrintf("Found %d resources on page %d\n", len(page.Value), pageCount)
-		resources = append(resources, page.Value...)
+		return nil, fmt.Errorf("failed to list Bastion hosts: %v", err)
 	}
 
 	if len(resources) == 0 {
 		return nil, fmt.Errorf("no Bastion hosts found in subscription")
 	}
 
-	var items []string
-	bastionMap := make(map[string]*config.BastionHost)
-
+	var choices []bastionChoice
 	for _, resource := range resources {
-		if resource.Name == nil {
+		if resource.Name == nil || resource.ID == nil {
 			continue
 		}
 
-		// Extract resource group from ID
-		parts := strings.Split(*resource.ID, "/")
-		var resourceGroup string
-		for i, part := range parts {
-			if strings.EqualFold(part, "resourceGroups") && i+1 < len(parts) {
-				resourceGroup = parts[i+1]
-				break
-			}
-		}
+		resourceGroup := resourceGroupFromID(*resource.ID)
 		if resourceGroup == "" {
 			debugPrintf("Could not extract resource group from ID: %s\n", *resource.ID)
 			continue
 		}
 
-		item := fmt.Sprintf("%s (%s)", *resource.Name, resourceGroup)
-		items = append(items, item)
-		bastionMap[item] = &config.BastionHost{
-			Name:           *resource.Name,
-			ResourceGroup:  resourceGroup,
-			SubscriptionID: subscriptionID,
+		choice := bastionChoice{
+			label: fmt.Sprintf("%s (%s)", *resource.Name, resourceGroup),
+			host: &config.BastionHost{
+				Name:           *resource.Name,
+				ResourceGroup:  resourceGroup,
+				SubscriptionID: subscriptionID,
+			},
 		}
-	}
-
-	selected, err := utils.SelectWithMenu(items, "Select Bastion host")
-	if err != nil {
-		if strings.Contains(err.Error(), "cancelled by user") {
-			fmt.Println("\nOperation cancelled by user")
-			os.Exit(0)
+		if features, err := getBastionFeatures(ctx, cred, *resource.ID); err != nil {
+			debugPrintf("Could not read features of %s: %v\n", *resource.Name, err)
+		} else {
+			choice.label += " | " + features.String()
+			choice.unsupported = features.supports(connectionType, ipConnect)
 		}
-		return nil, fmt.Errorf("failed to select Bastion host: %v", err)
+		choices = append(choices, choice)
 	}
 
-	return bastionMap[selected], nil
+	return selectBastionChoice(choices, connectionType, "Select Bastion host")
 }
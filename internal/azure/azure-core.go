@@ -3,11 +3,13 @@ package azure
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"runtime"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/subscription/armsubscription"
 	"github.com/antnsn/BastionBuddy/internal/config"
 	"github.com/antnsn/BastionBuddy/internal/tunnels"
@@ -19,22 +21,9 @@ func init() {
 	// Initialize other things if needed, but don't check auth here
 }
 
-// ensureAuthenticated ensures the user is logged in to Azure
+// ensureAuthenticated ensures Azure SDK credentials are available. The Azure
+// CLI login is checked separately, when a connection runs az.
 func ensureAuthenticated() error {
-	// First check if we're logged into Azure CLI
-	isLoggedIn, err := utils.CheckAzureLogin()
-	if err != nil {
-		return fmt.Errorf("failed to check Azure login status: %v", err)
-	}
-
-	// If not logged in, start the login process
-	if !isLoggedIn {
-		if err := utils.LoginToAzure(); err != nil {
-			return fmt.Errorf("failed to login to Azure: %v", err)
-		}
-	}
-
-	// Now check Azure SDK credentials
 	cred, err := GetAzureCredential()
 	if err != nil {
 		return fmt.Errorf("authentication failed: %v", err)
@@ -52,12 +41,26 @@ func CheckDependencies() error {
 
 // Cleanup performs any necessary cleanup operations.
 func Cleanup() error {
+	// Give background cache refreshes a moment to finish writing
+	waitForRefreshes(5 * time.Second)
 	return nil
 }
 
-// getSubscriptions retrieves available Azure subscriptions using the Azure SDK
-func getSubscriptions(ctx context.Context, cred *azidentity.DefaultAzureCredential) ([]*armsubscription.Subscription, error) {
-	client, err := armsubscription.NewSubscriptionsClient(cred, nil)
+// getSubscriptions retrieves the subscriptions of the tenant in use, using the discovery cache
+func getSubscriptions(ctx context.Context, cred azcore.TokenCredential) ([]*armsubscription.Subscription, error) {
+	return tenantSubscriptions(ctx, currentTenantID(), cred)
+}
+
+// tenantSubscriptions retrieves the subscriptions of a tenant, using the discovery cache
+func tenantSubscriptions(ctx context.Context, tenantID string, cred azcore.TokenCredential) ([]*armsubscription.Subscription, error) {
+	return cachedTenantDiscovery(ctx, tenantID, "", "subscriptions", func(ctx context.Context) ([]*armsubscription.Subscription, error) {
+		return fetchSubscriptions(ctx, cred)
+	})
+}
+
+// fetchSubscriptions retrieves available Azure subscriptions using the Azure SDK
+func fetchSubscriptions(ctx context.Context, cred azcore.TokenCredential) ([]*armsubscription.Subscription, error) {
+	client, err := armsubscription.NewSubscriptionsClient(cred, armClientOptions())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create subscriptions client: %v", err)
 	}
@@ -86,7 +89,10 @@ func getSubscriptions(ctx context.Context, cred *azidentity.DefaultAzureCredenti
 }
 
 // SelectConnectionType prompts the user to select the type of connection.
-func SelectConnectionType() (ConnectionType, error) {
+// When the target's OS type is known, the matching type is offered first:
+// RDP for Windows where this host can run it, otherwise SSH for Linux and a
+// tunnel for Windows.
+func SelectConnectionType(target *config.TargetResource) (ConnectionType, error) {
 	var items []string
 	items = append(items, string(SSH))
 	if runtime.GOOS == "windows" {
@@ -94,6 +100,20 @@ func SelectConnectionType() (ConnectionType, error) {
 	}
 	items = append(items, string(Tunnel))
 
+	if target != nil && strings.EqualFold(target.OSType, "Windows") {
+		preferred := string(Tunnel)
+		if runtime.GOOS == "windows" {
+			preferred = string(RDP)
+		}
+		ordered := []string{preferred}
+		for _, item := range items {
+			if item != preferred {
+				ordered = append(ordered, item)
+			}
+		}
+		items = ordered
+	}
+
 	selected, err := utils.SelectWithMenu(items, "Select connection type")
 	if err != nil {
 		return "", fmt.Errorf("failed to select connection type: %v", err)
@@ -101,6 +121,22 @@ func SelectConnectionType() (ConnectionType, error) {
 	return ConnectionType(selected), nil
 }
 
+// suggestedRemotePort returns the port a tunnel to the target most likely
+// wants, from its OS type, or 0 when unknown
+func suggestedRemotePort(target *config.TargetResource) int {
+	if target == nil {
+		return 0
+	}
+	switch strings.ToLower(target.OSType) {
+	case "linux":
+		return 22
+	case "windows":
+		return 3389
+	default:
+		return 0
+	}
+}
+
 // GetAzureResources retrieves the necessary Azure resource configuration.
 func GetAzureResources() (*config.ResourceConfig, error) {
 	if err := ensureAuthenticated(); err != nil {
@@ -115,25 +151,25 @@ func GetAzureResources() (*config.ResourceConfig, error) {
 
 	// Get subscription ID
 	ctx := context.Background()
-	subID, err := getSubscriptionID(ctx, cred, "Select Azure subscription for Bastion host")
+	subID, err := getSubscriptionID(ctx, cred, "Select Azure subscription")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get subscription ID: %v", err)
 	}
 
-	// Get Bastion host details
-	bastionHost, err := GetBastionDetails(ctx, cred, subID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get Bastion host details: %v", err)
-	}
-
 	// Get target resource details
 	targetResource, err := GetTargetResource(ctx, cred, subID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get target resource details: %v", err)
 	}
 
+	// Get Bastion host details, preferring hosts that can reach the target
+	bastionHost, err := GetBastionDetails(ctx, cred, subID, targetResource, SSH)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Bastion host details: %v", err)
+	}
+
 	// Get username
-	username, err := utils.ReadInput("Enter username")
+	username, err := readUsername("Enter username", targetResource)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read username: %v", err)
 	}
@@ -158,60 +194,72 @@ func InitiateConnection() error {
 	for {
 		// Step 1: User has already selected Connect to get here
 
-		// Step 2: Get connection type (SSH/RDP/Tunnel)
-		connectionType, err := SelectConnectionType()
+		// Step 2: Get target resource details, selecting its subscription
+		// unless the user searches all subscriptions
+		ctx := context.Background()
+		cred, err := GetAzureCredential()
+		if err != nil {
+			return fmt.Errorf("failed to create credentials: %v", err)
+		}
+
+		targetResource, err := GetTargetResource(ctx, cred, "")
 		if err == utils.ErrReturnToMain {
 			return nil // Return to main menu
 		}
 		if err != nil {
-			return fmt.Errorf("failed to select connection type: %v", err)
+			return fmt.Errorf("failed to get target resource: %v", err)
 		}
 
-		// Step 3: Select subscription for Bastion host
-		ctx := context.Background()
-		cred, err := azidentity.NewDefaultAzureCredential(nil)
-		if err != nil {
+		// The target may be in another tenant, which the rest of the flow uses
+		if cred, err = GetAzureCredential(); err != nil {
 			return fmt.Errorf("failed to create credentials: %v", err)
 		}
 
-		bastionSubscriptionID, err := getSubscriptionID(ctx, cred, "Select Azure subscription for Bastion host")
+		// Step 3: Get connection type (SSH/RDP/Tunnel), offering the one that
+		// suits the target's OS first
+		connectionType, err := SelectConnectionType(targetResource)
 		if err == utils.ErrReturnToMain {
 			return nil // Return to main menu
 		}
 		if err != nil {
-			return fmt.Errorf("failed to select Bastion subscription: %v", err)
+			return fmt.Errorf("failed to select connection type: %v", err)
 		}
 
-		// Step 4: Get Bastion host details
-		bastionHost, err := GetBastionDetails(ctx, cred, bastionSubscriptionID)
+		// Step 4: Get Bastion host details, offering the hosts whose network
+		// reaches the target before asking for a subscription
+		bastionHost, err := GetBastionDetails(ctx, cred, "", targetResource, connectionType)
+		if err == utils.ErrReturnToMain {
+			return nil // Return to main menu
+		}
 		if err != nil {
 			return fmt.Errorf("failed to get Bastion details: %v", err)
 		}
 
-		// Step 5: Select target machine subscription
-		targetSubscriptionID, err := getSubscriptionID(ctx, cred, "Select Azure subscription for target resource")
+		// Step 4.1: Connect, or manage the host's active sessions first
+		err = selectBastionAction(ctx, cred, bastionHost, connectionType)
 		if err == utils.ErrReturnToMain {
 			return nil // Return to main menu
 		}
 		if err != nil {
-			return fmt.Errorf("failed to select target subscription: %v", err)
+			return err
 		}
 
-		// Step 6: Get target resource details
-		targetResource, err := GetTargetResource(ctx, cred, targetSubscriptionID)
+		// Step 5: Make sure the target VM is running
+		stopVM, err := prepareTargetVM(targetResource, false)
 		if err != nil {
-			return fmt.Errorf("failed to get target resource: %v", err)
+			return err
 		}
 
-		// Step 7: Get port configurations based on connection type
+		// Step 6: Get port configurations based on connection type
 		config := &config.ResourceConfig{
-			BastionHost:    bastionHost,
-			TargetResource: targetResource,
+			BastionHost:        bastionHost,
+			TargetResource:     targetResource,
+			StopVMOnDisconnect: stopVM,
 		}
 
 		// Get username for SSH connections
 		if connectionType == SSH {
-			username, err := utils.ReadInput("Enter username for SSH connection")
+			username, err := readUsername("Enter username for SSH connection", targetResource)
 			if err != nil {
 				return fmt.Errorf("failed to get username: %v", err)
 			}
@@ -222,31 +270,14 @@ func InitiateConnection() error {
 		}
 
 		if connectionType == Tunnel {
-			var defaultRemotePort int
-			switch connectionType {
-			case SSH:
-				defaultRemotePort = 22
-			case RDP:
-				defaultRemotePort = 3389
-			default:
-				defaultRemotePort = 0
-			}
-
-			// Step 7.1.1: Get target port
-			portPrompt := "Enter target resource port (e.g., 22 for SSH, 3389 for RDP, 80 for HTTP, 443 for HTTPS)"
-			if defaultRemotePort > 0 {
-				portPrompt = fmt.Sprintf("Enter target resource port (default: %d)", defaultRemotePort)
-			}
-			remotePort, err := utils.GetUserInputInt(portPrompt)
+			// Step 6.1.1: Get target port
+			remotePort, err := selectRemotePort(targetResource)
 			if err != nil {
 				return fmt.Errorf("failed to get remote port: %v", err)
 			}
-			if remotePort == 0 && defaultRemotePort > 0 {
-				remotePort = defaultRemotePort
-			}
 			config.RemotePort = remotePort
 
-			// Step 7.1.2: Get local port
+			// Step 6.1.2: Get local port
 			localPortPrompt := fmt.Sprintf("Enter local port (e.g., %d to match remote port, or any available local port)", remotePort)
 			localPort, err := utils.GetUserInputInt(localPortPrompt)
 			if err != nil {
@@ -260,6 +291,10 @@ func InitiateConnection() error {
 				SubscriptionID:        config.TargetResource.SubscriptionID,
 				ResourceID:            config.TargetResource.ID,
 				ResourceName:          config.TargetResource.Name,
+				TargetKind:            targetKind(config.TargetResource),
+				TargetIP:              config.TargetResource.IPAddress,
+				TenantID:              config.TargetResource.TenantID,
+				Cloud:                 cloudSetting(),
 				LocalPort:             config.LocalPort,
 				RemotePort:            config.RemotePort,
 				Command:               "",
@@ -309,7 +344,7 @@ func establishConnection(connectionType ConnectionType, config *config.ResourceC
 
 	switch connectionType {
 	case SSH:
-		if err := connectSSH(config, ""); err != nil {
+		if err := withVMStop(config, func() error { return connectSSH(config, nil) }); err != nil {
 			return err
 		}
 	case Tunnel:
@@ -318,6 +353,10 @@ func establishConnection(connectionType ConnectionType, config *config.ResourceC
 			SubscriptionID:        config.TargetResource.SubscriptionID,
 			ResourceID:            config.TargetResource.ID,
 			ResourceName:          config.TargetResource.Name,
+			TargetKind:            targetKind(config.TargetResource),
+			TargetIP:              config.TargetResource.IPAddress,
+			TenantID:              config.TargetResource.TenantID,
+			Cloud:                 cloudSetting(),
 			LocalPort:             config.LocalPort,
 			RemotePort:            config.RemotePort,
 			Command:               "",
@@ -333,7 +372,7 @@ func establishConnection(connectionType ConnectionType, config *config.ResourceC
 			return err
 		}
 	case RDP:
-		if err := connectRDP(config, nil); err != nil {
+		if err := withVMStop(config, func() error { return connectRDP(config, nil) }); err != nil {
 			return err
 		}
 	default:
@@ -343,7 +382,7 @@ func establishConnection(connectionType ConnectionType, config *config.ResourceC
 	return nil
 }
 
-func connectSSH(config *config.ResourceConfig, savedAuthType string) error {
+func connectSSH(config *config.ResourceConfig, savedConfig *tunnels.Config) error {
 	if config == nil {
 		return fmt.Errorf("no configuration provided")
 	}
@@ -352,18 +391,22 @@ func connectSSH(config *config.ResourceConfig, savedAuthType string) error {
 		return fmt.Errorf("username is required")
 	}
 
+	if err := ensureAzLogin(config.TargetResource.TenantID); err != nil {
+		return err
+	}
+
 	var authType string
 
-	if savedAuthType != "" {
+	if savedConfig != nil && savedConfig.AuthType != "" {
 		// Use the saved auth type
-		authType = savedAuthType
+		authType = savedConfig.AuthType
 	} else {
 		// Let user select auth type for new connections
-		authType, _ = utils.SelectWithMenu([]string{"AAD", "password"}, "Select authentication type")
+		authType, _ = utils.SelectWithMenu(authTypeItems(config.TargetResource), "Select authentication type")
 	}
 
 	// Save the SSH configuration only if it's a new connection
-	if savedAuthType == "" {
+	if savedConfig == nil {
 		manager, err := GetTunnelManager()
 		if err != nil {
 			return fmt.Errorf("failed to get tunnel manager: %v", err)
@@ -374,6 +417,10 @@ func connectSSH(config *config.ResourceConfig, savedAuthType string) error {
 			SubscriptionID:        config.TargetResource.SubscriptionID,
 			ResourceID:            config.TargetResource.ID,
 			ResourceName:          config.TargetResource.Name,
+			TargetKind:            targetKind(config.TargetResource),
+			TargetIP:              config.TargetResource.IPAddress,
+			TenantID:              config.TargetResource.TenantID,
+			Cloud:                 cloudSetting(),
 			BastionName:           config.BastionHost.Name,
 			BastionResourceGroup:  config.BastionHost.ResourceGroup,
 			BastionSubscriptionID: config.BastionHost.SubscriptionID,
@@ -393,12 +440,73 @@ func connectSSH(config *config.ResourceConfig, savedAuthType string) error {
 		"--subscription", config.BastionHost.SubscriptionID,
 		"--resource-group", config.BastionHost.ResourceGroup,
 		"--name", config.BastionHost.Name,
-		"--target-resource-id", config.TargetResource.ID,
-		"--auth-type", authType,
-		"--username", config.Username,
+	}
+	args = append(args, config.TargetResource.TargetArgs()...)
+	args = append(args, "--auth-type", authType, "--username", config.Username)
+
+	// Fetch credentials from Key Vault at connect time, keeping them off disk
+	if savedConfig != nil && savedConfig.KeyVaultSecret != nil {
+		ref := savedConfig.KeyVaultSecret
+		value, err := readKeyVaultSecret(ref)
+		if err != nil {
+			return fmt.Errorf("failed to read Key Vault secret %s/%s: %v", ref.Vault, ref.Secret, err)
+		}
+
+		switch ref.Kind {
+		case "ssh-key":
+			publicKeyFile, cleanup, err := utils.AddKeyToAgent([]byte(value), 5*time.Minute)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			args = replaceArg(args, "--auth-type", "ssh-key")
+			args = append(args, "--ssh-key", publicKeyFile)
+			return runAzConnection(config.TargetResource.TenantID, func() error {
+				return utils.AzureInteractiveCommand(args...)
+			})
+		default:
+			env, cleanup, err := utils.AskPassEnv(value)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			args = replaceArg(args, "--auth-type", "password")
+			return runAzConnection(config.TargetResource.TenantID, func() error {
+				return utils.AzureInteractiveCommandWithEnv(env, args...)
+			})
+		}
 	}
 
-	return utils.AzureInteractiveCommand(args...)
+	// Feed a stored password to ssh instead of prompting for it
+	if authType == "password" && savedConfig != nil && savedConfig.PasswordSecret != "" {
+		password, err := readSecret(savedConfig.PasswordSecret)
+		if err != nil {
+			return fmt.Errorf("failed to read password secret '%s': %v", savedConfig.PasswordSecret, err)
+		}
+		env, cleanup, err := utils.AskPassEnv(password)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		return runAzConnection(config.TargetResource.TenantID, func() error {
+			return utils.AzureInteractiveCommandWithEnv(env, args...)
+		})
+	}
+
+	return runAzConnection(config.TargetResource.TenantID, func() error {
+		return utils.AzureInteractiveCommand(args...)
+	})
+}
+
+// replaceArg replaces the value following flag in an az argument list
+func replaceArg(args []string, flag string, value string) []string {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag {
+			args[i+1] = value
+			return args
+		}
+	}
+	return append(args, flag, value)
 }
 
 func connectRDP(config *config.ResourceConfig, savedConfig *tunnels.Config) error {
@@ -414,6 +522,10 @@ func connectRDP(config *config.ResourceConfig, savedConfig *tunnels.Config) erro
 		return fmt.Errorf("username is required")
 	}
 
+	if err := ensureAzLogin(config.TargetResource.TenantID); err != nil {
+		return err
+	}
+
 	var enableMFA bool
 
 	if savedConfig != nil {
@@ -435,6 +547,10 @@ func connectRDP(config *config.ResourceConfig, savedConfig *tunnels.Config) erro
 			SubscriptionID:        config.TargetResource.SubscriptionID,
 			ResourceID:            config.TargetResource.ID,
 			ResourceName:          config.TargetResource.Name,
+			TargetKind:            targetKind(config.TargetResource),
+			TargetIP:              config.TargetResource.IPAddress,
+			TenantID:              config.TargetResource.TenantID,
+			Cloud:                 cloudSetting(),
 			BastionName:           config.BastionHost.Name,
 			BastionResourceGroup:  config.BastionHost.ResourceGroup,
 			BastionSubscriptionID: config.BastionHost.SubscriptionID,
@@ -454,29 +570,32 @@ func connectRDP(config *config.ResourceConfig, savedConfig *tunnels.Config) erro
 		"--subscription", config.BastionHost.SubscriptionID,
 		"--resource-group", config.BastionHost.ResourceGroup,
 		"--name", config.BastionHost.Name,
-		"--target-resource-id", config.TargetResource.ID,
-		"--auth-type", "AAD",
-		"--username", config.Username,
 	}
+	args = append(args, config.TargetResource.TargetArgs()...)
+	args = append(args, "--auth-type", "AAD", "--username", config.Username)
 
 	if enableMFA {
 		args = append(args, "--enable-mfa")
 	}
 
-	return utils.AzureInteractiveCommand(args...)
+	return runAzConnection(config.TargetResource.TenantID, func() error {
+		return utils.AzureInteractiveCommand(args...)
+	})
 }
 
 // SelectInitialAction prompts the user to select the initial action
 func SelectInitialAction() (string, error) {
 	items := []string{"Create new connection"}
 
-	// Only show manage-tunnels if there are active tunnels
+	// Only show saved configurations and manage-tunnels when there is something to show
 	if manager, err := GetTunnelManager(); err == nil {
+		if len(manager.GetSavedConfigs()) > 0 {
+			items = append(items, "Connect to saved configuration")
+		}
 		if len(manager.ListTunnels()) > 0 {
 			items = append(items, "Manage active tunnels")
 		}
 	}
-
 	items = append(items, "Exit BastionBuddy")
 
 	action, err := utils.SelectWithMenu(items, "What would you like to do?")
@@ -488,6 +607,8 @@ func SelectInitialAction() (string, error) {
 	switch action {
 	case "Create new connection":
 		return "connect", nil
+	case "Connect to saved configuration":
+		return "saved", nil
 	case "Manage active tunnels":
 		return "manage-tunnels", nil
 	case "Exit BastionBuddy":
@@ -513,6 +634,18 @@ func handleInitialAction(action string, _ *config.ResourceConfig) error {
 				continue
 			}
 			return err
+		case "saved":
+			err := connectSavedConfiguration()
+			if err == utils.ErrReturnToMain {
+				// Get a new action selection
+				newAction, err := SelectInitialAction()
+				if err != nil {
+					return err
+				}
+				action = newAction
+				continue
+			}
+			return err
 		case "manage-tunnels":
 			err := manageTunnels()
 			if err == utils.ErrReturnToMain {
@@ -581,36 +714,63 @@ func InitiateAction(action string, config *config.ResourceConfig) error {
 }
 
 // getSubscriptionID retrieves the subscription ID from the Azure CLI.
-func getSubscriptionID(ctx context.Context, cred *azidentity.DefaultAzureCredential, prompt string) (string, error) {
-	// Get the list of subscriptions
+func getSubscriptionID(ctx context.Context, cred azcore.TokenCredential, prompt string) (string, error) {
+	// Get the list of subscriptions, plus, with PIM discovery, those an
+	// eligible role gives access to
 	subs, err := getSubscriptions(ctx, cred)
-	if err != nil {
+	listed := make(map[string]bool)
+	for _, sub := range subs {
+		listed[strings.ToLower(*sub.SubscriptionID)] = true
+	}
+	eligible := eligibleSubscriptionItems(ctx, cred, listed)
+	if err != nil && len(eligible) == 0 {
 		return "", err
 	}
 
 	// If there's only one subscription, use it
-	if len(subs) == 1 {
+	if len(subs) == 1 && len(eligible) == 0 {
 		return *subs[0].SubscriptionID, nil
 	}
 
-	// Create menu items
+	// Create menu items, listing the profile's default subscription first
 	var items []string
 	subMap := make(map[string]*armsubscription.Subscription)
+	defaultSubscriptionID := profileSubscriptionID()
 	for _, sub := range subs {
 		item := fmt.Sprintf("%s | ID: %s | State: %s",
 			*sub.DisplayName,
 			*sub.SubscriptionID,
 			*sub.State)
-		items = append(items, item)
+		if defaultSubscriptionID != "" && strings.EqualFold(*sub.SubscriptionID, defaultSubscriptionID) {
+			item += " (profile default)"
+			items = append([]string{item}, items...)
+		} else {
+			items = append(items, item)
+		}
 		subMap[item] = sub
 	}
 
+	eligibleItems := make([]string, 0, len(eligible))
+	for item := range eligible {
+		eligibleItems = append(eligibleItems, item)
+	}
+	sort.Strings(eligibleItems)
+	items = append(items, eligibleItems...)
+
 	// Let the user select a subscription
 	selected, err := utils.SelectWithMenu(items, prompt+" (type to filter)")
 	if err != nil {
 		return "", fmt.Errorf("failed to select subscription: %v", err)
 	}
 
+	// A subscription behind PIM needs its role activated first
+	if subscriptionID, ok := eligible[selected]; ok {
+		if _, err := activateRoleForSubscription(ctx, cred, subscriptionID, nil, "", ""); err != nil {
+			return "", err
+		}
+		return subscriptionID, nil
+	}
+
 	// Return the selected subscription ID
 	return *subMap[selected].SubscriptionID, nil
 }
@@ -6,15 +6,48 @@ import (
 	"os"
 	"strings"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
 	"github.com/antnsn/BastionBuddy/internal/config"
 	"github.com/antnsn/BastionBuddy/internal/utils"
 )
 
-// GetTargetResource prompts the user to select a target resource.
-func GetTargetResource(ctx context.Context, cred *azidentity.DefaultAzureCredential, subscriptionID string) (*config.TargetResource, error) {
-	selectionMethod, err := utils.SelectWithMenu([]string{"select-resource", "manual-input"}, "How would you like to specify the target resource?")
+// GetTargetResource prompts the user to select a target resource. When
+// subscriptionID is empty, the tenant is asked for if the user can access
+// several, and the subscription is asked for only if the user chooses to
+// select a resource from a single subscription. The tenant the target was
+// found in becomes the tenant in use.
+func GetTargetResource(ctx context.Context, cred azcore.TokenCredential, subscriptionID string) (*config.TargetResource, error) {
+	searchAllTenants := false
+	if subscriptionID == "" {
+		tenantID, err := selectTenant(ctx, cred)
+		if err != nil {
+			return nil, err
+		}
+		if tenantID == allTenants {
+			searchAllTenants = true
+		} else if tenantID != "" {
+			useTenant(tenantID)
+			if cred, err = GetAzureCredential(); err != nil {
+				return nil, fmt.Errorf("failed to get Azure credentials: %v", err)
+			}
+		}
+	}
+
+	target, err := selectTargetResource(ctx, cred, subscriptionID, searchAllTenants)
+	if err != nil {
+		return nil, err
+	}
+	if target.TenantID == "" {
+		target.TenantID = currentTenantID()
+	}
+	useTenant(target.TenantID)
+	return target, nil
+}
+
+// selectTargetResource asks how to specify the target and selects it
+func selectTargetResource(ctx context.Context, cred azcore.TokenCredential, subscriptionID string, searchAllTenants bool) (*config.TargetResource, error) {
+	selectionMethod, err := utils.SelectWithMenu([]string{"select-resource", "search-all-subscriptions", "ip-address", "manual-input"}, "How would you like to specify the target resource?")
 	if err != nil {
 		if strings.Contains(err.Error(), "cancelled by user") {
 			fmt.Println("\nOperation cancelled by user")
@@ -25,17 +58,37 @@ func GetTargetResource(ctx context.Context, cred *azidentity.DefaultAzureCredent
 
 	switch selectionMethod {
 	case "select-resource":
+		if subscriptionID == "" && searchAllTenants {
+			subscriptionID, err = selectSubscriptionAllTenants(ctx, cred, "Select Azure subscription for target resource")
+			if err != nil {
+				return nil, err
+			}
+			if cred, err = GetAzureCredential(); err != nil {
+				return nil, fmt.Errorf("failed to get Azure credentials: %v", err)
+			}
+		} else if subscriptionID == "" {
+			subscriptionID, err = getSubscriptionID(ctx, cred, "Select Azure subscription for target resource")
+			if err != nil {
+				return nil, err
+			}
+		}
 		return getResourceSelection(ctx, cred, subscriptionID)
+	case "search-all-subscriptions":
+		return getResourceSearchSelection(ctx, cred, searchAllTenants)
+	case "ip-address":
+		return getIPTarget(ctx, cred)
 	case "manual-input":
-		return getResourceManualInput()
+		return getResourceManualInput(ctx, cred)
 	default:
 		return nil, fmt.Errorf("invalid selection method: %s", selectionMethod)
 	}
 }
 
-// getResourceManualInput prompts the user to manually input resource details.
-func getResourceManualInput() (*config.TargetResource, error) {
-	resourceID, err := utils.ReadInput("Enter resource ID")
+// getResourceManualInput prompts for the resource ID or portal URL of a
+// virtual machine, scale set or scale set instance. A scale set connects to
+// any healthy instance.
+func getResourceManualInput(ctx context.Context, cred azcore.TokenCredential) (*config.TargetResource, error) {
+	input, err := utils.ReadInput("Enter resource ID or portal URL")
 	if err != nil {
 		if strings.Contains(err.Error(), "cancelled by user") {
 			fmt.Println("\nOperation cancelled by user")
@@ -44,91 +97,169 @@ func getResourceManualInput() (*config.TargetResource, error) {
 		return nil, fmt.Errorf("failed to read resource ID: %v", err)
 	}
 
-	return &config.TargetResource{
-		ID: resourceID,
-	}, nil
+	id, err := parseResourceIDOfType(input, virtualMachineType, scaleSetVMType, scaleSetType)
+	if err != nil {
+		return nil, err
+	}
+	if err := confirmResourceExists(ctx, cred, id, computeAPIVersion); err != nil {
+		return nil, err
+	}
+
+	target := &config.TargetResource{
+		ID:             id.ID,
+		Name:           id.Name,
+		Type:           id.Type,
+		SubscriptionID: id.SubscriptionID,
+	}
+	if strings.EqualFold(id.Type, scaleSetType) {
+		target.Type = config.TargetTypeScaleSet
+	}
+	return target, nil
 }
 
-// getResourceSelection retrieves available virtual machines and lets the user select one.
-func getResourceSelection(ctx context.Context, cred *azidentity.DefaultAzureCredential, subscriptionID string) (*config.TargetResource, error) {
+// getResourceSelection retrieves available virtual machines and scale sets
+// and lets the user select a VM or drill into a scale set's instances.
+func getResourceSelection(ctx context.Context, cred azcore.TokenCredential, subscriptionID string) (*config.TargetResource, error) {
 	debugPrintf("Fetching virtual machines from subscription: %s...\n", subscriptionID)
 
-	client, err := armresources.NewClient(subscriptionID, cred, nil)
+	resources, err := listResources(ctx, cred, subscriptionID, virtualMachineType)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create resources client: %v", err)
+		return nil, err
 	}
 
-	filter := "resourceType eq 'Microsoft.Compute/virtualMachines'"
-	debugPrintf("Using filter: %s\n", filter)
-
-	var resources []*armresources.GenericResourceExpanded
-	pageNum := 1
-
-	pager := client.NewListPager(&armresources.ClientListOptions{
-		Filter: &filter,
-	})
-
-	for pager.More() {
-		debugPrintf("Fetching page %d...\n", pageNum)
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get resources page: %v", err)
-		}
-		debugPrintf("Found %d resources on page %d\n", len(page.Value), pageNum)
-		for _, res := range page.Value {
-			debugPrintf("Found resource: Name=%s, Type=%s, ID=%s\n",
-				*res.Name,
-				*res.Type,
-				*res.ID)
-		}
-		resources = append(resources, page.Value...)
-		pageNum++
+	scaleSets, err := listResources(ctx, cred, subscriptionID, scaleSetType)
+	if err != nil {
+		debugPrintf("Could not list scale sets: %v\n", err)
 	}
 
-	if len(resources) == 0 {
+	if len(resources) == 0 && len(scaleSets) == 0 {
 		return nil, fmt.Errorf("no virtual machines found in subscription")
 	}
 
-	var items []string
-	resourceMap := make(map[string]*armresources.GenericResourceExpanded)
+	// Power states and OS types are looked up live; the picker works without them
+	states, err := subscriptionVMStates(ctx, cred, subscriptionID)
+	if err != nil {
+		debugPrintf("Could not read power states: %v\n", err)
+	}
 
+	var vms []vmEntry
 	for _, res := range resources {
 		if res.Name == nil || res.ID == nil {
 			continue
 		}
 
-		// Extract resource group from ID
-		parts := strings.Split(*res.ID, "/")
-		var resourceGroup string
-		for i, part := range parts {
-			if strings.EqualFold(part, "resourceGroups") && i+1 < len(parts) {
-				resourceGroup = parts[i+1]
-				break
-			}
+		vm := vmEntry{
+			ID:             *res.ID,
+			Name:           *res.Name,
+			ResourceGroup:  resourceGroupFromID(*res.ID),
+			SubscriptionID: subscriptionID,
+			Tags:           tagValues(res.Tags),
 		}
+		state := states[strings.ToLower(*res.ID)]
+		vm.PowerState, vm.OSType = state.PowerState, state.OSType
 
 		// Include name, resource group, and location in the display
-		item := fmt.Sprintf("%s | Group: %s | Region: %s",
+		vm.label = fmt.Sprintf("%s | Group: %s | Region: %s",
 			*res.Name,
-			resourceGroup,
+			vm.ResourceGroup,
 			*res.Location)
-		items = append(items, item)
-		resourceMap[item] = res
+		if vm.OSType != "" {
+			vm.label += " | " + vm.OSType
+		}
+		if vm.PowerState != "" {
+			vm.label += " | " + vm.PowerState
+		}
+		vms = append(vms, vm)
+	}
+
+	for _, res := range scaleSets {
+		if res.Name == nil || res.ID == nil {
+			continue
+		}
+		vm := vmEntry{
+			ID:             *res.ID,
+			Name:           *res.Name,
+			ResourceGroup:  resourceGroupFromID(*res.ID),
+			SubscriptionID: subscriptionID,
+			Tags:           tagValues(res.Tags),
+			scaleSet:       true,
+		}
+		vm.label = fmt.Sprintf("%s | Group: %s | Region: %s | scale set", *res.Name, vm.ResourceGroup, *res.Location)
+		vms = append(vms, vm)
 	}
 
-	selected, err := utils.SelectWithMenu(items, "Select virtual machine (type to filter)")
+	selected, err := selectVM(vms, "Select virtual machine or scale set")
 	if err != nil {
-		if strings.Contains(err.Error(), "cancelled by user") {
-			fmt.Println("\nOperation cancelled by user")
-			os.Exit(0)
+		return nil, err
+	}
+
+	if selected.scaleSet {
+		target, err := selectScaleSetInstance(ctx, cred, selected.ID, subscriptionID)
+		if err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("failed to select resource: %v", err)
+		target.Defaults = targetDefaultsFromTags(selected.Name, selected.Tags)
+		return target, nil
 	}
 
-	selectedResource := resourceMap[selected]
 	return &config.TargetResource{
-		ID:   *selectedResource.ID,
-		Name: *selectedResource.Name,
-		Type: "Microsoft.Compute/virtualMachines",
+		ID:             selected.ID,
+		Name:           selected.Name,
+		Type:           virtualMachineType,
+		SubscriptionID: subscriptionID,
+		OSType:         selected.OSType,
+		Defaults:       targetDefaultsFromTags(selected.Name, selected.Tags),
 	}, nil
 }
+
+// tagValues flattens SDK resource tags
+func tagValues(tags map[string]*string) map[string]string {
+	values := make(map[string]string, len(tags))
+	for key, value := range tags {
+		if value != nil {
+			values[key] = *value
+		} else {
+			values[key] = ""
+		}
+	}
+	return values
+}
+
+// listResources lists the resources of a type in a subscription, using the discovery cache
+func listResources(ctx context.Context, cred azcore.TokenCredential, subscriptionID string, resourceType string) ([]*armresources.GenericResourceExpanded, error) {
+	kind := cacheKeyPart(strings.ToLower(resourceType))
+	return cachedDiscovery(ctx, subscriptionID, kind, func(ctx context.Context) ([]*armresources.GenericResourceExpanded, error) {
+		return fetchResources(ctx, cred, subscriptionID, resourceType)
+	})
+}
+
+// fetchResources lists the resources of a type in a subscription from ARM
+func fetchResources(ctx context.Context, cred azcore.TokenCredential, subscriptionID string, resourceType string) ([]*armresources.GenericResourceExpanded, error) {
+	client, err := armresources.NewClient(subscriptionID, cred, armClientOptions())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resources client: %v", err)
+	}
+
+	filter := fmt.Sprintf("resourceType eq '%s'", resourceType)
+	debugPrintf("Using filter: %s\n", filter)
+
+	var resources []*armresources.GenericResourceExpanded
+	pageNum := 1
+
+	pager := client.NewListPager(&armresources.ClientListOptions{
+		Filter: &filter,
+	})
+
+	for pager.More() {
+		debugPrintf("Fetching page %d...\n", pageNum)
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get resources page: %v", err)
+		}
+		debugPrintf("Found %d resources on page %d\n", len(page.Value), pageNum)
+		resources = append(resources, page.Value...)
+		pageNum++
+	}
+
+	return resources, nil
+}
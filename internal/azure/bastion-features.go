@@ -0,0 +1,161 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/antnsn/BastionBuddy/internal/config"
+	"github.com/antnsn/BastionBuddy/internal/tunnels"
+)
+
+// bastionFeatures describes the SKU and optional features of a Bastion host
+type bastionFeatures struct {
+	SKU                 string `json:"sku"`
+	EnableTunneling     bool   `json:"enableTunneling"`
+	EnableIPConnect     bool   `json:"enableIpConnect"`
+	EnableShareableLink bool   `json:"enableShareableLink"`
+}
+
+// String renders the features for the Bastion picker
+func (f bastionFeatures) String() string {
+	sku := f.SKU
+	if sku == "" {
+		sku = "unknown SKU"
+	}
+	parts := []string{sku}
+	if f.EnableTunneling {
+		parts = append(parts, "native client")
+	}
+	if f.EnableIPConnect {
+		parts = append(parts, "IP connect")
+	}
+	if f.EnableShareableLink {
+		parts = append(parts, "shareable link")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// supports returns nil if the host can serve the connection type, or an
+// error explaining what is missing. Every az network bastion connection
+// (ssh, rdp and tunnel) goes through the native client support, which needs
+// the Standard or Premium SKU with tunneling enabled; connecting to an IP
+// address additionally needs IP-based connect.
+func (f bastionFeatures) supports(connectionType ConnectionType, ipConnect bool) error {
+	switch strings.ToLower(f.SKU) {
+	case "standard", "premium":
+	case "":
+		return nil
+	default:
+		return fmt.Errorf("%s SKU does not support native client %s connections; Standard or Premium is required", f.SKU, connectionName(connectionType))
+	}
+	if !f.EnableTunneling {
+		return fmt.Errorf("native client support (tunneling) is not enabled, which %s connections require", connectionName(connectionType))
+	}
+	if ipConnect && !f.EnableIPConnect {
+		return fmt.Errorf("IP-based connection is not enabled")
+	}
+	return nil
+}
+
+// supportsSessions returns nil if the host's active sessions can be listed
+// and disconnected, which works on every SKU but Developer
+func (f bastionFeatures) supportsSessions() error {
+	if strings.EqualFold(f.SKU, "Developer") {
+		return fmt.Errorf("Developer SKU does not support session management")
+	}
+	return nil
+}
+
+// connectionName returns a display name for a connection type
+func connectionName(connectionType ConnectionType) string {
+	switch connectionType {
+	case SSH:
+		return "SSH"
+	case RDP:
+		return "RDP"
+	case Tunnel, "":
+		return "tunnel"
+	default:
+		return string(connectionType)
+	}
+}
+
+// bastionHostID returns the resource ID of a Bastion host
+func bastionHostID(host *config.BastionHost) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/bastionHosts/%s",
+		host.SubscriptionID, host.ResourceGroup, host.Name)
+}
+
+// getBastionFeatures reads a Bastion host's SKU and feature flags, using the discovery cache
+func getBastionFeatures(ctx context.Context, cred azcore.TokenCredential, bastionID string) (bastionFeatures, error) {
+	id, err := parseResourceIDOfType(bastionID, bastionHostType)
+	if err != nil {
+		return bastionFeatures{}, err
+	}
+	kind := cacheKeyPart(strings.ToLower(fmt.Sprintf("bastion-%s-%s", id.ResourceGroup, id.Name)))
+
+	return cachedDiscovery(ctx, strings.ToLower(id.SubscriptionID), kind, func(ctx context.Context) (bastionFeatures, error) {
+		client, err := armresources.NewClient(id.SubscriptionID, cred, armClientOptions())
+		if err != nil {
+			return bastionFeatures{}, fmt.Errorf("failed to create resources client: %v", err)
+		}
+		resource, err := client.GetByID(ctx, bastionID, networkAPIVersion, nil)
+		if err != nil {
+			return bastionFeatures{}, fmt.Errorf("failed to read Bastion host: %v", err)
+		}
+		return bastionFeaturesFromResource(resource.GenericResource), nil
+	})
+}
+
+// bastionFeaturesFromResource extracts the features from a Bastion host resource
+func bastionFeaturesFromResource(resource armresources.GenericResource) bastionFeatures {
+	var features bastionFeatures
+	if resource.SKU != nil && resource.SKU.Name != nil {
+		features.SKU = *resource.SKU.Name
+	}
+	props, _ := resource.Properties.(map[string]interface{})
+	features.EnableTunneling, _ = props["enableTunneling"].(bool)
+	features.EnableIPConnect, _ = props["enableIpConnect"].(bool)
+	features.EnableShareableLink, _ = props["enableShareableLink"].(bool)
+	return features
+}
+
+// checkBastion verifies a Bastion host supports the connection before az is
+// started. Hosts whose features cannot be read are let through.
+func checkBastion(host *config.BastionHost, connectionType ConnectionType, ipConnect bool) error {
+	if host == nil || host.Name == "" || host.ResourceGroup == "" || host.SubscriptionID == "" {
+		return nil
+	}
+
+	cred, err := GetAzureCredential()
+	if err != nil {
+		return fmt.Errorf("failed to get Azure credentials: %v", err)
+	}
+
+	features, err := getBastionFeatures(context.Background(), cred, bastionHostID(host))
+	if err != nil {
+		debugPrintf("Could not read features of Bastion host %s: %v\n", host.Name, err)
+		return nil
+	}
+	if err := features.supports(connectionType, ipConnect); err != nil {
+		return fmt.Errorf("bastion host %s cannot be used: %v (use --refresh if its settings changed recently)", host.Name, err)
+	}
+	return nil
+}
+
+// checkSavedBastion verifies the Bastion host of a resolved saved configuration
+func checkSavedBastion(saved tunnels.Config) error {
+	connectionType := ConnectionType(saved.ConnectionType)
+	if connectionType == "" {
+		connectionType = Tunnel
+	}
+	host := &config.BastionHost{
+		Name:           saved.BastionName,
+		ResourceGroup:  saved.BastionResourceGroup,
+		SubscriptionID: saved.BastionSubscriptionID,
+	}
+	return checkBastion(host, connectionType, saved.TargetKind == config.TargetTypeIP)
+}
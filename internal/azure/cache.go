@@ -0,0 +1,344 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antnsn/BastionBuddy/internal/config"
+)
+
+const (
+	// CacheTTLEnv overrides how long discovery results are considered fresh
+	CacheTTLEnv = "BASTIONBUDDY_CACHE_TTL"
+	// defaultCacheTTL is how long discovery results are considered fresh
+	defaultCacheTTL = 15 * time.Minute
+	// refreshTimeout bounds a background refresh
+	refreshTimeout = 2 * time.Minute
+)
+
+var (
+	// forceRefresh bypasses cached discovery results (--refresh)
+	forceRefresh bool
+	// offlineMode serves cached discovery results without contacting ARM (--offline)
+	offlineMode bool
+
+	// pendingRefreshes tracks background refreshes so Cleanup can wait for them
+	pendingRefreshes sync.WaitGroup
+	refreshing       sync.Map
+)
+
+// cacheEntry is the on-disk representation of a cached discovery result
+type cacheEntry struct {
+	FetchedAt time.Time       `json:"fetched_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// SetForceRefresh makes discovery ignore cached results and fetch from ARM
+func SetForceRefresh(enabled bool) {
+	forceRefresh = enabled
+}
+
+// SetOfflineMode makes discovery serve cached results without contacting ARM
+func SetOfflineMode(enabled bool) {
+	offlineMode = enabled
+}
+
+// cacheTTL returns how long cached results are considered fresh. A zero
+// TTL disables caching.
+func cacheTTL() time.Duration {
+	if value := os.Getenv(CacheTTLEnv); value != "" {
+		if ttl, err := time.ParseDuration(value); err == nil && ttl >= 0 {
+			return ttl
+		}
+		debugPrintf("Ignoring invalid %s=%q\n", CacheTTLEnv, value)
+	}
+	return defaultCacheTTL
+}
+
+// CacheDir returns the directory holding cached discovery results
+func CacheDir() (string, error) {
+	stateDir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, "cache"), nil
+}
+
+// ClearCache removes all cached discovery results and returns the directory that was cleared
+func ClearCache() (string, error) {
+	dir, err := CacheDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return "", fmt.Errorf("failed to clear cache: %v", err)
+	}
+	return dir, nil
+}
+
+// cacheFile returns the file for a cache entry, keyed by cloud, tenant and,
+// when given, subscription. Without a tenant the credential's tenant is used,
+// so signing in to another tenant does not serve stale entries; if that is
+// not known without signing in, there is no cache file.
+func cacheFile(tenant string, subscriptionID string, kind string) (string, error) {
+	dir, err := CacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	if tenant == "" {
+		tenant = credentialTenantID()
+	}
+	if tenant == "" {
+		return "", fmt.Errorf("tenant of the %s credential is not known yet", currentAuthSource())
+	}
+	parts := []string{dir, cacheKeyPart(strings.ToLower(activeCloud().Name)), cacheKeyPart(strings.ToLower(tenant))}
+	if subscriptionID != "" {
+		parts = append(parts, cacheKeyPart(strings.ToLower(subscriptionID)))
+	}
+	parts = append(parts, kind+".json")
+	return filepath.Join(parts...), nil
+}
+
+// credentialTenantID returns the tenant the credential in use signs in to
+// when no tenant is chosen, without requesting a token: the tenant of the
+// last token issued, else the tenant configured for the credential source;
+// "" if unknown
+func credentialTenantID() string {
+	identityMu.Lock()
+	identity := lastIdentity
+	identityMu.Unlock()
+	if identity != nil && identity.TenantID != "" {
+		return identity.TenantID
+	}
+
+	switch currentAuthSource() {
+	case authEnvironment, authWorkloadIdentity:
+		return os.Getenv("AZURE_TENANT_ID")
+	case authAzureCLI:
+		return azCLITenantID()
+	case authDefault:
+		// The environment is tried before the Azure CLI
+		if tenant := os.Getenv("AZURE_TENANT_ID"); tenant != "" {
+			return tenant
+		}
+		return azCLITenantID()
+	}
+	return ""
+}
+
+// azCLITenantID returns the tenant of the Azure CLI's default subscription in
+// the cloud in use, read from its profile file rather than by running az
+func azCLITenantID() string {
+	azureDir := os.Getenv("AZURE_CONFIG_DIR")
+	if azureDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		azureDir = filepath.Join(home, ".azure")
+	}
+	data, err := os.ReadFile(filepath.Join(azureDir, "azureProfile.json"))
+	if err != nil {
+		return ""
+	}
+
+	var profile struct {
+		Subscriptions []struct {
+			TenantID        string `json:"tenantId"`
+			EnvironmentName string `json:"environmentName"`
+			IsDefault       bool   `json:"isDefault"`
+		} `json:"subscriptions"`
+	}
+	// az writes the file with a byte order mark
+	if err := json.Unmarshal(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), &profile); err != nil {
+		debugPrintf("Could not read the Azure CLI profile: %v\n", err)
+		return ""
+	}
+	azName := activeCloud().AzName
+	for _, sub := range profile.Subscriptions {
+		if sub.IsDefault && (azName == "" || strings.EqualFold(sub.EnvironmentName, azName)) {
+			return sub.TenantID
+		}
+	}
+	return ""
+}
+
+// cacheKeyPart makes a key safe to use as a path element
+func cacheKeyPart(key string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", "..", "_", ":", "_").Replace(key)
+}
+
+// readCache loads a cache entry, returning nil if there is none
+func readCache(path string) *cacheEntry {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		debugPrintf("Ignoring corrupt cache file %s: %v\n", path, err)
+		return nil
+	}
+	return &entry
+}
+
+// writeCache stores a discovery result, replacing the file atomically
+func writeCache(path string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry: %v", err)
+	}
+	entry, err := json.Marshal(cacheEntry{FetchedAt: time.Now(), Data: data})
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cache-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cache: %v", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(entry); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write cache: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write cache: %v", err)
+	}
+	return nil
+}
+
+// cachedDiscovery returns a discovery result from the cache when possible.
+// Fresh entries are served as is; stale entries are served while a background
+// refresh updates them. Without an entry, or with --refresh, the result is
+// fetched from ARM, and if ARM is unreachable any cached entry is served.
+// Entries belong to the tenant in use.
+func cachedDiscovery[T any](ctx context.Context, subscriptionID string, kind string, fetch func(ctx context.Context) (T, error)) (T, error) {
+	return cachedTenantDiscovery(ctx, currentTenantID(), subscriptionID, kind, fetch)
+}
+
+// cachedTenantDiscovery is cachedDiscovery for an explicit tenant
+func cachedTenantDiscovery[T any](ctx context.Context, tenantID string, subscriptionID string, kind string, fetch func(ctx context.Context) (T, error)) (T, error) {
+	ttl := cacheTTL()
+	if ttl == 0 && !offlineMode {
+		return fetch(ctx)
+	}
+
+	path, err := cacheFile(tenantID, subscriptionID, kind)
+	if err != nil {
+		if offlineMode {
+			var none T
+			return none, fmt.Errorf("offline mode: no cached %s available: %v", kind, err)
+		}
+		debugPrintf("Cache unavailable: %v\n", err)
+		return fetch(ctx)
+	}
+
+	var cached T
+	entry := readCache(path)
+	if entry != nil {
+		if err := json.Unmarshal(entry.Data, &cached); err != nil {
+			debugPrintf("Ignoring unreadable cache file %s: %v\n", path, err)
+			entry = nil
+		}
+	}
+
+	if offlineMode {
+		if entry == nil {
+			return cached, fmt.Errorf("offline mode: no cached %s available; run once without --offline to populate the cache", kind)
+		}
+		debugPrintf("Offline: using cached %s from %s\n", kind, entry.FetchedAt.Format(time.RFC3339))
+		return cached, nil
+	}
+
+	if entry != nil && !forceRefresh {
+		age := time.Since(entry.FetchedAt)
+		if age > ttl {
+			debugPrintf("Cached %s is %s old, refreshing in the background\n", kind, age.Round(time.Second))
+			refreshInBackground(path, kind, fetch)
+		} else {
+			debugPrintf("Using cached %s (%s old)\n", kind, age.Round(time.Second))
+		}
+		return cached, nil
+	}
+
+	result, err := fetch(ctx)
+	if err != nil {
+		if entry != nil {
+			fmt.Printf("Warning: %v\nUsing cached %s from %s\n", err, kind, entry.FetchedAt.Local().Format("2006-01-02 15:04"))
+			return cached, nil
+		}
+		return result, err
+	}
+
+	if err := writeCache(path, result); err != nil {
+		debugPrintf("Warning: %v\n", err)
+	}
+	return result, nil
+}
+
+// forgetCachedDiscovery drops a cache entry, e.g. after access has changed,
+// so the next lookup fetches it from ARM
+func forgetCachedDiscovery(tenantID string, subscriptionID string, kind string) {
+	path, err := cacheFile(tenantID, subscriptionID, kind)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		debugPrintf("Warning: failed to remove cache file %s: %v\n", path, err)
+	}
+}
+
+// refreshInBackground refetches a stale cache entry without blocking the caller
+func refreshInBackground[T any](path string, kind string, fetch func(ctx context.Context) (T, error)) {
+	if _, running := refreshing.LoadOrStore(path, true); running {
+		return
+	}
+
+	pendingRefreshes.Add(1)
+	go func() {
+		defer pendingRefreshes.Done()
+		defer refreshing.Delete(path)
+
+		// Nobody is waiting on a background refresh, so it never prompts to sign in
+		ctx, cancel := context.WithTimeout(withoutReauthPrompt(context.Background()), refreshTimeout)
+		defer cancel()
+
+		result, err := fetch(ctx)
+		if err != nil {
+			debugPrintf("Background refresh of %s failed: %v\n", kind, err)
+			return
+		}
+		if err := writeCache(path, result); err != nil {
+			debugPrintf("Warning: %v\n", err)
+		}
+	}()
+}
+
+// waitForRefreshes waits up to timeout for background refreshes to finish
+func waitForRefreshes(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		pendingRefreshes.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
@@ -0,0 +1,228 @@
+package azure
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// useTempCache points the cache and configuration at temporary directories
+// and resets the cache modes after the test
+func useTempCache(t *testing.T) {
+	t.Helper()
+	t.Setenv("BASTIONBUDDY_CONFIG_DIR", t.TempDir())
+	t.Setenv("BASTIONBUDDY_STATE_DIR", t.TempDir())
+	t.Setenv(CacheTTLEnv, "")
+	t.Cleanup(func() {
+		offlineMode = false
+		forceRefresh = false
+	})
+}
+
+// writeAgedCache stores value as a cache entry fetched age ago
+func writeAgedCache(t *testing.T, tenant, kind string, value interface{}, age time.Duration) {
+	t.Helper()
+	path, err := cacheFile(tenant, "", kind)
+	if err != nil {
+		t.Fatalf("cacheFile: %v", err)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	entry, err := json.Marshal(cacheEntry{FetchedAt: time.Now().Add(-age), Data: data})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, entry, 0600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+// countingFetch returns a fetch function yielding value and counting its calls
+func countingFetch(value string, err error, calls *int) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) {
+		*calls++
+		return value, err
+	}
+}
+
+// fakeJWT wraps claims in an unsigned token
+func fakeJWT(t *testing.T, claims string) azcore.AccessToken {
+	t.Helper()
+	payload := base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return azcore.AccessToken{Token: "e30." + payload + ".sig", ExpiresOn: time.Now().Add(time.Hour)}
+}
+
+func TestCacheTTL(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", defaultCacheTTL},
+		{"5m", 5 * time.Minute},
+		{"90s", 90 * time.Second},
+		{"0", 0},
+		{"-1m", defaultCacheTTL},
+		{"soon", defaultCacheTTL},
+		{"15", defaultCacheTTL},
+	}
+	for _, tt := range tests {
+		t.Setenv(CacheTTLEnv, tt.value)
+		if got := cacheTTL(); got != tt.want {
+			t.Errorf("cacheTTL with %q = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCachedDiscoveryFreshAndStale(t *testing.T) {
+	useTempCache(t)
+	ctx := context.Background()
+
+	// A fresh entry is served without fetching
+	writeAgedCache(t, "tenant-1", "things", "cached", time.Minute)
+	calls := 0
+	got, err := cachedTenantDiscovery(ctx, "tenant-1", "", "things", countingFetch("fetched", nil, &calls))
+	if err != nil || got != "cached" || calls != 0 {
+		t.Fatalf("fresh entry: got %q, %v after %d fetches", got, err, calls)
+	}
+
+	// A stale entry is served while it is refreshed in the background
+	writeAgedCache(t, "tenant-1", "things", "stale", time.Hour)
+	got, err = cachedTenantDiscovery(ctx, "tenant-1", "", "things", countingFetch("refreshed", nil, &calls))
+	if err != nil || got != "stale" {
+		t.Fatalf("stale entry: got %q, %v", got, err)
+	}
+	waitForRefreshes(5 * time.Second)
+	if calls != 1 {
+		t.Fatalf("background refresh fetched %d times, want 1", calls)
+	}
+	got, err = cachedTenantDiscovery(ctx, "tenant-1", "", "things", countingFetch("unused", nil, &calls))
+	if err != nil || got != "refreshed" || calls != 1 {
+		t.Errorf("after refresh: got %q, %v after %d fetches", got, err, calls)
+	}
+
+	// --refresh fetches even a fresh entry
+	forceRefresh = true
+	got, err = cachedTenantDiscovery(ctx, "tenant-1", "", "things", countingFetch("forced", nil, &calls))
+	if err != nil || got != "forced" || calls != 2 {
+		t.Errorf("forced refresh: got %q, %v after %d fetches", got, err, calls)
+	}
+}
+
+func TestCachedDiscoveryFetchFailure(t *testing.T) {
+	useTempCache(t)
+	ctx := context.Background()
+	unreachable := errors.New("resource manager unreachable")
+
+	calls := 0
+	if _, err := cachedTenantDiscovery(ctx, "tenant-1", "", "things", countingFetch("", unreachable, &calls)); !errors.Is(err, unreachable) {
+		t.Fatalf("without a cached entry: err = %v", err)
+	}
+
+	writeAgedCache(t, "tenant-1", "things", "last known", time.Minute)
+	forceRefresh = true
+	got, err := cachedTenantDiscovery(ctx, "tenant-1", "", "things", countingFetch("", unreachable, &calls))
+	if err != nil || got != "last known" {
+		t.Errorf("with a cached entry: got %q, %v", got, err)
+	}
+}
+
+func TestCachedDiscoveryOffline(t *testing.T) {
+	useTempCache(t)
+	ctx := context.Background()
+	offlineMode = true
+
+	calls := 0
+	_, err := cachedTenantDiscovery(ctx, "tenant-1", "", "things", countingFetch("fetched", nil, &calls))
+	if err == nil || !strings.Contains(err.Error(), "offline mode: no cached things available") {
+		t.Errorf("without a cached entry: err = %v", err)
+	}
+
+	// Offline, even an old entry is served and nothing is fetched
+	writeAgedCache(t, "tenant-1", "things", "old", 48*time.Hour)
+	got, err := cachedTenantDiscovery(ctx, "tenant-1", "", "things", countingFetch("fetched", nil, &calls))
+	if err != nil || got != "old" {
+		t.Errorf("with a cached entry: got %q, %v", got, err)
+	}
+
+	// Offline, caching is not disabled by a zero TTL
+	t.Setenv(CacheTTLEnv, "0")
+	got, err = cachedTenantDiscovery(ctx, "tenant-1", "", "things", countingFetch("fetched", nil, &calls))
+	if err != nil || got != "old" {
+		t.Errorf("with a zero TTL: got %q, %v", got, err)
+	}
+	waitForRefreshes(5 * time.Second)
+	if calls != 0 {
+		t.Errorf("offline mode fetched %d times", calls)
+	}
+}
+
+func TestCacheFileKey(t *testing.T) {
+	useTempCache(t)
+	previousSource := sessionAuthSource
+	identityMu.Lock()
+	previousIdentity := lastIdentity
+	lastIdentity = nil
+	identityMu.Unlock()
+	t.Cleanup(func() {
+		sessionAuthSource = previousSource
+		identityMu.Lock()
+		lastIdentity = previousIdentity
+		identityMu.Unlock()
+	})
+	dir, err := CacheDir()
+	if err != nil {
+		t.Fatalf("CacheDir: %v", err)
+	}
+
+	path, err := cacheFile("Tenant-1", "Sub-1", "vms")
+	if err != nil {
+		t.Fatalf("cacheFile: %v", err)
+	}
+	if want := filepath.Join(dir, "azurepublic", "tenant-1", "sub-1", "vms.json"); path != want {
+		t.Errorf("cacheFile = %s, want %s", path, want)
+	}
+
+	// Without a tenant, the Azure CLI's tenant comes from its profile file
+	azureDir := t.TempDir()
+	t.Setenv("AZURE_CONFIG_DIR", azureDir)
+	t.Setenv("AZURE_TENANT_ID", "")
+	profile := "\xef\xbb\xbf" + `{"subscriptions":[
+		{"id":"sub-gov","tenantId":"tenant-gov","environmentName":"AzureUSGovernment","isDefault":true},
+		{"id":"sub-2","tenantId":"tenant-2","environmentName":"AzureCloud","isDefault":true},
+		{"id":"sub-3","tenantId":"tenant-3","environmentName":"AzureCloud","isDefault":false}]}`
+	if err := os.WriteFile(filepath.Join(azureDir, "azureProfile.json"), []byte(profile), 0600); err != nil {
+		t.Fatalf("write profile: %v", err)
+	}
+	sessionAuthSource = authAzureCLI
+	path, err = cacheFile("", "", "subscriptions")
+	if err != nil {
+		t.Fatalf("cacheFile: %v", err)
+	}
+	if want := filepath.Join(dir, "azurepublic", "tenant-2", "subscriptions.json"); path != want {
+		t.Errorf("cacheFile = %s, want %s", path, want)
+	}
+
+	// A managed identity's tenant is only known from a token
+	sessionAuthSource = authManagedIdentity
+	if path, err := cacheFile("", "", "subscriptions"); err == nil {
+		t.Errorf("cacheFile = %s, want an error for an unknown tenant", path)
+	}
+	rememberIdentity(fakeJWT(t, `{"tid":"tenant-mi"}`))
+	path, err = cacheFile("", "", "subscriptions")
+	if err != nil || !strings.Contains(path, filepath.Join("azurepublic", "tenant-mi")) {
+		t.Errorf("cacheFile = %s, %v", path, err)
+	}
+}
@@ -0,0 +1,325 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/antnsn/BastionBuddy/internal/config"
+	"github.com/antnsn/BastionBuddy/internal/utils"
+)
+
+// cloudMetadataAPIVersion is the API version of the ARM metadata endpoint
+// used to discover the endpoints of a custom cloud
+const cloudMetadataAPIVersion = "2022-09-01"
+
+// azureCloud describes the endpoints of an Azure cloud
+type azureCloud struct {
+	// Name is the cloud name, or the ARM endpoint of a custom cloud
+	Name string
+	// AzName is the name of the cloud in the Azure CLI
+	AzName string
+	// ResourceManager is the Azure Resource Manager endpoint
+	ResourceManager string
+	// Audience is the token audience for Azure Resource Manager
+	Audience string
+	// AuthorityHost is the Microsoft Entra ID login endpoint
+	AuthorityHost string
+	// KeyVaultSuffix is the DNS suffix of Key Vault, e.g. vault.azure.net
+	KeyVaultSuffix string
+	// custom marks a cloud the Azure CLI has to have registered
+	custom bool
+}
+
+// Known clouds, by the names accepted for the cloud setting
+var (
+	azurePublic = azureCloud{
+		Name:            "AzurePublic",
+		AzName:          "AzureCloud",
+		ResourceManager: "https://management.azure.com",
+		Audience:        "https://management.core.windows.net/",
+		AuthorityHost:   "https://login.microsoftonline.com/",
+		KeyVaultSuffix:  "vault.azure.net",
+	}
+	azureUSGovernment = azureCloud{
+		Name:            "AzureUSGovernment",
+		AzName:          "AzureUSGovernment",
+		ResourceManager: "https://management.usgovcloudapi.net",
+		Audience:        "https://management.core.usgovcloudapi.net/",
+		AuthorityHost:   "https://login.microsoftonline.us/",
+		KeyVaultSuffix:  "vault.usgovcloudapi.net",
+	}
+	azureChina = azureCloud{
+		Name:            "AzureChina",
+		AzName:          "AzureChinaCloud",
+		ResourceManager: "https://management.chinacloudapi.cn",
+		Audience:        "https://management.core.chinacloudapi.cn/",
+		AuthorityHost:   "https://login.chinacloudapi.cn/",
+		KeyVaultSuffix:  "vault.azure.cn",
+	}
+	knownClouds = []azureCloud{azurePublic, azureUSGovernment, azureChina}
+)
+
+var (
+	// sessionCloud is the cloud chosen with --cloud or by a saved configuration
+	sessionCloud string
+	// customClouds caches the discovered endpoints of custom clouds, by endpoint
+	customClouds   = make(map[string]*azureCloud)
+	customCloudsMu sync.Mutex
+	// azCloudChecked is set once the Azure CLI was switched to the cloud in use
+	azCloudChecked string
+)
+
+// SetCloud selects the cloud for this run (--cloud). It is one of
+// AzurePublic, AzureUSGovernment, AzureChina or the https:// ARM endpoint of
+// a custom cloud. A custom cloud's endpoints are discovered when Azure is
+// first used.
+func SetCloud(name string) error {
+	if err := ValidateCloud(name); err != nil {
+		return err
+	}
+	sessionCloud = name
+	return nil
+}
+
+// ValidateCloud checks that name is a known cloud or an https:// endpoint.
+// Plain http:// is accepted for loopback endpoints such as a local Resource
+// Manager stand-in.
+func ValidateCloud(name string) error {
+	if knownCloud(name) != nil || strings.HasPrefix(strings.ToLower(name), "https://") || isLoopbackEndpoint(name) {
+		return nil
+	}
+	var names []string
+	for _, known := range knownClouds {
+		names = append(names, known.Name)
+	}
+	return fmt.Errorf("unknown cloud %q: expected %s or an https:// Resource Manager endpoint", name, strings.Join(names, ", "))
+}
+
+// isLoopbackEndpoint reports whether endpoint is an http:// URL on this machine
+func isLoopbackEndpoint(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil || !strings.EqualFold(u.Scheme, "http") {
+		return false
+	}
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+// knownCloud looks up a cloud by its name or its Azure CLI name
+func knownCloud(name string) *azureCloud {
+	for i, known := range knownClouds {
+		if strings.EqualFold(name, known.Name) || strings.EqualFold(name, known.AzName) {
+			return &knownClouds[i]
+		}
+	}
+	return nil
+}
+
+// cloudSetting returns the configured cloud: --cloud or a saved
+// configuration's cloud, then the active profile's, or "" for Azure public
+func cloudSetting() string {
+	if sessionCloud != "" {
+		return sessionCloud
+	}
+	if profile, err := config.ActiveProfile(); err == nil && profile != nil {
+		return profile.Cloud
+	}
+	return ""
+}
+
+// activeCloud returns the cloud in use. The setting is checked when Azure is
+// initialized, so a cloud that can't be resolved here falls back to public.
+func activeCloud() *azureCloud {
+	c, err := resolveCloud(cloudSetting())
+	if err != nil {
+		debugPrintf("Using Azure public cloud: %v\n", err)
+		return &azurePublic
+	}
+	return c
+}
+
+// useCloud switches to the cloud a saved configuration was created in
+func useCloud(name string) error {
+	if name == "" || strings.EqualFold(name, cloudSetting()) {
+		return nil
+	}
+	if _, err := resolveCloud(name); err != nil {
+		return err
+	}
+	debugPrintf("Switching to cloud %s\n", name)
+	sessionCloud = name
+	return nil
+}
+
+// resolveCloud looks up a cloud by name, or discovers the endpoints of a
+// custom cloud from its ARM metadata endpoint
+func resolveCloud(name string) (*azureCloud, error) {
+	if name == "" {
+		return &azurePublic, nil
+	}
+	if c := knownCloud(name); c != nil {
+		return c, nil
+	}
+	if err := ValidateCloud(name); err != nil {
+		return nil, err
+	}
+
+	endpoint := strings.TrimSuffix(name, "/")
+	customCloudsMu.Lock()
+	defer customCloudsMu.Unlock()
+	if c, ok := customClouds[strings.ToLower(endpoint)]; ok {
+		return c, nil
+	}
+	c, err := discoverCloud(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	customClouds[strings.ToLower(endpoint)] = c
+	return c, nil
+}
+
+// discoverCloud reads the endpoints of a custom cloud from
+// {endpoint}/metadata/endpoints, as the Azure CLI does
+func discoverCloud(endpoint string) (*azureCloud, error) {
+	metadataURL := fmt.Sprintf("%s/metadata/endpoints?api-version=%s", endpoint, cloudMetadataAPIVersion)
+	debugPrintf("GET %s\n", metadataURL)
+	resp, err := armHTTPClient.Get(metadataURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cloud metadata from %s: %v", endpoint, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to read cloud metadata from %s: %s", endpoint, resp.Status)
+	}
+
+	var clouds []struct {
+		Name            string `json:"name"`
+		ResourceManager string `json:"resourceManager"`
+		Authentication  struct {
+			LoginEndpoint string   `json:"loginEndpoint"`
+			Audiences     []string `json:"audiences"`
+		} `json:"authentication"`
+		Suffixes struct {
+			KeyVaultDNS string `json:"keyVaultDns"`
+		} `json:"suffixes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&clouds); err != nil {
+		return nil, fmt.Errorf("failed to parse cloud metadata from %s: %v", endpoint, err)
+	}
+	if len(clouds) == 0 {
+		return nil, fmt.Errorf("no cloud metadata returned by %s", endpoint)
+	}
+
+	// The endpoint may describe several clouds; prefer the one it serves
+	metadata := clouds[0]
+	for _, c := range clouds {
+		if strings.EqualFold(strings.TrimSuffix(c.ResourceManager, "/"), endpoint) {
+			metadata = c
+			break
+		}
+	}
+	if metadata.Authentication.LoginEndpoint == "" {
+		return nil, fmt.Errorf("cloud metadata from %s has no login endpoint", endpoint)
+	}
+
+	host := endpoint
+	if parsed, err := url.Parse(endpoint); err == nil {
+		host = parsed.Hostname()
+	}
+	c := &azureCloud{
+		Name:            endpoint,
+		AzName:          "bastionbuddy-" + host,
+		ResourceManager: endpoint,
+		Audience:        endpoint,
+		AuthorityHost:   metadata.Authentication.LoginEndpoint,
+		KeyVaultSuffix:  strings.TrimPrefix(metadata.Suffixes.KeyVaultDNS, "."),
+		custom:          true,
+	}
+	if len(metadata.Authentication.Audiences) > 0 {
+		c.Audience = metadata.Authentication.Audiences[0]
+	}
+	return c, nil
+}
+
+// configuration returns the SDK configuration of the cloud
+func (c *azureCloud) configuration() cloud.Configuration {
+	return cloud.Configuration{
+		ActiveDirectoryAuthorityHost: c.AuthorityHost,
+		Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+			cloud.ResourceManager: {
+				Audience: c.Audience,
+				Endpoint: c.ResourceManager,
+			},
+		},
+	}
+}
+
+// scope returns the token scope for Azure Resource Manager
+func (c *azureCloud) scope() string {
+	return strings.TrimSuffix(c.Audience, "/") + "/.default"
+}
+
+// clientOptions returns the SDK client options for the cloud in use and the
+// configured proxy and CA bundle, used for credentials
+func clientOptions() azcore.ClientOptions {
+	options := azcore.ClientOptions{Cloud: activeCloud().configuration()}
+	if sdkHTTPClient != nil {
+		options.Transport = sdkHTTPClient
+	}
+	return options
+}
+
+// armClientOptions returns the options for ARM SDK clients
+func armClientOptions() *arm.ClientOptions {
+	return &arm.ClientOptions{ClientOptions: clientOptions()}
+}
+
+// ensureAzCloud points the Azure CLI at the configured cloud, registering a
+// custom cloud first. Without a cloud setting the CLI is left alone.
+func ensureAzCloud() error {
+	if cloudSetting() == "" {
+		return nil
+	}
+	c := activeCloud()
+	if azCloudChecked == c.AzName {
+		return nil
+	}
+
+	output, err := utils.PrepareAzureCommand("cloud", "show", "--query", "name", "--output", "tsv").Output()
+	if err != nil {
+		return fmt.Errorf("failed to read the Azure CLI cloud: %v", err)
+	}
+	if strings.EqualFold(strings.TrimSpace(string(output)), c.AzName) {
+		azCloudChecked = c.AzName
+		return nil
+	}
+
+	if c.custom {
+		if err := utils.PrepareAzureCommand("cloud", "show", "--name", c.AzName, "--output", "none").Run(); err != nil {
+			args := []string{"cloud", "register", "--name", c.AzName, "--endpoint-resource-manager", c.ResourceManager}
+			if c.KeyVaultSuffix != "" {
+				args = append(args, "--suffix-keyvault-dns", "."+c.KeyVaultSuffix)
+			}
+			if output, err := utils.PrepareAzureCommand(args...).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to register cloud %s with the Azure CLI: %v\n%s", c.Name, err, output)
+			}
+		}
+	}
+
+	fmt.Printf("Switching the Azure CLI to cloud %s\n", c.AzName)
+	if output, err := utils.PrepareAzureCommand("cloud", "set", "--name", c.AzName).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to switch the Azure CLI to cloud %s: %v\n%s", c.AzName, err, output)
+	}
+	azCloudChecked = c.AzName
+	return nil
+}
@@ -0,0 +1,237 @@
+package azure
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/antnsn/BastionBuddy/internal/config"
+)
+
+// Credential sources selectable with --auth or a profile's auth setting
+const (
+	authDefault          = "default"
+	authAzureCLI         = "azure-cli"
+	authEnvironment      = "environment"
+	authWorkloadIdentity = "workload-identity"
+	authManagedIdentity  = "managed-identity"
+	authDeviceCode       = "device-code"
+	authBrowser          = "browser"
+)
+
+// AuthSources lists the credential sources in the order they are documented
+var AuthSources = []string{
+	authDefault,
+	authAzureCLI,
+	authEnvironment,
+	authWorkloadIdentity,
+	authManagedIdentity,
+	authDeviceCode,
+	authBrowser,
+}
+
+// sessionAuthSource is the credential source chosen with --auth
+var sessionAuthSource string
+
+// SetAuthSource selects the credential source for this run (--auth)
+func SetAuthSource(source string) error {
+	if err := ValidateAuthSource(source); err != nil {
+		return err
+	}
+	sessionAuthSource = strings.ToLower(source)
+	return nil
+}
+
+// ValidateAuthSource checks that source names a known credential source
+func ValidateAuthSource(source string) error {
+	for _, known := range AuthSources {
+		if strings.EqualFold(source, known) {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown authentication source %q: expected one of %s", source, strings.Join(AuthSources, ", "))
+}
+
+// currentAuthSource returns the credential source in use: --auth, then the
+// active profile's auth setting, then the default chain
+func currentAuthSource() string {
+	if sessionAuthSource != "" {
+		return sessionAuthSource
+	}
+	if profile, err := config.ActiveProfile(); err == nil && profile != nil && profile.Auth != "" {
+		return strings.ToLower(profile.Auth)
+	}
+	return authDefault
+}
+
+// newCredential creates a credential from the source in use that acquires
+// tokens in tenantID, or in the identity's home tenant when it is empty. An
+// expired sign-in is renewed when a request needs it.
+func newCredential(tenantID string) (azcore.TokenCredential, error) {
+	cred, err := newSourceCredential(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return &reauthCredential{tenantID: tenantID, cred: cred}, nil
+}
+
+// newSourceCredential creates a credential from the source in use. The
+// credential signs in through the authority of the cloud in use.
+func newSourceCredential(tenantID string) (azcore.TokenCredential, error) {
+	source := currentAuthSource()
+	debugPrintf("Using %s credentials in cloud %s\n", source, activeCloud().Name)
+	options := clientOptions()
+
+	// The Azure CLI credential gets its tokens from az's current cloud
+	if source == authDefault || source == authAzureCLI {
+		if err := ensureAzCloud(); err != nil {
+			debugPrintf("Could not switch the Azure CLI cloud: %v\n", err)
+		}
+	}
+
+	switch source {
+	case authAzureCLI:
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{
+			TenantID: tenantID,
+		})
+	case authEnvironment:
+		// EnvironmentCredential is bound to AZURE_TENANT_ID; a client secret
+		// can be used in other tenants the service principal is registered in
+		secret := os.Getenv("AZURE_CLIENT_SECRET")
+		if tenantID != "" && secret != "" {
+			return azidentity.NewClientSecretCredential(tenantID, os.Getenv("AZURE_CLIENT_ID"), secret, &azidentity.ClientSecretCredentialOptions{
+				ClientOptions: options,
+			})
+		}
+		return azidentity.NewEnvironmentCredential(&azidentity.EnvironmentCredentialOptions{
+			ClientOptions: options,
+		})
+	case authWorkloadIdentity:
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: options,
+			TenantID:      tenantID,
+		})
+	case authManagedIdentity:
+		managedOptions := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: options}
+		if clientID := os.Getenv("AZURE_CLIENT_ID"); clientID != "" {
+			managedOptions.ID = azidentity.ClientID(clientID)
+		}
+		return azidentity.NewManagedIdentityCredential(managedOptions)
+	case authDeviceCode:
+		return azidentity.NewDeviceCodeCredential(&azidentity.DeviceCodeCredentialOptions{
+			ClientOptions: options,
+			TenantID:      tenantID,
+		})
+	case authBrowser:
+		return azidentity.NewInteractiveBrowserCredential(&azidentity.InteractiveBrowserCredentialOptions{
+			ClientOptions: options,
+			TenantID:      tenantID,
+		})
+	default:
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+			ClientOptions: options,
+			TenantID:      tenantID,
+		})
+	}
+}
+
+// azLoginArgs returns the az login arguments matching the credential source,
+// so the Azure CLI signs in as the same identity without a browser where the
+// source allows it. Secrets are passed as @file references that az reads, so
+// they never appear on its command line; cleanup removes any file written
+// for that and must be called once az has exited.
+func azLoginArgs(tenantID string) ([]string, func(), error) {
+	args := []string{"login"}
+	cleanup := func() {}
+	if tenantID == "" {
+		tenantID = os.Getenv("AZURE_TENANT_ID")
+	}
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+
+	switch currentAuthSource() {
+	case authEnvironment:
+		if clientID == "" {
+			break
+		}
+		if secret := os.Getenv("AZURE_CLIENT_SECRET"); secret != "" {
+			secretFile, remove, err := writeSecretFile(secret)
+			if err != nil {
+				return nil, nil, err
+			}
+			cleanup = remove
+			args = append(args, "--service-principal", "--username", clientID, "--password", "@"+secretFile)
+		} else if certificate := os.Getenv("AZURE_CLIENT_CERTIFICATE_PATH"); certificate != "" {
+			args = append(args, "--service-principal", "--username", clientID, "--certificate", certificate)
+		}
+	case authWorkloadIdentity:
+		if tokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"); tokenFile != "" && clientID != "" {
+			args = append(args, "--service-principal", "--username", clientID, "--federated-token", "@"+tokenFile)
+		}
+	case authManagedIdentity:
+		args = append(args, "--identity")
+		if clientID != "" {
+			args = append(args, "--username", clientID)
+		}
+		// A managed identity always signs in to its own tenant
+		return args, cleanup, nil
+	case authDeviceCode:
+		args = append(args, "--use-device-code")
+	}
+
+	if tenantID != "" {
+		args = append(args, "--tenant", tenantID)
+	}
+	return args, cleanup, nil
+}
+
+// writeSecretFile writes a secret to a file only the current user can read,
+// for handing it to az as an @file argument
+func writeSecretFile(secret string) (string, func(), error) {
+	file, err := os.CreateTemp("", "bastionbuddy-secret-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create secret file for az login: %v", err)
+	}
+	remove := func() { _ = os.Remove(file.Name()) }
+
+	if _, err := file.WriteString(secret); err != nil {
+		_ = file.Close()
+		remove()
+		return "", nil, fmt.Errorf("failed to write secret file for az login: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		remove()
+		return "", nil, fmt.Errorf("failed to write secret file for az login: %v", err)
+	}
+	return file.Name(), remove, nil
+}
+
+// ensureAzLogin makes sure the Azure CLI is signed in, to tenantID if one is
+// given, before an az-backed connection runs. Discovery only needs the SDK
+// credential, so this is checked just before az is used.
+func ensureAzLogin(tenantID string) error {
+	if err := ensureAzCloud(); err != nil {
+		return err
+	}
+
+	authErr := checkAzToken(tenantID)
+	if authErr == nil {
+		return nil
+	}
+
+	debugPrintf("Azure CLI token check failed: %v\n", authErr)
+	if tenantID != "" {
+		fmt.Printf("The Azure CLI is not signed in to tenant %s. Please follow the instructions to log in...\n", tenantID)
+	} else {
+		fmt.Println("The Azure CLI is not signed in. Please follow the instructions to log in...")
+	}
+	if err := azLogin(tenantID); err != nil {
+		return err
+	}
+
+	if authErr := checkAzToken(tenantID); authErr != nil {
+		return fmt.Errorf("login verification failed: %v", authErr)
+	}
+	return nil
+}
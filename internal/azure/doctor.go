@@ -0,0 +1,166 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/antnsn/BastionBuddy/internal/utils"
+)
+
+const (
+	// doctorTimeout bounds each network check
+	doctorTimeout = 20 * time.Second
+	// subscriptionsAPIVersion is the API version used to list subscriptions
+	subscriptionsAPIVersion = "2022-12-01"
+)
+
+// doctorCheck is one check run by the doctor command
+type doctorCheck struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+// RunDoctor checks that BastionBuddy can reach and sign in to Azure with the
+// configured cloud, proxy and CA bundle, printing one line per check
+func RunDoctor(w io.Writer) error {
+	checks := []doctorCheck{
+		{"Network settings", doctorNetworkSettings},
+		{"Resource Manager", doctorResourceManager},
+		{"Azure sign-in", doctorSignIn},
+		{"Subscriptions", doctorSubscriptions},
+		{"Azure CLI", doctorAzureCLI},
+	}
+
+	failed := 0
+	for _, check := range checks {
+		ctx, cancel := context.WithTimeout(withoutReauthPrompt(context.Background()), doctorTimeout)
+		detail, err := check.run(ctx)
+		cancel()
+		if err != nil {
+			failed++
+			if _, err := fmt.Fprintf(w, "✗ %s: %v\n", check.name, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "✓ %s: %s\n", check.name, detail); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// doctorNetworkSettings applies and describes the proxy and CA bundle
+func doctorNetworkSettings(_ context.Context) (string, error) {
+	settings, err := networkSettings()
+	if err != nil {
+		return "", err
+	}
+	if err := applyNetworkSettings(); err != nil {
+		return "", err
+	}
+
+	var parts []string
+	switch {
+	case settings.Proxy != "":
+		parts = append(parts, "proxy "+settings.Proxy)
+	case os.Getenv("HTTPS_PROXY") != "":
+		parts = append(parts, "proxy "+os.Getenv("HTTPS_PROXY")+" (from HTTPS_PROXY)")
+	default:
+		parts = append(parts, "no proxy")
+	}
+	if settings.CABundle != "" {
+		parts = append(parts, "CA bundle "+settings.CABundle)
+	} else {
+		parts = append(parts, "system CA certificates")
+	}
+	parts = append(parts, "cloud "+activeCloud().Name)
+	return strings.Join(parts, ", "), nil
+}
+
+// doctorResourceManager reaches the unauthenticated ARM metadata endpoint,
+// which shows whether the proxy and CA bundle let requests through
+func doctorResourceManager(ctx context.Context) (string, error) {
+	endpoint := fmt.Sprintf("%s/metadata/endpoints?api-version=%s", armEndpoint(), cloudMetadataAPIVersion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+
+	started := time.Now()
+	resp, err := armHTTPClient.Do(req)
+	if err != nil {
+		if strings.Contains(err.Error(), "x509:") || strings.Contains(err.Error(), "certificate") {
+			return "", fmt.Errorf("%v (a TLS-inspecting proxy needs its CA in the ca-bundle setting)", err)
+		}
+		return "", err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", armEndpoint(), resp.Status)
+	}
+	return fmt.Sprintf("%s reachable in %s", armEndpoint(), time.Since(started).Round(time.Millisecond)), nil
+}
+
+// doctorSignIn gets a Resource Manager token with the configured credential
+func doctorSignIn(ctx context.Context) (string, error) {
+	cred, err := GetAzureCredential()
+	if err != nil {
+		return "", err
+	}
+	if _, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{activeCloud().scope()}}); err != nil {
+		return "", err
+	}
+
+	identity, err := CurrentIdentity()
+	if err != nil || identity == nil {
+		return fmt.Sprintf("token acquired with %s credentials", currentAuthSource()), nil
+	}
+	return fmt.Sprintf("%s (%s credentials), token valid until %s", identity.Name, currentAuthSource(), identity.ExpiresOn.Local().Format("15:04")), nil
+}
+
+// doctorSubscriptions makes an authenticated Resource Manager request
+func doctorSubscriptions(ctx context.Context) (string, error) {
+	cred, err := GetAzureCredential()
+	if err != nil {
+		return "", err
+	}
+	subs, err := armList[struct {
+		SubscriptionID string `json:"subscriptionId"`
+	}](ctx, cred, "/subscriptions?api-version="+subscriptionsAPIVersion)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d subscriptions visible", len(subs)), nil
+}
+
+// doctorAzureCLI checks that az is installed and signed in; it is only
+// needed for SSH, RDP and tunnels
+func doctorAzureCLI(_ context.Context) (string, error) {
+	if _, err := exec.LookPath("az"); err != nil {
+		return "", fmt.Errorf("az is not installed; it is needed for SSH, RDP and tunnels")
+	}
+	if err := ensureAzCloud(); err != nil {
+		return "", err
+	}
+	if authErr := checkAzToken(currentTenantID()); authErr != nil {
+		return "", authErr
+	}
+
+	output, err := utils.PrepareAzureCommand("version", "--query", "\"azure-cli\"", "--output", "tsv").Output()
+	if err != nil {
+		return "signed in", nil
+	}
+	return fmt.Sprintf("version %s, signed in", strings.TrimSpace(string(output))), nil
+}
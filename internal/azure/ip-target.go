@@ -0,0 +1,138 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/antnsn/BastionBuddy/internal/config"
+	"github.com/antnsn/BastionBuddy/internal/utils"
+)
+
+// nicSearchQuery lists the private IPs of every network interface with the
+// virtual network and, if attached, the VM they belong to
+const nicSearchQuery = `Resources
+| where type =~ 'microsoft.network/networkinterfaces'
+| mv-expand ipConfiguration = properties.ipConfigurations
+| project name, resourceGroup, subscriptionId,
+	privateIp = tostring(ipConfiguration.properties.privateIPAddress),
+	subnetId = tostring(ipConfiguration.properties.subnet.id),
+	vmId = tostring(properties.virtualMachine.id)
+| where isnotempty(privateIp)
+| order by privateIp asc`
+
+// nicRecord is a network interface IP found by a Resource Graph search
+type nicRecord struct {
+	Name           string `json:"name"`
+	ResourceGroup  string `json:"resourceGroup"`
+	SubscriptionID string `json:"subscriptionId"`
+	PrivateIP      string `json:"privateIp"`
+	SubnetID       string `json:"subnetId"`
+	VMID           string `json:"vmId"`
+}
+
+// label renders a network interface IP for the picker
+func (n nicRecord) label() string {
+	label := fmt.Sprintf("%s | NIC: %s | Group: %s", n.PrivateIP, n.Name, n.ResourceGroup)
+	if vnet := vnetFromSubnet(n.SubnetID); vnet != "" {
+		label += " | VNet: " + resourceNameFromID(vnet)
+	}
+	if n.VMID != "" {
+		label += " | VM: " + resourceNameFromID(n.VMID)
+	}
+	return label
+}
+
+// getIPTarget lets the user enter a private IP address, or pick one from the
+// network interfaces in their subscriptions, for Bastion's IP-based connection
+func getIPTarget(ctx context.Context, cred azcore.TokenCredential) (*config.TargetResource, error) {
+	method, err := utils.SelectWithMenu([]string{"enter-ip-address", "pick-from-network-interfaces"}, "How would you like to specify the IP address?")
+	if err != nil {
+		if strings.Contains(err.Error(), "cancelled by user") {
+			fmt.Println("\nOperation cancelled by user")
+			os.Exit(0)
+		}
+		return nil, fmt.Errorf("failed to get selection method: %v", err)
+	}
+
+	var ip string
+	switch method {
+	case "enter-ip-address":
+		ip, err = readIPAddress()
+	case "pick-from-network-interfaces":
+		ip, err = selectNetworkInterfaceIP(ctx, cred)
+	default:
+		return nil, fmt.Errorf("invalid selection method: %s", method)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &config.TargetResource{
+		Name:      ip,
+		Type:      config.TargetTypeIP,
+		IPAddress: ip,
+	}, nil
+}
+
+// readIPAddress prompts for an IP address and validates it
+func readIPAddress() (string, error) {
+	input, err := utils.ReadInput("Enter private IP address")
+	if err != nil {
+		if strings.Contains(err.Error(), "cancelled by user") {
+			fmt.Println("\nOperation cancelled by user")
+			os.Exit(0)
+		}
+		return "", fmt.Errorf("failed to read IP address: %v", err)
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(input))
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address: %s", input)
+	}
+	return ip.String(), nil
+}
+
+// selectNetworkInterfaceIP lets the user pick a private IP from the network
+// interfaces in every subscription they can see
+func selectNetworkInterfaceIP(ctx context.Context, cred azcore.TokenCredential) (string, error) {
+	subs, err := getSubscriptions(ctx, cred)
+	if err != nil {
+		return "", err
+	}
+	subscriptionIDs := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		subscriptionIDs = append(subscriptionIDs, *sub.SubscriptionID)
+	}
+
+	nics, err := cachedDiscovery(ctx, "", "nic-search", func(ctx context.Context) ([]nicRecord, error) {
+		return queryResourceGraph[nicRecord](ctx, cred, subscriptionIDs, nicSearchQuery)
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(nics) == 0 {
+		return "", fmt.Errorf("no network interfaces found")
+	}
+
+	var items []string
+	ipMap := make(map[string]string)
+	for _, nic := range nics {
+		item := nic.label()
+		items = append(items, item)
+		ipMap[item] = nic.PrivateIP
+	}
+
+	selected, err := utils.SelectWithMenu(items, "Select IP address (type to filter)")
+	if err != nil {
+		if strings.Contains(err.Error(), "cancelled by user") {
+			fmt.Println("\nOperation cancelled by user")
+			os.Exit(0)
+		}
+		return "", fmt.Errorf("failed to select IP address: %v", err)
+	}
+	return ipMap[selected], nil
+}
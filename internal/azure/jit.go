@@ -0,0 +1,458 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/antnsn/BastionBuddy/internal/config"
+	"github.com/antnsn/BastionBuddy/internal/tunnels"
+)
+
+const (
+	// jitAPIVersion is the Microsoft.Security API version for just-in-time access
+	jitAPIVersion = "2020-01-01"
+	// jitDefaultDuration is requested when a configuration sets no duration
+	jitDefaultDuration = time.Hour
+	// jitApprovalTimeout bounds the wait for a request to take effect
+	jitApprovalTimeout = 5 * time.Minute
+)
+
+// jitPollInterval is the delay between checks of a pending request
+var jitPollInterval = 5 * time.Second
+
+// jitPolicy is a Defender for Cloud just-in-time network access policy
+type jitPolicy struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Properties struct {
+		VirtualMachines   []jitPolicyVM `json:"virtualMachines"`
+		Requests          []jitRequest  `json:"requests"`
+		ProvisioningState string        `json:"provisioningState"`
+	} `json:"properties"`
+}
+
+// jitPolicyVM is a VM covered by a policy and the ports it allows
+type jitPolicyVM struct {
+	ID    string          `json:"id"`
+	Ports []jitPolicyPort `json:"ports"`
+}
+
+// jitPolicyPort is a port that access can be requested for
+type jitPolicyPort struct {
+	Number                       int      `json:"number"`
+	AllowedSourceAddressPrefix   string   `json:"allowedSourceAddressPrefix,omitempty"`
+	AllowedSourceAddressPrefixes []string `json:"allowedSourceAddressPrefixes,omitempty"`
+	MaxRequestAccessDuration     string   `json:"maxRequestAccessDuration"`
+}
+
+// jitInitiateRequest is the body of an access request
+type jitInitiateRequest struct {
+	VirtualMachines []jitInitiateVM `json:"virtualMachines"`
+	Justification   string          `json:"justification,omitempty"`
+}
+
+// jitInitiateVM is the VM part of an access request
+type jitInitiateVM struct {
+	ID    string            `json:"id"`
+	Ports []jitInitiatePort `json:"ports"`
+}
+
+// jitInitiatePort is one requested port
+type jitInitiatePort struct {
+	Number                     int    `json:"number"`
+	Duration                   string `json:"duration"`
+	AllowedSourceAddressPrefix string `json:"allowedSourceAddressPrefix"`
+}
+
+// jitRequest is an access request as recorded on the policy
+type jitRequest struct {
+	VirtualMachines []jitRequestVM `json:"virtualMachines"`
+	StartTimeUtc    time.Time      `json:"startTimeUtc"`
+}
+
+// jitRequestVM is the VM part of a recorded request
+type jitRequestVM struct {
+	ID    string           `json:"id"`
+	Ports []jitRequestPort `json:"ports"`
+}
+
+// jitRequestPort is the status of one port of a recorded request
+type jitRequestPort struct {
+	Number       int       `json:"number"`
+	EndTimeUtc   time.Time `json:"endTimeUtc"`
+	Status       string    `json:"status"`
+	StatusReason string    `json:"statusReason"`
+}
+
+// requestSavedJITAccess opens just-in-time access to the target VM of a saved
+// configuration from its Bastion host's subnet, if the configuration asks for it
+func requestSavedJITAccess(saved tunnels.Config, target *config.TargetResource) error {
+	if saved.JIT == nil {
+		return nil
+	}
+	vmID := target.ConnectID()
+	if !isVirtualMachineID(vmID) {
+		return fmt.Errorf("just-in-time access applies to virtual machines only, not %s", target.Name)
+	}
+
+	ports := saved.JIT.Ports
+	if len(ports) == 0 {
+		ports = []int{connectionPort(saved)}
+	}
+	duration := jitDefaultDuration
+	if saved.JIT.Duration != "" {
+		d, err := time.ParseDuration(saved.JIT.Duration)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid just-in-time duration %q: expected e.g. 30m or 2h", saved.JIT.Duration)
+		}
+		duration = d
+	}
+	justification := saved.JIT.Justification
+	if justification == "" {
+		justification = fmt.Sprintf("BastionBuddy connection %s", saved.Name)
+	}
+
+	cred, err := GetAzureCredential()
+	if err != nil {
+		return fmt.Errorf("failed to get Azure credentials: %v", err)
+	}
+	ctx := context.Background()
+
+	source, err := bastionSubnetPrefix(ctx, cred, saved.BastionSubscriptionID, saved.BastionResourceGroup, saved.BastionName)
+	if err != nil {
+		return err
+	}
+	return requestJITAccess(ctx, cred, vmID, ports, source, duration, saved.JIT.Duration == "", justification)
+}
+
+// describeJIT renders the just-in-time access a saved configuration requests
+func describeJIT(saved tunnels.Config) string {
+	ports := saved.JIT.Ports
+	if len(ports) == 0 {
+		ports = []int{connectionPort(saved)}
+	}
+	duration := saved.JIT.Duration
+	if duration == "" {
+		duration = shortDuration(jitDefaultDuration)
+	}
+	return fmt.Sprintf("port %s for %s", joinPorts(ports), duration)
+}
+
+// connectionPort returns the VM port a saved configuration connects to
+func connectionPort(saved tunnels.Config) int {
+	switch saved.ConnectionType {
+	case "ssh":
+		return 22
+	case "rdp":
+		return 3389
+	default:
+		return saved.RemotePort
+	}
+}
+
+// requestJITAccess submits a just-in-time access request for ports on a VM
+// from source and waits until it is in effect. A default duration is
+// shortened to the policy maximum, an explicit one is not.
+func requestJITAccess(ctx context.Context, cred azcore.TokenCredential, vmID string, ports []int, source string, duration time.Duration, defaultDuration bool, justification string) error {
+	vmName := resourceNameFromID(vmID)
+	policy, policyVM, err := findJITPolicy(ctx, cred, vmID)
+	if err != nil {
+		return err
+	}
+
+	request := jitInitiateRequest{Justification: justification}
+	requestVM := jitInitiateVM{ID: policyVM.ID}
+	for _, port := range ports {
+		policyPort := policyVM.port(port)
+		if policyPort == nil {
+			return fmt.Errorf("the just-in-time policy for %s does not allow port %d (allowed: %s)", vmName, port, policyVM.portList())
+		}
+		if !policyPort.allowsSource(source) {
+			return fmt.Errorf("the just-in-time policy for %s only allows port %d from %s, not from the Bastion subnet %s",
+				vmName, port, policyPort.sources(), source)
+		}
+		portDuration := duration
+		if maxDuration, err := parseISODuration(policyPort.MaxRequestAccessDuration); err == nil && portDuration > maxDuration {
+			if !defaultDuration {
+				return fmt.Errorf("the just-in-time policy for %s allows port %d for at most %s", vmName, port, shortDuration(maxDuration))
+			}
+			portDuration = maxDuration
+		}
+		requestVM.Ports = append(requestVM.Ports, jitInitiatePort{
+			Number:                     port,
+			Duration:                   isoDuration(portDuration),
+			AllowedSourceAddressPrefix: source,
+		})
+	}
+	request.VirtualMachines = []jitInitiateVM{requestVM}
+
+	fmt.Printf("Requesting just-in-time access to %s (port %s) from %s...\n", vmName, joinPorts(ports), source)
+	requestedAt := time.Now().Add(-time.Minute)
+	if err := armRequest(ctx, cred, http.MethodPost, policy.ID+"/initiate?api-version="+jitAPIVersion, request, nil); err != nil {
+		return jitRequestError(vmName, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, jitApprovalTimeout)
+	defer cancel()
+	for {
+		granted, until, err := jitRequestStatus(ctx, cred, policy.ID, policyVM.ID, ports, requestedAt)
+		if err != nil {
+			return fmt.Errorf("just-in-time access to %s: %v", vmName, err)
+		}
+		if granted {
+			fmt.Printf("✓ Just-in-time access to %s granted until %s\n", vmName, until.Local().Format("15:04"))
+			return nil
+		}
+
+		debugPrintf("Waiting for just-in-time access to %s\n", vmName)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("just-in-time access to %s was not granted within %s", vmName, shortDuration(jitApprovalTimeout))
+		case <-time.After(jitPollInterval):
+		}
+	}
+}
+
+// findJITPolicy returns the policy covering a VM in its subscription
+func findJITPolicy(ctx context.Context, cred azcore.TokenCredential, vmID string) (*jitPolicy, *jitPolicyVM, error) {
+	id, err := parseResourceID(vmID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path := fmt.Sprintf("/subscriptions/%s/providers/Microsoft.Security/jitNetworkAccessPolicies?api-version=%s", id.SubscriptionID, jitAPIVersion)
+	policies, err := armList[jitPolicy](ctx, cred, path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list just-in-time access policies: %v", err)
+	}
+	for i := range policies {
+		for j := range policies[i].Properties.VirtualMachines {
+			if strings.EqualFold(policies[i].Properties.VirtualMachines[j].ID, vmID) {
+				return &policies[i], &policies[i].Properties.VirtualMachines[j], nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("%s is not covered by a just-in-time access policy; enable JIT for it in Defender for Cloud or turn off jit for the configuration", resourceNameFromID(vmID))
+}
+
+// jitRequestStatus reports whether a request made after requestedAt is in
+// effect for every port, and when the access ends
+func jitRequestStatus(ctx context.Context, cred azcore.TokenCredential, policyID string, vmID string, ports []int, requestedAt time.Time) (bool, time.Time, error) {
+	var policy jitPolicy
+	if err := armRequest(ctx, cred, http.MethodGet, policyID+"?api-version="+jitAPIVersion, nil, &policy); err != nil {
+		return false, time.Time{}, err
+	}
+
+	// The latest request for the VM is ours
+	var latest *jitRequestVM
+	var latestStart time.Time
+	for _, request := range policy.Properties.Requests {
+		if request.StartTimeUtc.Before(requestedAt) || request.StartTimeUtc.Before(latestStart) {
+			continue
+		}
+		for i := range request.VirtualMachines {
+			if strings.EqualFold(request.VirtualMachines[i].ID, vmID) {
+				latest = &request.VirtualMachines[i]
+				latestStart = request.StartTimeUtc
+			}
+		}
+	}
+	if latest == nil {
+		return false, time.Time{}, nil
+	}
+
+	var until time.Time
+	for _, port := range ports {
+		var status *jitRequestPort
+		for i := range latest.Ports {
+			if latest.Ports[i].Number == port {
+				status = &latest.Ports[i]
+			}
+		}
+		if status == nil {
+			return false, time.Time{}, nil
+		}
+		if strings.EqualFold(status.Status, "Revoked") {
+			return false, time.Time{}, fmt.Errorf("request for port %d was revoked (%s)", port, status.StatusReason)
+		}
+		if !strings.EqualFold(status.Status, "Initiated") {
+			return false, time.Time{}, nil
+		}
+		if until.IsZero() || status.EndTimeUtc.Before(until) {
+			until = status.EndTimeUtc
+		}
+	}
+	// Network security group rules are in place once the policy has been applied
+	return strings.EqualFold(policy.Properties.ProvisioningState, "Succeeded"), until, nil
+}
+
+// jitRequestError explains why Azure refused an access request
+func jitRequestError(vmName string, err error) error {
+	var respErr *armResponseError
+	if errors.As(err, &respErr) {
+		switch {
+		case respErr.Code == "RequestDisallowedByPolicy":
+			return fmt.Errorf("just-in-time access to %s was denied by Azure Policy: %s", vmName, respErr.Message)
+		case respErr.StatusCode == http.StatusForbidden:
+			return fmt.Errorf("just-in-time access to %s was denied: you are not allowed to request access (%s)", vmName, respErr.Message)
+		case respErr.StatusCode == http.StatusBadRequest:
+			return fmt.Errorf("just-in-time access request for %s was rejected by its policy: %s", vmName, respErr.Message)
+		}
+	}
+	return fmt.Errorf("failed to request just-in-time access to %s: %v", vmName, err)
+}
+
+// bastionSubnetPrefix returns the address prefix of the subnet a Bastion host
+// is deployed into, which is where its connections to VMs come from
+func bastionSubnetPrefix(ctx context.Context, cred azcore.TokenCredential, subscriptionID string, resourceGroup string, name string) (string, error) {
+	bastionID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/bastionHosts/%s", subscriptionID, resourceGroup, name)
+	var bastion map[string]interface{}
+	if err := armRequest(ctx, cred, http.MethodGet, bastionID+"?api-version="+networkAPIVersion, nil, &bastion); err != nil {
+		return "", fmt.Errorf("failed to read Bastion host %s: %v", name, err)
+	}
+
+	var subnetID string
+	if ipConfigs := propertyList(bastion, "properties", "ipConfigurations"); len(ipConfigs) > 0 {
+		subnetID = propertyString(ipConfigs[0], "properties", "subnet", "id")
+	}
+	if subnetID == "" {
+		return "", fmt.Errorf("bastion host %s is not deployed into a subnet (Developer SKU), so just-in-time access cannot be limited to it", name)
+	}
+
+	var subnet map[string]interface{}
+	if err := armRequest(ctx, cred, http.MethodGet, subnetID+"?api-version="+networkAPIVersion, nil, &subnet); err != nil {
+		return "", fmt.Errorf("failed to read Bastion subnet: %v", err)
+	}
+	if prefix := propertyString(subnet, "properties", "addressPrefix"); prefix != "" {
+		return prefix, nil
+	}
+	if prefixes := propertyList(subnet, "properties", "addressPrefixes"); len(prefixes) > 0 {
+		if prefix, ok := prefixes[0].(string); ok {
+			return prefix, nil
+		}
+	}
+	return "", fmt.Errorf("bastion subnet %s has no address prefix", resourceNameFromID(subnetID))
+}
+
+// port returns the policy entry for a port, or nil if it is not allowed
+func (vm *jitPolicyVM) port(number int) *jitPolicyPort {
+	for i := range vm.Ports {
+		if vm.Ports[i].Number == number {
+			return &vm.Ports[i]
+		}
+	}
+	return nil
+}
+
+// portList renders the ports a policy allows
+func (vm *jitPolicyVM) portList() string {
+	var ports []int
+	for _, port := range vm.Ports {
+		ports = append(ports, port.Number)
+	}
+	sort.Ints(ports)
+	return joinPorts(ports)
+}
+
+// allowsSource reports whether access may be requested from source, an
+// address or CIDR prefix: an allowed prefix must contain it. "*" allows any
+// source; a service tag such as VirtualNetwork cannot be checked here and is
+// left for Azure to enforce.
+func (p *jitPolicyPort) allowsSource(source string) bool {
+	sourcePrefix, err := parsePrefix(source)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range append([]string{p.AllowedSourceAddressPrefix}, p.AllowedSourceAddressPrefixes...) {
+		switch {
+		case allowed == "":
+			continue
+		case allowed == "*":
+			return true
+		}
+		allowedPrefix, err := parsePrefix(allowed)
+		if err != nil {
+			debugPrintf("Leaving just-in-time source %s to Azure: %s is not an address prefix\n", source, allowed)
+			return true
+		}
+		if allowedPrefix.Bits() <= sourcePrefix.Bits() && allowedPrefix.Contains(sourcePrefix.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefix parses a CIDR prefix or a single address
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// sources renders the source addresses a policy port allows
+func (p *jitPolicyPort) sources() string {
+	if p.AllowedSourceAddressPrefix != "" {
+		return p.AllowedSourceAddressPrefix
+	}
+	return strings.Join(p.AllowedSourceAddressPrefixes, ", ")
+}
+
+// joinPorts renders a list of ports as "22, 3389"
+func joinPorts(ports []int) string {
+	parts := make([]string, len(ports))
+	for i, port := range ports {
+		parts[i] = strconv.Itoa(port)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// isoDuration renders a duration as an ISO 8601 time duration, e.g. PT1H30M
+func isoDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < time.Minute {
+		d = time.Minute
+	}
+	s := "PT"
+	if hours := int(d / time.Hour); hours > 0 {
+		s += fmt.Sprintf("%dH", hours)
+	}
+	if minutes := int(d%time.Hour) / int(time.Minute); minutes > 0 {
+		s += fmt.Sprintf("%dM", minutes)
+	}
+	return s
+}
+
+// shortDuration renders a duration in whole minutes, e.g. 1h30m
+func shortDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	hours, minutes := int(d/time.Hour), int(d%time.Hour/time.Minute)
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("%dm", minutes)
+	case minutes == 0:
+		return fmt.Sprintf("%dh", hours)
+	}
+	return fmt.Sprintf("%dh%dm", hours, minutes)
+}
+
+// parseISODuration parses an ISO 8601 time duration such as PT3H
+func parseISODuration(s string) (time.Duration, error) {
+	if !strings.HasPrefix(strings.ToUpper(s), "PT") {
+		return 0, fmt.Errorf("unsupported duration %q", s)
+	}
+	return time.ParseDuration(strings.ToLower(s[2:]))
+}
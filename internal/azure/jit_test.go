@@ -0,0 +1,250 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testVMID     = "/subscriptions/sub-1/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"
+	testPolicyID = "/subscriptions/sub-1/resourceGroups/rg/providers/Microsoft.Security/locations/westeurope/jitNetworkAccessPolicies/default"
+)
+
+// jitStandIn is a loopback Microsoft.Security stand-in with one policy
+// covering testVMID
+type jitStandIn struct {
+	mu sync.Mutex
+	// ports are the policy's ports
+	ports []jitPolicyPort
+	// initiateStatus, if set, is returned instead of accepting a request
+	initiateStatus int
+	// pendingPolls is how many status checks see the request still pending
+	pendingPolls int
+	// portStatus is the status the request ends in
+	portStatus string
+
+	initiateCalls int
+	initiated     *jitInitiateRequest
+	started       time.Time
+	polls         int
+}
+
+func newJITStandIn(t *testing.T, s *jitStandIn) {
+	t.Helper()
+	previous := jitPollInterval
+	jitPollInterval = time.Millisecond
+	t.Cleanup(func() { jitPollInterval = previous })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/subscriptions/sub-1/providers/Microsoft.Security/jitNetworkAccessPolicies", func(w http.ResponseWriter, r *http.Request) {
+		requireBearer(t, r)
+		if r.Method != http.MethodGet || r.URL.Query().Get("api-version") != jitAPIVersion {
+			t.Errorf("unexpected policy list %s %s", r.Method, r.URL)
+		}
+		policy := s.policy(false)
+		writeJSON(t, w, map[string]interface{}{"value": []jitPolicy{policy}})
+	})
+	mux.HandleFunc(testPolicyID+"/initiate", func(w http.ResponseWriter, r *http.Request) {
+		requireBearer(t, r)
+		if r.Method != http.MethodPost {
+			t.Errorf("initiate: method %s", r.Method)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.initiateCalls++
+		if s.initiateStatus != 0 {
+			w.WriteHeader(s.initiateStatus)
+			_, _ = fmt.Fprint(w, `{"error":{"code":"AuthorizationFailed","message":"no Microsoft.Security/locations/jitNetworkAccessPolicies/initiate/action"}}`)
+			return
+		}
+		var request jitInitiateRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("initiate: %v", err)
+		}
+		s.initiated = &request
+		s.started = time.Now().UTC()
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc(testPolicyID, func(w http.ResponseWriter, r *http.Request) {
+		requireBearer(t, r)
+		s.mu.Lock()
+		s.polls++
+		pending := s.polls <= s.pendingPolls
+		s.mu.Unlock()
+		writeJSON(t, w, s.policy(pending))
+	})
+	newStandIn(t, mux)
+}
+
+// policy renders the policy, with the request made so far
+func (s *jitStandIn) policy(pending bool) jitPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var policy jitPolicy
+	policy.ID = testPolicyID
+	policy.Name = "default"
+	policy.Properties.ProvisioningState = "Succeeded"
+	policy.Properties.VirtualMachines = []jitPolicyVM{{ID: testVMID, Ports: s.ports}}
+	if s.initiated == nil {
+		return policy
+	}
+
+	status := s.portStatus
+	if pending {
+		// The rules are still being applied
+		status = "Initiated"
+		policy.Properties.ProvisioningState = "Updating"
+	}
+	request := jitRequest{StartTimeUtc: s.started}
+	for _, vm := range s.initiated.VirtualMachines {
+		requestVM := jitRequestVM{ID: vm.ID}
+		for _, port := range vm.Ports {
+			requestVM.Ports = append(requestVM.Ports, jitRequestPort{
+				Number:       port.Number,
+				EndTimeUtc:   s.started.Add(time.Hour),
+				Status:       status,
+				StatusReason: "UserRequested",
+			})
+		}
+		request.VirtualMachines = append(request.VirtualMachines, requestVM)
+	}
+	policy.Properties.Requests = []jitRequest{request}
+	return policy
+}
+
+func TestRequestJITAccessGranted(t *testing.T) {
+	s := &jitStandIn{
+		ports:        []jitPolicyPort{{Number: 22, AllowedSourceAddressPrefix: "10.0.0.0/16", MaxRequestAccessDuration: "PT30M"}},
+		pendingPolls: 2,
+		portStatus:   "Initiated",
+	}
+	newJITStandIn(t, s)
+
+	err := requestJITAccess(context.Background(), &fakeCred{}, testVMID, []int{22}, "10.0.1.0/26", time.Hour, true, "maintenance")
+	if err != nil {
+		t.Fatalf("requestJITAccess: %v", err)
+	}
+
+	if s.initiated == nil {
+		t.Fatal("no access request was initiated")
+	}
+	if s.initiated.Justification != "maintenance" {
+		t.Errorf("justification = %q", s.initiated.Justification)
+	}
+	vms := s.initiated.VirtualMachines
+	if len(vms) != 1 || vms[0].ID != testVMID || len(vms[0].Ports) != 1 {
+		t.Fatalf("request = %+v", s.initiated)
+	}
+	want := jitInitiatePort{Number: 22, Duration: "PT30M", AllowedSourceAddressPrefix: "10.0.1.0/26"}
+	if vms[0].Ports[0] != want {
+		t.Errorf("port = %+v, want %+v (default duration shortened to the policy maximum)", vms[0].Ports[0], want)
+	}
+	if s.polls != s.pendingPolls+1 {
+		t.Errorf("status checked %d times, want %d", s.polls, s.pendingPolls+1)
+	}
+}
+
+func TestRequestJITAccessRefused(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        *jitStandIn
+		ports    []int
+		duration time.Duration
+		explicit bool
+		wantErr  string
+		initiate bool
+	}{
+		{
+			name:    "port not in policy",
+			s:       &jitStandIn{ports: []jitPolicyPort{{Number: 3389, AllowedSourceAddressPrefix: "*"}}},
+			ports:   []int{22},
+			wantErr: "does not allow port 22 (allowed: 3389)",
+		},
+		{
+			name:    "source outside allowed prefix",
+			s:       &jitStandIn{ports: []jitPolicyPort{{Number: 22, AllowedSourceAddressPrefix: "192.168.0.0/24"}}},
+			ports:   []int{22},
+			wantErr: "only allows port 22 from 192.168.0.0/24",
+		},
+		{
+			name:     "explicit duration over maximum",
+			s:        &jitStandIn{ports: []jitPolicyPort{{Number: 22, AllowedSourceAddressPrefix: "*", MaxRequestAccessDuration: "PT1H"}}},
+			ports:    []int{22},
+			duration: 3 * time.Hour,
+			explicit: true,
+			wantErr:  "allows port 22 for at most 1h",
+		},
+		{
+			name:     "initiate forbidden",
+			s:        &jitStandIn{ports: []jitPolicyPort{{Number: 22, AllowedSourceAddressPrefix: "*"}}, initiateStatus: http.StatusForbidden},
+			ports:    []int{22},
+			wantErr:  "you are not allowed to request access",
+			initiate: true,
+		},
+		{
+			name:     "request revoked",
+			s:        &jitStandIn{ports: []jitPolicyPort{{Number: 22, AllowedSourceAddressPrefix: "*"}}, portStatus: "Revoked", pendingPolls: 1},
+			ports:    []int{22},
+			wantErr:  "request for port 22 was revoked (UserRequested)",
+			initiate: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newJITStandIn(t, tt.s)
+			duration := tt.duration
+			if duration == 0 {
+				duration = time.Hour
+			}
+			err := requestJITAccess(context.Background(), &fakeCred{}, testVMID, tt.ports, "10.0.1.0/26", duration, !tt.explicit, "")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+			if got := tt.s.initiateCalls > 0; got != tt.initiate {
+				t.Errorf("initiated = %v, want %v", got, tt.initiate)
+			}
+		})
+	}
+}
+
+func TestFindJITPolicyNotCovered(t *testing.T) {
+	newJITStandIn(t, &jitStandIn{})
+	_, _, err := findJITPolicy(context.Background(), &fakeCred{}, strings.Replace(testVMID, "vm1", "vm2", 1))
+	if err == nil || !strings.Contains(err.Error(), "vm2 is not covered by a just-in-time access policy") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestAllowsSource(t *testing.T) {
+	tests := []struct {
+		port   jitPolicyPort
+		source string
+		want   bool
+	}{
+		{jitPolicyPort{AllowedSourceAddressPrefix: "*"}, "10.0.1.0/26", true},
+		{jitPolicyPort{AllowedSourceAddressPrefix: "10.0.0.0/16"}, "10.0.1.0/26", true},
+		{jitPolicyPort{AllowedSourceAddressPrefix: "10.0.1.0/26"}, "10.0.1.0/26", true},
+		{jitPolicyPort{AllowedSourceAddressPrefix: "10.0.1.0/27"}, "10.0.1.0/26", false},
+		{jitPolicyPort{AllowedSourceAddressPrefix: "10.1.0.0/16"}, "10.0.1.0/26", false},
+		{jitPolicyPort{AllowedSourceAddressPrefix: "10.0.1.4"}, "10.0.1.4", true},
+		{jitPolicyPort{AllowedSourceAddressPrefix: "10.0.1.0/24"}, "10.0.1.4", true},
+		{jitPolicyPort{AllowedSourceAddressPrefixes: []string{"192.168.0.0/24", "10.0.0.0/8"}}, "10.0.1.0/26", true},
+		{jitPolicyPort{AllowedSourceAddressPrefixes: []string{"192.168.0.0/24"}}, "10.0.1.0/26", false},
+		{jitPolicyPort{AllowedSourceAddressPrefix: "VirtualNetwork"}, "10.0.1.0/26", true},
+		{jitPolicyPort{AllowedSourceAddressPrefix: "fd00::/8"}, "10.0.1.0/26", false},
+		{jitPolicyPort{}, "10.0.1.0/26", false},
+		{jitPolicyPort{AllowedSourceAddressPrefix: "*"}, "not-an-address", false},
+	}
+	for _, tt := range tests {
+		if got := tt.port.allowsSource(tt.source); got != tt.want {
+			t.Errorf("allowsSource(%q) with %q %v = %v, want %v",
+				tt.source, tt.port.AllowedSourceAddressPrefix, tt.port.AllowedSourceAddressPrefixes, got, tt.want)
+		}
+	}
+}
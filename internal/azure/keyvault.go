@@ -0,0 +1,199 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/antnsn/BastionBuddy/internal/tunnels"
+)
+
+const (
+	// keyVaultAPIVersion is the Key Vault data-plane API version
+	keyVaultAPIVersion = "7.4"
+	// keyVaultARMAPIVersion is the API version for reading vault resources through ARM
+	keyVaultARMAPIVersion = "2022-07-01"
+	// resourcesAPIVersion is the API version for listing resources through ARM
+	resourcesAPIVersion = "2021-04-01"
+)
+
+// keyVaultHTTPClient is used for Key Vault data-plane requests
+var keyVaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// readKeyVaultSecret fetches the secret a saved configuration references,
+// keeping it in memory only
+func readKeyVaultSecret(ref *tunnels.KeyVaultSecret) (string, error) {
+	cred, err := GetAzureCredential()
+	if err != nil {
+		return "", fmt.Errorf("failed to get Azure credentials: %v", err)
+	}
+
+	ctx := context.Background()
+	vaultURL, err := resolveVaultURL(ctx, cred, ref)
+	if err != nil {
+		return "", err
+	}
+
+	debugPrintf("Fetching secret %s from %s\n", ref.Secret, vaultURL)
+	return fetchKeyVaultSecret(ctx, keyVaultHTTPClient, cred, vaultURL, ref.Secret)
+}
+
+// resolveVaultURL returns the data-plane URI of the referenced vault. An
+// explicit URL wins; with a subscription the URI is looked up through ARM;
+// otherwise the naming convention of the cloud in use is used. Whatever the
+// source, the URI must belong to the Key Vault service of that cloud.
+func resolveVaultURL(ctx context.Context, cred azcore.TokenCredential, ref *tunnels.KeyVaultSecret) (string, error) {
+	if ref.VaultURL != "" {
+		vaultURL := strings.TrimSuffix(ref.VaultURL, "/")
+		if err := validateVaultURL(vaultURL); err != nil {
+			return "", err
+		}
+		return vaultURL, nil
+	}
+	if ref.Vault == "" {
+		return "", fmt.Errorf("key vault name is required")
+	}
+	if ref.SubscriptionID == "" {
+		return fmt.Sprintf("https://%s.%s", ref.Vault, activeCloud().KeyVaultSuffix), nil
+	}
+
+	filter := fmt.Sprintf("resourceType eq 'Microsoft.KeyVault/vaults' and name eq '%s'", ref.Vault)
+	resources, err := armList[struct {
+		ID string `json:"id"`
+	}](ctx, cred, fmt.Sprintf("/subscriptions/%s/resources?$filter=%s&api-version=%s",
+		ref.SubscriptionID, url.QueryEscape(filter), resourcesAPIVersion))
+	if err != nil {
+		return "", fmt.Errorf("failed to look up key vault %s: %v", ref.Vault, err)
+	}
+	for _, res := range resources {
+		if res.ID == "" {
+			continue
+		}
+		var vault struct {
+			Properties struct {
+				VaultURI string `json:"vaultUri"`
+			} `json:"properties"`
+		}
+		if err := armRequest(ctx, cred, http.MethodGet, res.ID+"?api-version="+keyVaultARMAPIVersion, nil, &vault); err != nil {
+			return "", fmt.Errorf("failed to read key vault %s: %v", ref.Vault, err)
+		}
+		if vault.Properties.VaultURI != "" {
+			vaultURL := strings.TrimSuffix(vault.Properties.VaultURI, "/")
+			if err := validateVaultURL(vaultURL); err != nil {
+				return "", err
+			}
+			return vaultURL, nil
+		}
+	}
+
+	return "", fmt.Errorf("key vault %s not found in subscription %s", ref.Vault, ref.SubscriptionID)
+}
+
+// validateVaultURL rejects a vault URI that is not https on a host under the
+// Key Vault DNS suffix of the cloud in use, or its private link zone, so a
+// configuration cannot send a Key Vault token elsewhere. A loopback URI is
+// accepted only while the cloud itself is a loopback stand-in.
+func validateVaultURL(vaultURL string) error {
+	parsed, err := url.Parse(vaultURL)
+	if err != nil {
+		return fmt.Errorf("invalid key vault URL %s: %v", vaultURL, err)
+	}
+	if isLoopbackEndpoint(vaultURL) && isLoopbackEndpoint(activeCloud().ResourceManager) {
+		return nil
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	suffix := strings.ToLower(activeCloud().KeyVaultSuffix)
+	if parsed.Scheme != "https" || parsed.User != nil || suffix == "" {
+		return fmt.Errorf("key vault URL %s is not an https Key Vault endpoint", vaultURL)
+	}
+	for _, zone := range keyVaultZones(suffix) {
+		if name, ok := strings.CutSuffix(host, "."+zone); ok && name != "" && !strings.Contains(name, ".") {
+			return nil
+		}
+	}
+	return fmt.Errorf("key vault URL %s is not under %s for cloud %s", vaultURL, suffix, activeCloud().Name)
+}
+
+// keyVaultZones returns the DNS zones vaults of a cloud live in: the public
+// suffix, e.g. vault.azure.net, and its private link zone,
+// privatelink.vaultcore.azure.net
+func keyVaultZones(suffix string) []string {
+	zones := []string{suffix, "privatelink." + suffix}
+	if rest, ok := strings.CutPrefix(suffix, "vault."); ok {
+		zones = append(zones, "privatelink.vaultcore."+rest)
+	}
+	return zones
+}
+
+// fetchKeyVaultSecret reads the current version of a secret from the Key Vault
+// secrets endpoint (GET {vault}/secrets/{name})
+func fetchKeyVaultSecret(ctx context.Context, client *http.Client, cred azcore.TokenCredential, vaultURL string, secretName string) (string, error) {
+	scope, err := keyVaultScope(vaultURL)
+	if err != nil {
+		return "", err
+	}
+	token, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{scope}})
+	if err != nil {
+		return "", fmt.Errorf("failed to get Key Vault token: %v", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/secrets/%s?api-version=%s", vaultURL, url.PathEscape(secretName), keyVaultAPIVersion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create Key Vault request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach Key Vault: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Key Vault response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var kvError struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(body, &kvError) == nil && kvError.Error.Code != "" {
+			return "", fmt.Errorf("key vault returned %d %s: %s", resp.StatusCode, kvError.Error.Code, kvError.Error.Message)
+		}
+		return "", fmt.Errorf("key vault returned %s", resp.Status)
+	}
+
+	var secret struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", fmt.Errorf("failed to parse Key Vault secret: %v", err)
+	}
+	if secret.Value == "" {
+		return "", fmt.Errorf("key vault secret %s is empty", secretName)
+	}
+	return secret.Value, nil
+}
+
+// keyVaultScope returns the token scope for a vault URI of the cloud in use,
+// e.g. https://myvault.vault.azure.net -> https://vault.azure.net/.default
+func keyVaultScope(vaultURL string) (string, error) {
+	if err := validateVaultURL(vaultURL); err != nil {
+		return "", err
+	}
+	return "https://" + activeCloud().KeyVaultSuffix + "/.default", nil
+}
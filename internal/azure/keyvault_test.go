@@ -0,0 +1,166 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/antnsn/BastionBuddy/internal/tunnels"
+)
+
+// fakeCred hands out a fixed token and records the scopes asked for
+type fakeCred struct {
+	scopes []string
+}
+
+func (c *fakeCred) GetToken(_ context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.scopes = append(c.scopes, options.Scopes...)
+	return azcore.AccessToken{Token: "test-token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestFetchKeyVaultSecret(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/secrets/", func(w http.ResponseWriter, r *http.Request) {
+		requireBearer(t, r)
+		if r.URL.Query().Get("api-version") != keyVaultAPIVersion {
+			t.Errorf("api-version = %q", r.URL.Query().Get("api-version"))
+		}
+		switch r.URL.Path {
+		case "/secrets/vm-password":
+			_, _ = fmt.Fprint(w, `{"value":"s3cret","id":"vm-password/1"}`)
+		case "/secrets/denied":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = fmt.Fprint(w, `{"error":{"code":"Forbidden","message":"caller lacks secrets/get"}}`)
+		case "/secrets/empty":
+			_, _ = fmt.Fprint(w, `{"value":""}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprint(w, `{"error":{"code":"SecretNotFound","message":"not found"}}`)
+		}
+	})
+	srv := newStandIn(t, mux)
+
+	tests := []struct {
+		secret  string
+		want    string
+		wantErr string
+	}{
+		{secret: "vm-password", want: "s3cret"},
+		{secret: "denied", wantErr: "403 Forbidden"},
+		{secret: "missing", wantErr: "404 SecretNotFound"},
+		{secret: "empty", wantErr: "is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.secret, func(t *testing.T) {
+			cred := &fakeCred{}
+			got, err := fetchKeyVaultSecret(context.Background(), srv.Client(), cred, srv.URL, tt.secret)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchKeyVaultSecret: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("secret = %q, want %q", got, tt.want)
+			}
+			if len(cred.scopes) != 1 || cred.scopes[0] != "https://vault.example/.default" {
+				t.Errorf("scopes = %v", cred.scopes)
+			}
+		})
+	}
+}
+
+func TestResolveVaultURL(t *testing.T) {
+	var vaultURI string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/subscriptions/sub-1/resources", func(w http.ResponseWriter, r *http.Request) {
+		requireBearer(t, r)
+		switch filter := r.URL.Query().Get("$filter"); {
+		case strings.Contains(filter, "name eq 'team-kv'"):
+			_, _ = fmt.Fprint(w, `{"value":[{"id":"/subscriptions/sub-1/resourceGroups/rg/providers/Microsoft.KeyVault/vaults/team-kv"}]}`)
+		default:
+			_, _ = fmt.Fprint(w, `{"value":[]}`)
+		}
+	})
+	mux.HandleFunc("/subscriptions/sub-1/resourceGroups/rg/providers/Microsoft.KeyVault/vaults/team-kv", func(w http.ResponseWriter, r *http.Request) {
+		requireBearer(t, r)
+		_, _ = fmt.Fprintf(w, `{"properties":{"vaultUri":%q}}`, vaultURI)
+	})
+	newStandIn(t, mux)
+	ctx := context.Background()
+
+	t.Run("found", func(t *testing.T) {
+		vaultURI = "https://team-kv.vault.example/"
+		got, err := resolveVaultURL(ctx, &fakeCred{}, &tunnels.KeyVaultSecret{Vault: "team-kv", SubscriptionID: "sub-1"})
+		if err != nil {
+			t.Fatalf("resolveVaultURL: %v", err)
+		}
+		if got != "https://team-kv.vault.example" {
+			t.Errorf("vault URL = %q", got)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := resolveVaultURL(ctx, &fakeCred{}, &tunnels.KeyVaultSecret{Vault: "other-kv", SubscriptionID: "sub-1"})
+		if err == nil || !strings.Contains(err.Error(), "key vault other-kv not found in subscription sub-1") {
+			t.Fatalf("err = %v", err)
+		}
+	})
+
+	t.Run("foreign vaultUri", func(t *testing.T) {
+		vaultURI = "https://team-kv.attacker.example/"
+		_, err := resolveVaultURL(ctx, &fakeCred{}, &tunnels.KeyVaultSecret{Vault: "team-kv", SubscriptionID: "sub-1"})
+		if err == nil {
+			t.Fatal("expected a vault URI outside the Key Vault suffix to be rejected")
+		}
+	})
+
+	t.Run("by name", func(t *testing.T) {
+		got, err := resolveVaultURL(ctx, &fakeCred{}, &tunnels.KeyVaultSecret{Vault: "team-kv"})
+		if err != nil || got != "https://team-kv.vault.example" {
+			t.Errorf("resolveVaultURL = %q, %v", got, err)
+		}
+	})
+}
+
+func TestValidateVaultURL(t *testing.T) {
+	previous := sessionCloud
+	sessionCloud = "AzurePublic"
+	t.Cleanup(func() { sessionCloud = previous })
+
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://myvault.vault.azure.net", true},
+		{"https://MyVault.Vault.Azure.Net", true},
+		{"https://myvault.privatelink.vaultcore.azure.net", true},
+		{"https://myvault.vault.azure.net:443", true},
+		{"http://myvault.vault.azure.net", false},
+		{"https://vault.azure.net", false},
+		{"https://a.b.vault.azure.net", false},
+		{"https://myvault.vault.azure.net.attacker.example", false},
+		{"https://myvault.vault.usgovcloudapi.net", false},
+		{"https://user@myvault.vault.azure.net", false},
+		{"https://attacker.example", false},
+		{"http://127.0.0.1:8080", false},
+		{"not a url", false},
+	}
+	for _, tt := range tests {
+		err := validateVaultURL(tt.url)
+		if (err == nil) != tt.ok {
+			t.Errorf("validateVaultURL(%q) = %v, want ok=%v", tt.url, err, tt.ok)
+		}
+		if _, err := keyVaultScope(tt.url); (err == nil) != tt.ok {
+			t.Errorf("keyVaultScope(%q) = %v, want ok=%v", tt.url, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package azure
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/antnsn/BastionBuddy/internal/config"
+)
+
+// sdkHTTPClient is the transport of SDK clients and credentials when a proxy
+// or CA bundle is configured, and nil for the SDK default otherwise
+var sdkHTTPClient *http.Client
+
+// networkSettings returns the proxy and CA bundle settings: the global
+// settings, overridden by the active profile
+func networkSettings() (*config.Settings, error) {
+	settings, err := config.LoadSettings()
+	if err != nil {
+		return nil, err
+	}
+	profile, err := config.ActiveProfile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load profile: %v", err)
+	}
+	if profile != nil {
+		if profile.Proxy != "" {
+			settings.Proxy = profile.Proxy
+		}
+		if profile.CABundle != "" {
+			settings.CABundle = profile.CABundle
+		}
+	}
+	return settings, nil
+}
+
+// applyNetworkSettings routes Azure requests through the configured proxy
+// and trusts the configured CA bundle. The settings are exported as
+// HTTPS_PROXY, NO_PROXY and REQUESTS_CA_BUNDLE, the latter pointing at a copy
+// that adds the system roots, so az child processes use them too. It must run
+// before the first request.
+func applyNetworkSettings() error {
+	settings, err := networkSettings()
+	if err != nil {
+		return err
+	}
+	if settings.Proxy == "" && settings.CABundle == "" {
+		return nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if settings.Proxy != "" {
+		proxyURL, err := url.Parse(settings.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q: expected http://host:port", settings.Proxy)
+		}
+		debugPrintf("Using proxy %s\n", proxyURL.Redacted())
+		if err := os.Setenv("HTTPS_PROXY", settings.Proxy); err != nil {
+			return fmt.Errorf("failed to set HTTPS_PROXY: %v", err)
+		}
+		if settings.NoProxy != "" {
+			if err := os.Setenv("NO_PROXY", settings.NoProxy); err != nil {
+				return fmt.Errorf("failed to set NO_PROXY: %v", err)
+			}
+		}
+		// Read the environment set above; http.ProxyFromEnvironment caches it
+		// on first use, which may already have happened
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if !useProxy(req.URL.Hostname(), settings.NoProxy) {
+				return nil, nil
+			}
+			return proxyURL, nil
+		}
+	}
+
+	if settings.CABundle != "" {
+		pool, err := loadCABundle(settings.CABundle)
+		if err != nil {
+			return err
+		}
+		debugPrintf("Trusting certificate authorities from %s\n", settings.CABundle)
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		// Python's requests trusts only this bundle, so az gets a copy that
+		// includes the system roots as well
+		azBundle, err := writeAzCABundle(settings.CABundle)
+		if err != nil {
+			return err
+		}
+		if err := os.Setenv("REQUESTS_CA_BUNDLE", azBundle); err != nil {
+			return fmt.Errorf("failed to set REQUESTS_CA_BUNDLE: %v", err)
+		}
+	}
+
+	armHTTPClient.Transport = transport
+	keyVaultHTTPClient.Transport = transport
+	sdkHTTPClient = &http.Client{Transport: transport}
+	return nil
+}
+
+// useProxy reports whether requests to host go through the proxy, given a
+// NO_PROXY style list of hosts and domain suffixes
+func useProxy(host string, noProxy string) bool {
+	if host == "localhost" || host == "127.0.0.1" || host == "::1" {
+		return false
+	}
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return false
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		host = strings.ToLower(host)
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadCABundle returns the system roots plus the certificates in a PEM file
+func loadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in CA bundle %s", path)
+	}
+	return pool, nil
+}
+
+// systemCABundles are where Linux distributions and macOS keep the system
+// roots as PEM, as searched by crypto/x509
+var systemCABundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// writeAzCABundle writes the system roots followed by the certificates of
+// the CA bundle at path to the state directory, for az to trust, and
+// returns the file written
+func writeAzCABundle(path string) (string, error) {
+	userPEM, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+
+	var combined []byte
+	candidates := systemCABundles
+	if file := os.Getenv("SSL_CERT_FILE"); file != "" {
+		candidates = append([]string{file}, candidates...)
+	}
+	for _, candidate := range candidates {
+		if data, err := os.ReadFile(candidate); err == nil {
+			debugPrintf("Adding system roots from %s to the az CA bundle\n", candidate)
+			combined = append(data, '\n')
+			break
+		}
+	}
+	if combined == nil {
+		fmt.Printf("Warning: no system CA bundle found; az will trust only %s\n", path)
+	}
+	combined = append(combined, userPEM...)
+
+	stateDir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create state directory: %v", err)
+	}
+	bundle := filepath.Join(stateDir, "az-ca-bundle.pem")
+	if err := os.WriteFile(bundle, combined, 0600); err != nil {
+		return "", fmt.Errorf("failed to write CA bundle for az: %v", err)
+	}
+	return bundle, nil
+}
@@ -0,0 +1,551 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/antnsn/BastionBuddy/internal/config"
+	"github.com/antnsn/BastionBuddy/internal/tunnels"
+	"github.com/antnsn/BastionBuddy/internal/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	// authorizationAPIVersion is the Microsoft.Authorization API version for PIM
+	authorizationAPIVersion = "2020-10-01"
+	// pimDefaultDuration is the activation duration offered by default
+	pimDefaultDuration = time.Hour
+	// pimActivationTimeout bounds the wait for an activation, including approval
+	pimActivationTimeout = 10 * time.Minute
+	// pimPropagationTimeout bounds the wait for an active role to take effect
+	pimPropagationTimeout = 5 * time.Minute
+)
+
+var (
+	// pimPollInterval is the delay between checks of a pending activation
+	pimPollInterval = 5 * time.Second
+	// pimDiscovery lists subscriptions behind eligible roles in the subscription picker (--pim)
+	pimDiscovery bool
+)
+
+// SetPIMDiscovery makes the subscription picker list subscriptions that an
+// eligible PIM role gives access to
+func SetPIMDiscovery(enabled bool) {
+	pimDiscovery = enabled
+}
+
+// pimDiscoveryEnabled reports whether the subscription picker looks up
+// eligible PIM roles: with --pim or the active profile's pim setting
+func pimDiscoveryEnabled() bool {
+	if pimDiscovery {
+		return true
+	}
+	profile, err := config.ActiveProfile()
+	return err == nil && profile != nil && profile.PIM
+}
+
+// roleScheduleInstance is an eligible or active role assignment of the
+// signed-in identity, as listed by Privileged Identity Management
+type roleScheduleInstance struct {
+	ID         string `json:"id"`
+	Properties struct {
+		Scope                     string     `json:"scope"`
+		RoleDefinitionID          string     `json:"roleDefinitionId"`
+		RoleEligibilityScheduleID string     `json:"roleEligibilityScheduleId"`
+		AssignmentType            string     `json:"assignmentType"`
+		EndDateTime               *time.Time `json:"endDateTime"`
+		ExpandedProperties        struct {
+			Scope struct {
+				DisplayName string `json:"displayName"`
+				Type        string `json:"type"`
+			} `json:"scope"`
+			RoleDefinition struct {
+				DisplayName string `json:"displayName"`
+			} `json:"roleDefinition"`
+		} `json:"expandedProperties"`
+	} `json:"properties"`
+}
+
+// roleName returns the display name of the role
+func (r roleScheduleInstance) roleName() string {
+	if name := r.Properties.ExpandedProperties.RoleDefinition.DisplayName; name != "" {
+		return name
+	}
+	return resourceNameFromID(r.Properties.RoleDefinitionID)
+}
+
+// scopeLabel renders the scope, e.g. "prod (subscription)"
+func (r roleScheduleInstance) scopeLabel() string {
+	scope := r.Properties.ExpandedProperties.Scope
+	if scope.DisplayName == "" {
+		return r.Properties.Scope
+	}
+	return fmt.Sprintf("%s (%s)", scope.DisplayName, strings.ToLower(scope.Type))
+}
+
+// label renders an eligible role for the role picker
+func (r roleScheduleInstance) label() string {
+	return fmt.Sprintf("%s on %s", r.roleName(), r.scopeLabel())
+}
+
+// subscriptionID returns the subscription the scope lies in, or "" for a
+// management group or tenant scope
+func (r roleScheduleInstance) subscriptionID() string {
+	parts := strings.Split(strings.Trim(r.Properties.Scope, "/"), "/")
+	if len(parts) >= 2 && strings.EqualFold(parts[0], "subscriptions") {
+		return parts[1]
+	}
+	return ""
+}
+
+// covers reports whether activating the role can give access to a
+// subscription. Management group scopes may contain it and are included.
+func (r roleScheduleInstance) covers(subscriptionID string) bool {
+	if sub := r.subscriptionID(); sub != "" {
+		return strings.EqualFold(sub, subscriptionID)
+	}
+	return strings.Contains(strings.ToLower(r.Properties.Scope), "/providers/microsoft.management/managementgroups/")
+}
+
+// matches reports whether the role is the remembered one
+func (r roleScheduleInstance) matches(role *tunnels.PIMRole) bool {
+	return role != nil && strings.EqualFold(r.roleName(), role.Role) && strings.EqualFold(r.Properties.Scope, role.Scope)
+}
+
+// pimRequest is the body and response of a role assignment schedule request
+type pimRequest struct {
+	Properties struct {
+		PrincipalID                     string           `json:"principalId,omitempty"`
+		RoleDefinitionID                string           `json:"roleDefinitionId,omitempty"`
+		RequestType                     string           `json:"requestType,omitempty"`
+		LinkedRoleEligibilityScheduleID string           `json:"linkedRoleEligibilityScheduleId,omitempty"`
+		Justification                   string           `json:"justification,omitempty"`
+		ScheduleInfo                    *pimScheduleInfo `json:"scheduleInfo,omitempty"`
+		Status                          string           `json:"status,omitempty"`
+	} `json:"properties"`
+}
+
+// pimScheduleInfo is how long a requested activation lasts
+type pimScheduleInfo struct {
+	Expiration struct {
+		Type     string `json:"type"`
+		Duration string `json:"duration"`
+	} `json:"expiration"`
+}
+
+// listEligibleRoles lists the roles the signed-in identity can activate
+func listEligibleRoles(ctx context.Context, cred azcore.TokenCredential) ([]roleScheduleInstance, error) {
+	roles, err := armList[roleScheduleInstance](ctx, cred, "/providers/Microsoft.Authorization/roleEligibilityScheduleInstances?api-version="+authorizationAPIVersion+"&$filter=asTarget()")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list eligible PIM roles: %v", err)
+	}
+	return roles, nil
+}
+
+// listActivatedRoles lists the signed-in identity's currently activated roles
+func listActivatedRoles(ctx context.Context, cred azcore.TokenCredential) ([]roleScheduleInstance, error) {
+	roles, err := armList[roleScheduleInstance](ctx, cred, "/providers/Microsoft.Authorization/roleAssignmentScheduleInstances?api-version="+authorizationAPIVersion+"&$filter=asTarget()")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list active PIM roles: %v", err)
+	}
+	var activated []roleScheduleInstance
+	for _, role := range roles {
+		if strings.EqualFold(role.Properties.AssignmentType, "Activated") {
+			activated = append(activated, role)
+		}
+	}
+	return activated, nil
+}
+
+// cachedEligibleRoles lists eligible roles through the discovery cache; they
+// change rarely and are looked up whenever subscriptions are picked with PIM
+// discovery enabled
+func cachedEligibleRoles(ctx context.Context, cred azcore.TokenCredential) ([]roleScheduleInstance, error) {
+	return cachedDiscovery(ctx, "", "pim-eligibility", func(ctx context.Context) ([]roleScheduleInstance, error) {
+		return listEligibleRoles(ctx, cred)
+	})
+}
+
+// subscriptionAccessible reports whether the signed-in identity can read a subscription
+func subscriptionAccessible(ctx context.Context, cred azcore.TokenCredential, subscriptionID string) (bool, error) {
+	err := armRequest(ctx, cred, http.MethodGet, fmt.Sprintf("/subscriptions/%s?api-version=%s", subscriptionID, subscriptionsAPIVersion), nil, nil)
+	if err == nil {
+		return true, nil
+	}
+	var respErr *armResponseError
+	if errors.As(err, &respErr) && (respErr.StatusCode == http.StatusForbidden || respErr.StatusCode == http.StatusNotFound) {
+		return false, nil
+	}
+	return false, err
+}
+
+// ensureSavedSubscriptionAccess activates an eligible PIM role for the
+// subscriptions of a saved configuration that are not accessible. The role
+// used is remembered in saved, which the caller stores.
+func ensureSavedSubscriptionAccess(saved *tunnels.Config, resolved tunnels.Config) error {
+	if offlineMode {
+		return nil
+	}
+	subscriptions := []string{resolved.SubscriptionID}
+	if resolved.BastionSubscriptionID != "" && !strings.EqualFold(resolved.BastionSubscriptionID, resolved.SubscriptionID) {
+		subscriptions = append(subscriptions, resolved.BastionSubscriptionID)
+	}
+
+	cred, err := GetAzureCredential()
+	if err != nil {
+		return fmt.Errorf("failed to get Azure credentials: %v", err)
+	}
+	ctx := context.Background()
+	for _, subscriptionID := range subscriptions {
+		if subscriptionID == "" {
+			continue
+		}
+		accessible, err := subscriptionAccessible(ctx, cred, subscriptionID)
+		if err != nil {
+			debugPrintf("Could not check access to subscription %s: %v\n", subscriptionID, err)
+			continue
+		}
+		if accessible {
+			continue
+		}
+
+		role, err := activateRoleForSubscription(ctx, cred, subscriptionID, saved.PIMRole, "", "")
+		if err != nil {
+			return err
+		}
+		// The target subscription's role is the one to remember
+		if role != nil && (saved.PIMRole == nil || strings.EqualFold(subscriptionID, resolved.SubscriptionID)) {
+			saved.PIMRole = role
+		}
+	}
+	return nil
+}
+
+// activateRoleForSubscription activates an eligible role that gives access
+// to a subscription and waits until it takes effect. The remembered role is
+// used without asking; otherwise the user picks one. Empty duration and
+// justification are asked for. It returns the role that was activated, or
+// nil if no eligible role covers the subscription.
+func activateRoleForSubscription(ctx context.Context, cred azcore.TokenCredential, subscriptionID string, preferred *tunnels.PIMRole, duration string, justification string) (*tunnels.PIMRole, error) {
+	eligible, err := listEligibleRoles(ctx, cred)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []roleScheduleInstance
+	for _, role := range eligible {
+		if role.covers(subscriptionID) {
+			candidates = append(candidates, role)
+		}
+	}
+	if len(candidates) == 0 {
+		debugPrintf("No eligible PIM role covers subscription %s\n", subscriptionID)
+		return nil, nil
+	}
+
+	role, err := selectEligibleRole(candidates, preferred, fmt.Sprintf("Subscription %s needs a PIM role. Activate", subscriptionID))
+	if err != nil {
+		return nil, err
+	}
+	if err := activateEligibleRole(ctx, cred, role, duration, justification); err != nil {
+		return nil, err
+	}
+	if err := waitForSubscriptionAccess(ctx, cred, subscriptionID); err != nil {
+		return nil, err
+	}
+	return &tunnels.PIMRole{Role: role.roleName(), Scope: role.Properties.Scope}, nil
+}
+
+// selectEligibleRole returns the remembered role if it is a candidate, and
+// otherwise lets the user pick one
+func selectEligibleRole(candidates []roleScheduleInstance, preferred *tunnels.PIMRole, prompt string) (roleScheduleInstance, error) {
+	for _, role := range candidates {
+		if role.matches(preferred) {
+			fmt.Printf("Using PIM role %s\n", role.label())
+			return role, nil
+		}
+	}
+
+	const cancel = "Cancel"
+	items := make([]string, 0, len(candidates)+1)
+	byLabel := make(map[string]roleScheduleInstance)
+	for _, role := range candidates {
+		items = append(items, role.label())
+		byLabel[role.label()] = role
+	}
+	items = append(items, cancel)
+
+	selected, err := utils.SelectWithMenu(items, prompt)
+	if err != nil {
+		return roleScheduleInstance{}, err
+	}
+	if selected == cancel {
+		return roleScheduleInstance{}, fmt.Errorf("role activation cancelled by user")
+	}
+	return byLabel[selected], nil
+}
+
+// activateEligibleRole requests activation of an eligible role and waits
+// until PIM has provisioned it, including any approval. A role that is
+// already active is left as is.
+func activateEligibleRole(ctx context.Context, cred azcore.TokenCredential, role roleScheduleInstance, duration string, justification string) error {
+	activated, err := listActivatedRoles(ctx, cred)
+	if err != nil {
+		debugPrintf("Could not list active PIM roles: %v\n", err)
+	}
+	for _, active := range activated {
+		if strings.EqualFold(active.Properties.RoleDefinitionID, role.Properties.RoleDefinitionID) &&
+			strings.EqualFold(active.Properties.Scope, role.Properties.Scope) {
+			fmt.Printf("PIM role %s is already active\n", role.label())
+			return nil
+		}
+	}
+
+	if duration == "" {
+		input, err := utils.ReadInput(fmt.Sprintf("Activation duration (default %s)", shortDuration(pimDefaultDuration)))
+		if err != nil {
+			return err
+		}
+		duration = strings.TrimSpace(input)
+	}
+	activeFor := pimDefaultDuration
+	if duration != "" {
+		d, err := time.ParseDuration(duration)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid activation duration %q: expected e.g. 30m or 4h", duration)
+		}
+		activeFor = d
+	}
+	if justification == "" {
+		input, err := utils.ReadInput("Justification")
+		if err != nil {
+			return err
+		}
+		justification = strings.TrimSpace(input)
+	}
+
+	principalID, err := signedInObjectID(ctx, cred)
+	if err != nil {
+		return err
+	}
+
+	var request pimRequest
+	request.Properties.PrincipalID = principalID
+	request.Properties.RoleDefinitionID = role.Properties.RoleDefinitionID
+	request.Properties.RequestType = "SelfActivate"
+	request.Properties.LinkedRoleEligibilityScheduleID = role.Properties.RoleEligibilityScheduleID
+	request.Properties.Justification = justification
+	request.Properties.ScheduleInfo = &pimScheduleInfo{}
+	request.Properties.ScheduleInfo.Expiration.Type = "AfterDuration"
+	request.Properties.ScheduleInfo.Expiration.Duration = isoDuration(activeFor)
+
+	path := fmt.Sprintf("%s/providers/Microsoft.Authorization/roleAssignmentScheduleRequests/%s?api-version=%s",
+		role.Properties.Scope, uuid.New().String(), authorizationAPIVersion)
+	fmt.Printf("Activating PIM role %s for %s...\n", role.label(), shortDuration(activeFor))
+	var response pimRequest
+	if err := armRequest(ctx, cred, http.MethodPut, path, request, &response); err != nil {
+		var respErr *armResponseError
+		if errors.As(err, &respErr) && respErr.Code == "RoleAssignmentExists" {
+			fmt.Printf("PIM role %s is already active\n", role.label())
+			return nil
+		}
+		return fmt.Errorf("failed to activate PIM role %s: %v", role.label(), err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pimActivationTimeout)
+	defer cancel()
+	waitingForApproval := false
+	for {
+		switch status := response.Properties.Status; status {
+		case "Provisioned", "ScheduleCreated":
+			fmt.Printf("✓ PIM role %s activated\n", role.label())
+			return nil
+		case "Denied", "AdminDenied", "Revoked", "Canceled", "Failed", "FailedAsResourceIsLocked", "TimedOut", "Invalid":
+			return fmt.Errorf("activation of PIM role %s ended with status %s", role.label(), status)
+		case "PendingApproval", "PendingApprovalProvisioning", "PendingAdminDecision":
+			if !waitingForApproval {
+				fmt.Println("The activation needs approval; waiting for an approver...")
+				waitingForApproval = true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("activation of PIM role %s is still %s after %s; connect again once it is active",
+				role.label(), response.Properties.Status, shortDuration(pimActivationTimeout))
+		case <-time.After(pimPollInterval):
+		}
+		if err := armRequest(ctx, cred, http.MethodGet, path, nil, &response); err != nil {
+			return fmt.Errorf("failed to check activation of PIM role %s: %v", role.label(), err)
+		}
+	}
+}
+
+// waitForSubscriptionAccess waits until an activated role lets the
+// signed-in identity read a subscription, then drops cached subscription lists
+func waitForSubscriptionAccess(ctx context.Context, cred azcore.TokenCredential, subscriptionID string) error {
+	ctx, cancel := context.WithTimeout(ctx, pimPropagationTimeout)
+	defer cancel()
+	for {
+		accessible, err := subscriptionAccessible(ctx, cred, subscriptionID)
+		if err != nil {
+			return err
+		}
+		if accessible {
+			forgetCachedDiscovery(currentTenantID(), "", "subscriptions")
+			return nil
+		}
+
+		debugPrintf("Waiting for access to subscription %s\n", subscriptionID)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("subscription %s is still not accessible %s after the role was activated", subscriptionID, shortDuration(pimPropagationTimeout))
+		case <-time.After(pimPollInterval):
+		}
+	}
+}
+
+// signedInObjectID returns the object ID of the signed-in identity, which
+// PIM activations are requested for
+func signedInObjectID(ctx context.Context, cred azcore.TokenCredential) (string, error) {
+	if _, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{activeCloud().scope()}}); err != nil {
+		return "", fmt.Errorf("failed to get Azure Resource Manager token: %v", err)
+	}
+	identityMu.Lock()
+	defer identityMu.Unlock()
+	if lastIdentity == nil || lastIdentity.ObjectID == "" {
+		return "", fmt.Errorf("could not determine the signed-in identity's object ID")
+	}
+	return lastIdentity.ObjectID, nil
+}
+
+// eligibleSubscriptionItems returns picker entries for subscriptions that are
+// not listed but can be accessed by activating an eligible role, keyed by
+// entry with the subscription ID as value. Nothing is looked up unless PIM
+// discovery is enabled.
+func eligibleSubscriptionItems(ctx context.Context, cred azcore.TokenCredential, listed map[string]bool) map[string]string {
+	if offlineMode || !pimDiscoveryEnabled() {
+		return nil
+	}
+	eligible, err := cachedEligibleRoles(ctx, cred)
+	if err != nil {
+		debugPrintf("Skipping PIM roles: %v\n", err)
+		return nil
+	}
+
+	items := make(map[string]string)
+	seen := make(map[string]bool)
+	for _, role := range eligible {
+		subscriptionID := strings.ToLower(role.subscriptionID())
+		if subscriptionID == "" || listed[subscriptionID] || seen[subscriptionID] {
+			continue
+		}
+		seen[subscriptionID] = true
+		name := subscriptionID
+		if strings.EqualFold(role.Properties.ExpandedProperties.Scope.Type, "subscription") {
+			name = role.Properties.ExpandedProperties.Scope.DisplayName
+		}
+		items[fmt.Sprintf("🔒 %s | ID: %s | PIM: activate %s", name, subscriptionID, role.roleName())] = subscriptionID
+	}
+	return items
+}
+
+// PIMActivation describes a role activation requested from the command line
+type PIMActivation struct {
+	// SubscriptionID limits the roles offered to those covering a subscription
+	SubscriptionID string
+	// Role is the role name to activate, e.g. Contributor
+	Role string
+	// Duration is how long the role stays active, e.g. "2h"; asked if empty
+	Duration string
+	// Justification is recorded with the activation; asked if empty
+	Justification string
+}
+
+// ActivatePIMRole activates an eligible role and waits until it is active
+func ActivatePIMRole(activation PIMActivation) error {
+	cred, err := GetAzureCredential()
+	if err != nil {
+		return fmt.Errorf("failed to get Azure credentials: %v", err)
+	}
+	ctx := context.Background()
+
+	eligible, err := listEligibleRoles(ctx, cred)
+	if err != nil {
+		return err
+	}
+	var candidates []roleScheduleInstance
+	for _, role := range eligible {
+		if activation.SubscriptionID != "" && !role.covers(activation.SubscriptionID) {
+			continue
+		}
+		if activation.Role != "" && !strings.EqualFold(role.roleName(), activation.Role) {
+			continue
+		}
+		candidates = append(candidates, role)
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("no eligible PIM role matches")
+	}
+
+	role, err := selectEligibleRole(candidates, nil, "Select a PIM role to activate")
+	if err != nil {
+		return err
+	}
+	if err := activateEligibleRole(ctx, cred, role, activation.Duration, activation.Justification); err != nil {
+		return err
+	}
+	if subscriptionID := activation.SubscriptionID; subscriptionID != "" {
+		return waitForSubscriptionAccess(ctx, cred, subscriptionID)
+	}
+	if subscriptionID := role.subscriptionID(); subscriptionID != "" {
+		return waitForSubscriptionAccess(ctx, cred, subscriptionID)
+	}
+	return nil
+}
+
+// ListPIMRoles prints the signed-in identity's eligible PIM roles and
+// whether each is active
+func ListPIMRoles(w io.Writer) error {
+	cred, err := GetAzureCredential()
+	if err != nil {
+		return fmt.Errorf("failed to get Azure credentials: %v", err)
+	}
+	ctx := context.Background()
+
+	eligible, err := listEligibleRoles(ctx, cred)
+	if err != nil {
+		return err
+	}
+	if len(eligible) == 0 {
+		_, err := fmt.Fprintln(w, "No eligible PIM roles")
+		return err
+	}
+	activated, err := listActivatedRoles(ctx, cred)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range eligible {
+		state := "eligible"
+		for _, active := range activated {
+			if strings.EqualFold(active.Properties.RoleDefinitionID, role.Properties.RoleDefinitionID) &&
+				strings.EqualFold(active.Properties.Scope, role.Properties.Scope) {
+				state = "active"
+				if active.Properties.EndDateTime != nil {
+					state += " until " + active.Properties.EndDateTime.Local().Format("2006-01-02 15:04")
+				}
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s | %s\n", role.label(), state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
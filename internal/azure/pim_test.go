@@ -0,0 +1,284 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antnsn/BastionBuddy/internal/tunnels"
+)
+
+const (
+	testRoleDefinitionID = "/subscriptions/sub-1/providers/Microsoft.Authorization/roleDefinitions/contributor"
+	testRequestsPath     = "/subscriptions/sub-1/providers/Microsoft.Authorization/roleAssignmentScheduleRequests/"
+)
+
+// pimStandIn is a loopback Microsoft.Authorization stand-in for the
+// signed-in identity's PIM roles
+type pimStandIn struct {
+	mu sync.Mutex
+	// eligible and active are the roles listed
+	eligible []roleScheduleInstance
+	active   []roleScheduleInstance
+	// statuses are the activation request's status on creation and on
+	// each check; the last one repeats
+	statuses []string
+	// requestCode, if set, fails the activation request with this error code
+	requestCode string
+	// deniedChecks is how many subscription reads are refused after activation
+	deniedChecks int
+
+	requests  []pimRequest
+	checks    int
+	activated bool
+}
+
+func newPIMStandIn(t *testing.T, s *pimStandIn) {
+	t.Helper()
+	useTempCache(t)
+	previous := pimPollInterval
+	pimPollInterval = time.Millisecond
+	identityMu.Lock()
+	previousIdentity := lastIdentity
+	identityMu.Unlock()
+	t.Cleanup(func() {
+		pimPollInterval = previous
+		identityMu.Lock()
+		lastIdentity = previousIdentity
+		identityMu.Unlock()
+	})
+	rememberIdentity(fakeJWT(t, `{"oid":"object-1","tid":"tenant-1"}`))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/providers/Microsoft.Authorization/roleEligibilityScheduleInstances", func(w http.ResponseWriter, r *http.Request) {
+		requireBearer(t, r)
+		writeJSON(t, w, map[string]interface{}{"value": s.eligible})
+	})
+	mux.HandleFunc("/providers/Microsoft.Authorization/roleAssignmentScheduleInstances", func(w http.ResponseWriter, r *http.Request) {
+		requireBearer(t, r)
+		writeJSON(t, w, map[string]interface{}{"value": s.active})
+	})
+	mux.HandleFunc(testRequestsPath, func(w http.ResponseWriter, r *http.Request) {
+		requireBearer(t, r)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.Method == http.MethodPut {
+			if s.requestCode != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				writeJSON(t, w, map[string]interface{}{"error": map[string]string{"code": s.requestCode, "message": "refused"}})
+				return
+			}
+			var request pimRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Errorf("activation request: %v", err)
+			}
+			s.requests = append(s.requests, request)
+		} else {
+			s.checks++
+		}
+		status := s.statuses[len(s.statuses)-1]
+		if s.checks < len(s.statuses) {
+			status = s.statuses[s.checks]
+		}
+		if status == "Provisioned" {
+			s.activated = true
+		}
+		response := s.requests[len(s.requests)-1]
+		response.Properties.Status = status
+		writeJSON(t, w, response)
+	})
+	mux.HandleFunc("/subscriptions/sub-1", func(w http.ResponseWriter, r *http.Request) {
+		requireBearer(t, r)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.activated || s.deniedChecks > 0 {
+			if s.activated {
+				s.deniedChecks--
+			}
+			w.WriteHeader(http.StatusForbidden)
+			writeJSON(t, w, map[string]interface{}{"error": map[string]string{"code": "AuthorizationFailed", "message": "no access"}})
+			return
+		}
+		writeJSON(t, w, map[string]string{"subscriptionId": "sub-1"})
+	})
+	newStandIn(t, mux)
+}
+
+// eligibleRole returns an eligible role on a scope
+func eligibleRole(name string, scope string, scopeType string) roleScheduleInstance {
+	var role roleScheduleInstance
+	role.Properties.Scope = scope
+	role.Properties.RoleDefinitionID = testRoleDefinitionID
+	role.Properties.RoleEligibilityScheduleID = scope + "/providers/Microsoft.Authorization/roleEligibilitySchedules/schedule-1"
+	role.Properties.AssignmentType = "Activated"
+	role.Properties.ExpandedProperties.Scope.Type = scopeType
+	role.Properties.ExpandedProperties.Scope.DisplayName = "prod"
+	role.Properties.ExpandedProperties.RoleDefinition.DisplayName = name
+	return role
+}
+
+func TestActivateRoleForSubscription(t *testing.T) {
+	s := &pimStandIn{
+		eligible: []roleScheduleInstance{
+			eligibleRole("Reader", "/subscriptions/sub-2", "subscription"),
+			eligibleRole("Contributor", "/subscriptions/sub-1", "subscription"),
+		},
+		statuses:     []string{"PendingApproval", "PendingApproval", "Provisioned"},
+		deniedChecks: 2,
+	}
+	newPIMStandIn(t, s)
+
+	preferred := &tunnels.PIMRole{Role: "contributor", Scope: "/subscriptions/SUB-1"}
+	role, err := activateRoleForSubscription(context.Background(), &fakeCred{}, "sub-1", preferred, "90m", "INC-1234")
+	if err != nil {
+		t.Fatalf("activateRoleForSubscription: %v", err)
+	}
+	if role == nil || role.Role != "Contributor" || role.Scope != "/subscriptions/sub-1" {
+		t.Errorf("role = %+v", role)
+	}
+
+	if len(s.requests) != 1 {
+		t.Fatalf("%d activation requests, want 1", len(s.requests))
+	}
+	got := s.requests[0].Properties
+	if got.PrincipalID != "object-1" || got.RoleDefinitionID != testRoleDefinitionID || got.RequestType != "SelfActivate" {
+		t.Errorf("request = %+v", got)
+	}
+	if got.LinkedRoleEligibilityScheduleID != s.eligible[1].Properties.RoleEligibilityScheduleID {
+		t.Errorf("linked schedule = %q", got.LinkedRoleEligibilityScheduleID)
+	}
+	if got.Justification != "INC-1234" || got.ScheduleInfo == nil || got.ScheduleInfo.Expiration.Duration != "PT1H30M" {
+		t.Errorf("justification %q, schedule %+v", got.Justification, got.ScheduleInfo)
+	}
+	if s.checks != 2 {
+		t.Errorf("activation checked %d times, want 2", s.checks)
+	}
+	if s.deniedChecks != 0 {
+		t.Errorf("stopped waiting for access with %d refusals left", s.deniedChecks)
+	}
+}
+
+func TestActivateRoleForSubscriptionWithoutRole(t *testing.T) {
+	s := &pimStandIn{eligible: []roleScheduleInstance{eligibleRole("Reader", "/subscriptions/sub-2", "subscription")}}
+	newPIMStandIn(t, s)
+
+	role, err := activateRoleForSubscription(context.Background(), &fakeCred{}, "sub-1", nil, "1h", "x")
+	if err != nil || role != nil {
+		t.Errorf("activateRoleForSubscription = %+v, %v, want no role", role, err)
+	}
+}
+
+func TestActivateEligibleRole(t *testing.T) {
+	role := eligibleRole("Contributor", "/subscriptions/sub-1", "subscription")
+	tests := []struct {
+		name     string
+		s        *pimStandIn
+		duration string
+		wantErr  string
+		requests int
+	}{
+		{
+			name:     "already active",
+			s:        &pimStandIn{active: []roleScheduleInstance{role}},
+			duration: "1h",
+		},
+		{
+			name:     "assignment exists",
+			s:        &pimStandIn{requestCode: "RoleAssignmentExists"},
+			duration: "1h",
+		},
+		{
+			name:     "request refused",
+			s:        &pimStandIn{requestCode: "RoleAssignmentRequestPolicyValidationFailed"},
+			duration: "1h",
+			wantErr:  "failed to activate PIM role Contributor on prod (subscription)",
+		},
+		{
+			name:     "denied after approval",
+			s:        &pimStandIn{statuses: []string{"PendingApproval", "Denied"}},
+			duration: "1h",
+			wantErr:  "ended with status Denied",
+			requests: 1,
+		},
+		{
+			name:     "created",
+			s:        &pimStandIn{statuses: []string{"ScheduleCreated"}},
+			duration: "2h",
+			requests: 1,
+		},
+		{
+			name:     "invalid duration",
+			s:        &pimStandIn{},
+			duration: "soon",
+			wantErr:  `invalid activation duration "soon"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newPIMStandIn(t, tt.s)
+			err := activateEligibleRole(context.Background(), &fakeCred{}, role, tt.duration, "INC-1234")
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("activateEligibleRole: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+			if len(tt.s.requests) != tt.requests {
+				t.Errorf("%d activation requests, want %d", len(tt.s.requests), tt.requests)
+			}
+		})
+	}
+}
+
+func TestEligibleSubscriptionItems(t *testing.T) {
+	s := &pimStandIn{eligible: []roleScheduleInstance{
+		eligibleRole("Contributor", "/subscriptions/sub-1", "subscription"),
+		eligibleRole("Reader", "/subscriptions/sub-2/resourceGroups/rg", "resourcegroup"),
+		eligibleRole("Owner", "/subscriptions/sub-3", "subscription"),
+	}}
+	newPIMStandIn(t, s)
+	t.Cleanup(func() { pimDiscovery = false })
+	listed := map[string]bool{"sub-3": true}
+
+	if items := eligibleSubscriptionItems(context.Background(), &fakeCred{}, listed); len(items) != 0 {
+		t.Errorf("without PIM discovery: items = %v", items)
+	}
+
+	SetPIMDiscovery(true)
+	items := eligibleSubscriptionItems(context.Background(), &fakeCred{}, listed)
+	want := map[string]string{
+		"🔒 prod | ID: sub-1 | PIM: activate Contributor": "sub-1",
+		"🔒 sub-2 | ID: sub-2 | PIM: activate Reader":     "sub-2",
+	}
+	if len(items) != len(want) {
+		t.Fatalf("items = %v, want %v", items, want)
+	}
+	for item, subscriptionID := range want {
+		if items[item] != subscriptionID {
+			t.Errorf("items[%q] = %q, want %q", item, items[item], subscriptionID)
+		}
+	}
+}
+
+func TestRoleCovers(t *testing.T) {
+	tests := []struct {
+		scope string
+		want  bool
+	}{
+		{"/subscriptions/sub-1", true},
+		{"/subscriptions/SUB-1/resourceGroups/rg", true},
+		{"/subscriptions/sub-2", false},
+		{"/providers/Microsoft.Management/managementGroups/platform", true},
+		{"/", false},
+	}
+	for _, tt := range tests {
+		role := eligibleRole("Reader", tt.scope, "")
+		if got := role.covers("sub-1"); got != tt.want {
+			t.Errorf("covers with scope %s = %v, want %v", tt.scope, got, tt.want)
+		}
+	}
+}
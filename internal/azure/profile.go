@@ -0,0 +1,51 @@
+package azure
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/antnsn/BastionBuddy/internal/config"
+)
+
+// profileTenantID returns the default tenant of the active profile, if any
+func profileTenantID() string {
+	profile, err := config.ActiveProfile()
+	if err != nil || profile == nil {
+		return ""
+	}
+	return profile.TenantID
+}
+
+// profileSubscriptionID returns the default subscription of the active profile, if any
+func profileSubscriptionID() string {
+	profile, err := config.ActiveProfile()
+	if err != nil || profile == nil {
+		return ""
+	}
+	return profile.SubscriptionID
+}
+
+// applyProfile prepares the process environment for the active profile. A
+// profile with a separate az login keeps its Azure CLI context in its own
+// config store, which also isolates the SDK's Azure CLI credential.
+func applyProfile() error {
+	profile, err := config.ActiveProfile()
+	if err != nil {
+		return fmt.Errorf("failed to load profile: %v", err)
+	}
+	if profile == nil || !profile.SeparateAzLogin {
+		return nil
+	}
+
+	configDir, err := config.ConfigDir()
+	if err != nil {
+		return err
+	}
+
+	azureDir := filepath.Join(configDir, "azure")
+	if err := os.MkdirAll(azureDir, 0700); err != nil {
+		return fmt.Errorf("failed to create Azure CLI directory for profile %s: %v", profile.Name, err)
+	}
+	return os.Setenv("AZURE_CONFIG_DIR", azureDir)
+}
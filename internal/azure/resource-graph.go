@@ -0,0 +1,226 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/antnsn/BastionBuddy/internal/config"
+)
+
+const (
+	// resourceGraphAPIVersion is the Resource Graph query API version
+	resourceGraphAPIVersion = "2022-10-01"
+	// resourceGraphPageSize is the number of rows requested per page
+	resourceGraphPageSize = 1000
+	// resourceGraphMaxSubscriptions is the number of subscriptions a single query may target
+	resourceGraphMaxSubscriptions = 1000
+)
+
+// vmSearchQuery finds virtual machines with the details shown in the picker.
+// The network interface join provides the primary private IP and the
+// subscription join provides the subscription's display name.
+const vmSearchQuery = `Resources
+| where type =~ 'microsoft.compute/virtualmachines'
+| extend osType = tostring(properties.storageProfile.osDisk.osType),
+	powerState = tostring(properties.extended.instanceView.powerState.displayStatus),
+	nicId = tolower(tostring(properties.networkProfile.networkInterfaces[0].id))
+| join kind=leftouter (
+	Resources
+	| where type =~ 'microsoft.network/networkinterfaces'
+	| project nicId = tolower(id), privateIp = tostring(properties.ipConfigurations[0].properties.privateIPAddress)
+) on nicId
+| join kind=leftouter (
+	ResourceContainers
+	| where type =~ 'microsoft.resources/subscriptions'
+	| project subscriptionId, subscriptionName = name
+) on subscriptionId
+| project id, name, resourceGroup, subscriptionId, subscriptionName, tenantId, location, osType, powerState, privateIp, tags
+| order by name asc`
+
+// vmSearchResult is a virtual machine found by a Resource Graph search
+type vmSearchResult struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	ResourceGroup    string            `json:"resourceGroup"`
+	SubscriptionID   string            `json:"subscriptionId"`
+	SubscriptionName string            `json:"subscriptionName"`
+	TenantID         string            `json:"tenantId"`
+	Location         string            `json:"location"`
+	OSType           string            `json:"osType"`
+	PowerState       string            `json:"powerState"`
+	PrivateIP        string            `json:"privateIp"`
+	Tags             map[string]string `json:"tags"`
+}
+
+// resourceGraphRequest is the body of a Resource Graph query
+type resourceGraphRequest struct {
+	Subscriptions []string                    `json:"subscriptions"`
+	Query         string                      `json:"query"`
+	Options       resourceGraphRequestOptions `json:"options"`
+}
+
+// resourceGraphRequestOptions controls paging and the result format
+type resourceGraphRequestOptions struct {
+	Top          int    `json:"$top"`
+	SkipToken    string `json:"$skipToken,omitempty"`
+	ResultFormat string `json:"resultFormat"`
+}
+
+// resourceGraphResponse is one page of Resource Graph results
+type resourceGraphResponse[T any] struct {
+	TotalRecords int64  `json:"totalRecords"`
+	Count        int64  `json:"count"`
+	Data         []T    `json:"data"`
+	SkipToken    string `json:"$skipToken"`
+}
+
+// queryResourceGraph runs a query across the given subscriptions, following
+// skip tokens until all rows have been read
+func queryResourceGraph[T any](ctx context.Context, cred azcore.TokenCredential, subscriptionIDs []string, query string) ([]T, error) {
+	var rows []T
+	for start := 0; start < len(subscriptionIDs); start += resourceGraphMaxSubscriptions {
+		end := start + resourceGraphMaxSubscriptions
+		if end > len(subscriptionIDs) {
+			end = len(subscriptionIDs)
+		}
+
+		request := resourceGraphRequest{
+			Subscriptions: subscriptionIDs[start:end],
+			Query:         query,
+			Options:       resourceGraphRequestOptions{Top: resourceGraphPageSize, ResultFormat: "objectArray"},
+		}
+		for page := 1; ; page++ {
+			var response resourceGraphResponse[T]
+			path := "/providers/Microsoft.ResourceGraph/resources?api-version=" + resourceGraphAPIVersion
+			if err := armRequest(ctx, cred, http.MethodPost, path, request, &response); err != nil {
+				return nil, fmt.Errorf("resource graph query failed: %v", err)
+			}
+			debugPrintf("Resource Graph page %d: %d of %d rows\n", page, response.Count, response.TotalRecords)
+			rows = append(rows, response.Data...)
+
+			if response.SkipToken == "" {
+				break
+			}
+			request.Options.SkipToken = response.SkipToken
+		}
+	}
+	return rows, nil
+}
+
+// searchVirtualMachines finds virtual machines in every subscription of the
+// tenant in use
+func searchVirtualMachines(ctx context.Context, cred azcore.TokenCredential) ([]vmSearchResult, error) {
+	return searchTenantVirtualMachines(ctx, currentTenantID(), cred)
+}
+
+// searchTenantVirtualMachines finds virtual machines in every subscription of a tenant
+func searchTenantVirtualMachines(ctx context.Context, tenantID string, cred azcore.TokenCredential) ([]vmSearchResult, error) {
+	subs, err := tenantSubscriptions(ctx, tenantID, cred)
+	if err != nil {
+		return nil, err
+	}
+	subscriptionIDs := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		subscriptionIDs = append(subscriptionIDs, *sub.SubscriptionID)
+	}
+
+	return cachedTenantDiscovery(ctx, tenantID, "", "vm-search", func(ctx context.Context) ([]vmSearchResult, error) {
+		return queryResourceGraph[vmSearchResult](ctx, cred, subscriptionIDs, vmSearchQuery)
+	})
+}
+
+// searchAllTenantVirtualMachines finds virtual machines in every tenant,
+// skipping tenants that can't be searched
+func searchAllTenantVirtualMachines(ctx context.Context, cred azcore.TokenCredential) ([]vmSearchResult, error) {
+	tenants, err := listTenants(ctx, cred)
+	if err != nil {
+		return nil, err
+	}
+
+	var vms []vmSearchResult
+	for _, t := range tenants {
+		tenantCred, err := credentialForTenant(t.TenantID)
+		if err != nil {
+			return nil, err
+		}
+		found, err := searchTenantVirtualMachines(ctx, t.TenantID, tenantCred)
+		if err != nil {
+			fmt.Printf("Warning: skipping tenant %s: %v\n", t.label(), err)
+			continue
+		}
+		vms = append(vms, found...)
+	}
+	return vms, nil
+}
+
+// getResourceSearchSelection lets the user pick a virtual machine from all
+// subscriptions of the tenant in use or, with allTenants, of every tenant
+func getResourceSearchSelection(ctx context.Context, cred azcore.TokenCredential, allTenants bool) (*config.TargetResource, error) {
+	var vms []vmSearchResult
+	var err error
+	if allTenants {
+		fmt.Println("Searching virtual machines in all tenants...")
+		vms, err = searchAllTenantVirtualMachines(ctx, cred)
+	} else {
+		fmt.Println("Searching virtual machines in all subscriptions...")
+		vms, err = searchVirtualMachines(ctx, cred)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(vms) == 0 {
+		return nil, fmt.Errorf("no virtual machines found in any subscription")
+	}
+
+	entries := make([]vmEntry, 0, len(vms))
+	for _, vm := range vms {
+		entries = append(entries, vmEntry{
+			ID:             vm.ID,
+			Name:           vm.Name,
+			ResourceGroup:  vm.ResourceGroup,
+			SubscriptionID: vm.SubscriptionID,
+			TenantID:       vm.TenantID,
+			OSType:         vm.OSType,
+			PowerState:     vm.PowerState,
+			Tags:           vm.Tags,
+			label:          vmSearchLabel(vm),
+		})
+	}
+
+	vm, err := selectVM(entries, "Select virtual machine")
+	if err != nil {
+		return nil, err
+	}
+
+	return &config.TargetResource{
+		ID:             vm.ID,
+		Name:           vm.Name,
+		Type:           virtualMachineType,
+		SubscriptionID: vm.SubscriptionID,
+		TenantID:       vm.TenantID,
+		OSType:         vm.OSType,
+		Defaults:       targetDefaultsFromTags(vm.Name, vm.Tags),
+	}, nil
+}
+
+// vmSearchLabel renders a search result for the picker
+func vmSearchLabel(vm vmSearchResult) string {
+	subscription := vm.SubscriptionName
+	if subscription == "" {
+		subscription = vm.SubscriptionID
+	}
+	label := fmt.Sprintf("%s | Group: %s | Subscription: %s | Region: %s", vm.Name, vm.ResourceGroup, subscription, vm.Location)
+	if vm.OSType != "" {
+		label += " | " + vm.OSType
+	}
+	if vm.PowerState != "" {
+		label += " | " + strings.TrimPrefix(vm.PowerState, "VM ")
+	}
+	if vm.PrivateIP != "" {
+		label += " | " + vm.PrivateIP
+	}
+	return label
+}
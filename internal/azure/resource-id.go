@@ -0,0 +1,144 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+)
+
+// Resource types accepted as connection endpoints
+const (
+	bastionHostType    = "Microsoft.Network/bastionHosts"
+	virtualMachineType = "Microsoft.Compute/virtualMachines"
+	scaleSetType       = "Microsoft.Compute/virtualMachineScaleSets"
+	scaleSetVMType     = "Microsoft.Compute/virtualMachineScaleSets/virtualMachines"
+)
+
+// resourceID is a parsed Azure Resource Manager resource ID
+type resourceID struct {
+	// ID is the normalized resource ID
+	ID             string
+	SubscriptionID string
+	ResourceGroup  string
+	// Type is the full resource type, e.g. Microsoft.Compute/virtualMachines
+	Type string
+	Name string
+}
+
+// parseResourceID parses a resource ID. It also accepts an Azure portal URL
+// or an ID pasted with a trailing blade such as /overview.
+func parseResourceID(input string) (*resourceID, error) {
+	raw := strings.TrimSpace(input)
+	if raw == "" {
+		return nil, fmt.Errorf("resource ID cannot be empty")
+	}
+	if unescaped, err := url.PathUnescape(raw); err == nil {
+		raw = unescaped
+	}
+
+	if !strings.HasPrefix(raw, "/") {
+		raw = "/" + raw
+	}
+	// Portal URLs carry the ID in the fragment, after the tenant
+	start := strings.Index(strings.ToLower(raw), "/subscriptions/")
+	if start < 0 {
+		return nil, fmt.Errorf("invalid resource ID %q: expected /subscriptions/<id>/resourceGroups/<group>/providers/...", input)
+	}
+	raw = raw[start:]
+	if end := strings.IndexAny(raw, "?#"); end >= 0 {
+		raw = raw[:end]
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(raw, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	// After providers/<namespace>, segments come in type/name pairs; an odd
+	// one left over is a portal blade
+	for i, segment := range segments {
+		if strings.EqualFold(segment, "providers") {
+			if rest := len(segments) - i - 2; rest > 0 && rest%2 == 1 {
+				segments = segments[:len(segments)-1]
+			}
+			break
+		}
+	}
+
+	parsed, err := arm.ParseResourceID("/" + strings.Join(segments, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource ID %q: %v", input, err)
+	}
+	if parsed.SubscriptionID == "" || parsed.ResourceGroupName == "" || parsed.Name == "" {
+		return nil, fmt.Errorf("invalid resource ID %q: expected a resource in a resource group", input)
+	}
+
+	return &resourceID{
+		ID:             parsed.String(),
+		SubscriptionID: parsed.SubscriptionID,
+		ResourceGroup:  parsed.ResourceGroupName,
+		Type:           parsed.ResourceType.String(),
+		Name:           parsed.Name,
+	}, nil
+}
+
+// parseResourceIDOfType parses a resource ID and checks it is one of the
+// expected resource types
+func parseResourceIDOfType(input string, types ...string) (*resourceID, error) {
+	id, err := parseResourceID(input)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range types {
+		if strings.EqualFold(id.Type, t) {
+			return id, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is a %s, expected %s", id.Name, id.Type, strings.Join(types, " or "))
+}
+
+// confirmResourceExists reads a resource to make sure a pasted ID points at
+// something. A missing resource is an error; other failures, such as missing
+// read permission, only print a warning since az may still connect.
+func confirmResourceExists(ctx context.Context, cred azcore.TokenCredential, id *resourceID, apiVersion string) error {
+	if offlineMode {
+		return nil
+	}
+
+	_, err := getResourceProperties(ctx, cred, id.ID, apiVersion)
+	if err == nil {
+		return nil
+	}
+
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s %s not found in resource group %s of subscription %s", id.Type, id.Name, id.ResourceGroup, id.SubscriptionID)
+	}
+	fmt.Printf("Warning: could not confirm that %s exists: %v\n", id.Name, err)
+	return nil
+}
+
+// resourceGroupFromID extracts the resource group from a resource ID
+func resourceGroupFromID(resourceID string) string {
+	id, err := arm.ParseResourceID(resourceID)
+	if err != nil {
+		return ""
+	}
+	return id.ResourceGroupName
+}
+
+// subscriptionFromID extracts the subscription from a resource ID
+func subscriptionFromID(resourceID string) string {
+	id, err := arm.ParseResourceID(resourceID)
+	if err != nil {
+		return ""
+	}
+	return id.SubscriptionID
+}
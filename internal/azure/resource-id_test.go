@@ -0,0 +1,130 @@
+package azure
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseResourceIDOfType(t *testing.T) {
+	const vmID = "/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/rg-app/providers/Microsoft.Compute/virtualMachines/vm-web"
+
+	tests := []struct {
+		name    string
+		input   string
+		types   []string
+		wantID  string
+		wantErr string
+	}{
+		{
+			name:   "resource ID",
+			input:  vmID,
+			types:  []string{virtualMachineType},
+			wantID: vmID,
+		},
+		{
+			name:   "portal URL",
+			input:  "https://portal.azure.com/#@contoso.onmicrosoft.com/resource" + vmID + "/overview",
+			types:  []string{virtualMachineType},
+			wantID: vmID,
+		},
+		{
+			name:   "portal URL with query",
+			input:  "https://portal.azure.com/#@contoso.onmicrosoft.com/resource" + vmID + "/connect?tab=bastion",
+			types:  []string{virtualMachineType},
+			wantID: vmID,
+		},
+		{
+			name:   "trailing overview and whitespace",
+			input:  "  " + vmID + "/overview\n",
+			types:  []string{virtualMachineType},
+			wantID: vmID,
+		},
+		{
+			name:   "escaped portal URL",
+			input:  "https://portal.azure.com/#@contoso.onmicrosoft.com/resource" + strings.ReplaceAll(vmID, "/", "%2F"),
+			types:  []string{virtualMachineType},
+			wantID: vmID,
+		},
+		{
+			name:   "without leading slash",
+			input:  strings.TrimPrefix(vmID, "/"),
+			types:  []string{virtualMachineType},
+			wantID: vmID,
+		},
+		{
+			name:   "mixed case",
+			input:  "/SUBSCRIPTIONS/00000000-0000-0000-0000-000000000001/resourcegroups/rg-app/PROVIDERS/microsoft.compute/VIRTUALMACHINES/vm-web",
+			types:  []string{virtualMachineType},
+			wantID: "/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/rg-app/providers/microsoft.compute/VIRTUALMACHINES/vm-web",
+		},
+		{
+			name:   "one of several types",
+			input:  "/subscriptions/sub-1/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/vmss/virtualMachines/3",
+			types:  []string{virtualMachineType, scaleSetVMType},
+			wantID: "/subscriptions/sub-1/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/vmss/virtualMachines/3",
+		},
+		{
+			name:    "wrong resource type",
+			input:   "/subscriptions/sub-1/resourceGroups/rg/providers/Microsoft.Network/bastionHosts/bastion-hub",
+			types:   []string{virtualMachineType},
+			wantErr: "bastion-hub is a Microsoft.Network/bastionHosts, expected Microsoft.Compute/virtualMachines",
+		},
+		{
+			name:    "scale set is not an instance",
+			input:   "/subscriptions/sub-1/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/vmss",
+			types:   []string{virtualMachineType, scaleSetVMType},
+			wantErr: "expected Microsoft.Compute/virtualMachines or Microsoft.Compute/virtualMachineScaleSets/virtualMachines",
+		},
+		{
+			name:    "resource group only",
+			input:   "/subscriptions/sub-1/resourceGroups/rg",
+			types:   []string{virtualMachineType},
+			wantErr: "rg is a Microsoft.Resources/resourceGroups",
+		},
+		{
+			name:    "subscription only",
+			input:   "/subscriptions/sub-1",
+			types:   []string{virtualMachineType},
+			wantErr: "expected a resource in a resource group",
+		},
+		{
+			name:    "not an ID",
+			input:   "vm-web",
+			types:   []string{virtualMachineType},
+			wantErr: "expected /subscriptions/<id>/resourceGroups/<group>/providers/...",
+		},
+		{
+			name:    "empty",
+			input:   "  ",
+			types:   []string{virtualMachineType},
+			wantErr: "cannot be empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := parseResourceIDOfType(tt.input, tt.types...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseResourceIDOfType: %v", err)
+			}
+			if id.ID != tt.wantID {
+				t.Errorf("ID = %q, want %q", id.ID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestParseResourceIDParts(t *testing.T) {
+	id, err := parseResourceIDOfType("https://portal.azure.com/#@contoso.onmicrosoft.com/resource/subscriptions/sub-1/resourceGroups/RG-App/providers/Microsoft.Network/bastionHosts/bastion-hub/overview", bastionHostType)
+	if err != nil {
+		t.Fatalf("parseResourceIDOfType: %v", err)
+	}
+	if id.SubscriptionID != "sub-1" || id.ResourceGroup != "RG-App" || id.Name != "bastion-hub" || id.Type != bastionHostType {
+		t.Errorf("id = %+v", id)
+	}
+}
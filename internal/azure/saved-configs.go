@@ -0,0 +1,259 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/antnsn/BastionBuddy/internal/tunnels"
+	"github.com/antnsn/BastionBuddy/internal/utils"
+)
+
+// savedConfigLabel renders a saved configuration for the interactive pickers
+func savedConfigLabel(config tunnels.Config) string {
+	connectionType := config.ConnectionType
+	if connectionType == "" {
+		connectionType = "tunnel"
+	}
+
+	label := fmt.Sprintf("%s [%s] - %s", config.Name, connectionType, config.ResourceName)
+	if len(config.Tags) > 0 {
+		label += fmt.Sprintf(" (%s)", tunnels.FormatTags(config.Tags))
+	}
+	if config.Description != "" {
+		label += " | " + config.Description
+	}
+	return label
+}
+
+// connectSavedConfiguration lets the user pick a saved configuration and connects with it
+func connectSavedConfiguration() error {
+	manager, err := GetTunnelManager()
+	if err != nil {
+		return fmt.Errorf("failed to get tunnel manager: %v", err)
+	}
+
+	configs := manager.GetSavedConfigs()
+	if len(configs) == 0 {
+		fmt.Println("No saved configurations found")
+		return nil
+	}
+
+	var items []string
+	configMap := make(map[string]tunnels.Config)
+	for _, config := range configs {
+		item := savedConfigLabel(config)
+		items = append(items, item)
+		configMap[item] = config
+	}
+
+	selected, err := utils.SelectWithMenu(items, "Select saved configuration (type to filter by name, tag or description)")
+	if err != nil {
+		return err
+	}
+
+	config := configMap[selected]
+	switch config.ConnectionType {
+	case "ssh":
+		return StartSavedSSH(config.Name)
+	case "rdp":
+		return StartSavedRDP(config.Name)
+	default:
+		_, err := StartSavedTunnel(config.Name)
+		return err
+	}
+}
+
+// StartConfigurations starts every saved tunnel configuration matching the filter.
+// SSH and RDP configurations are interactive and are skipped.
+func StartConfigurations(filter tunnels.Filter) error {
+	if filter.IsEmpty() {
+		return fmt.Errorf("refusing to start all configurations; specify --tag, --search or a type")
+	}
+
+	manager, err := GetTunnelManager()
+	if err != nil {
+		return fmt.Errorf("failed to get tunnel manager: %v", err)
+	}
+
+	configs := manager.configMgr.FilterConfigs(filter)
+	if len(configs) == 0 {
+		fmt.Println("No saved configurations match the filter")
+		return nil
+	}
+
+	var failed []string
+	for _, config := range configs {
+		if config.ConnectionType == "ssh" || config.ConnectionType == "rdp" {
+			fmt.Printf("Skipping %s: %s connections are interactive\n", config.Name, config.ConnectionType)
+			continue
+		}
+
+		fmt.Printf("Starting %s...\n", config.Name)
+		if _, err := StartSavedTunnel(config.Name); err != nil {
+			fmt.Printf("Warning: failed to start %s: %v\n", config.Name, err)
+			failed = append(failed, config.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to start: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// StopConfigurations stops every active tunnel started from a saved configuration matching the filter
+func StopConfigurations(filter tunnels.Filter) error {
+	if filter.IsEmpty() {
+		return fmt.Errorf("refusing to stop all tunnels; specify --tag, --search or a type")
+	}
+
+	manager, err := GetTunnelManager()
+	if err != nil {
+		return fmt.Errorf("failed to get tunnel manager: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for _, config := range manager.configMgr.FilterConfigs(filter) {
+		names[config.Name] = true
+	}
+
+	stopped := 0
+	var lastErr error
+	for _, tunnel := range manager.ListTunnels() {
+		if !names[tunnel.ConfigName] {
+			continue
+		}
+		if err := manager.StopTunnel(tunnel.ID); err != nil {
+			lastErr = fmt.Errorf("failed to stop tunnel %s: %v", tunnel.ConfigName, err)
+			fmt.Printf("Warning: %v\n", lastErr)
+			continue
+		}
+		stopped++
+	}
+
+	fmt.Printf("Stopped %d tunnel(s)\n", stopped)
+	return lastErr
+}
+
+// ExportConfigurations writes the saved configurations matching the filter as JSON
+func ExportConfigurations(filter tunnels.Filter, w io.Writer) error {
+	manager, err := GetTunnelManager()
+	if err != nil {
+		return fmt.Errorf("failed to get tunnel manager: %v", err)
+	}
+
+	configs := manager.configMgr.FilterConfigs(filter)
+	if configs == nil {
+		configs = []tunnels.Config{}
+	}
+
+	data, err := json.MarshalIndent(configs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal configurations: %v", err)
+	}
+	if _, err := fmt.Fprintln(w, string(data)); err != nil {
+		return fmt.Errorf("failed to write configurations: %v", err)
+	}
+	return nil
+}
+
+// TagConfiguration sets and removes tags on a saved configuration
+func TagConfiguration(name string, set map[string]string, remove []string) error {
+	return updateConfiguration(name, func(config *tunnels.Config) {
+		if config.Tags == nil {
+			config.Tags = make(map[string]string)
+		}
+		for key, value := range set {
+			config.Tags[key] = value
+		}
+		for _, key := range remove {
+			delete(config.Tags, key)
+		}
+		if len(config.Tags) == 0 {
+			config.Tags = nil
+		}
+	})
+}
+
+// DescribeConfiguration sets the description of a saved configuration
+func DescribeConfiguration(name string, description string) error {
+	return updateConfiguration(name, func(config *tunnels.Config) {
+		config.Description = description
+	})
+}
+
+// SetStopVMOnDisconnect sets whether a VM started for a saved configuration
+// is deallocated again when the connection ends
+func SetStopVMOnDisconnect(name string, enabled bool) error {
+	return updateConfiguration(name, func(config *tunnels.Config) {
+		config.StopVMOnDisconnect = enabled
+	})
+}
+
+// SetConfigurationJIT sets the just-in-time access request made before a saved
+// configuration connects; nil turns it off
+func SetConfigurationJIT(name string, jit *tunnels.JITAccess) error {
+	return updateConfiguration(name, func(config *tunnels.Config) {
+		config.JIT = jit
+	})
+}
+
+// SetConfigurationPIMRole sets the PIM role a saved configuration activates
+// when its subscription is not accessible; nil forgets it
+func SetConfigurationPIMRole(name string, role *tunnels.PIMRole) error {
+	return updateConfiguration(name, func(config *tunnels.Config) {
+		config.PIMRole = role
+	})
+}
+
+// updateConfiguration applies an update to a saved user configuration
+func updateConfiguration(name string, update func(config *tunnels.Config)) error {
+	manager, err := GetTunnelManager()
+	if err != nil {
+		return fmt.Errorf("failed to get tunnel manager: %v", err)
+	}
+
+	config, ok := manager.configMgr.FindConfig(name)
+	if !ok {
+		return fmt.Errorf("configuration '%s' not found", name)
+	}
+	if config.ReadOnly {
+		return fmt.Errorf("configuration '%s' is read-only (from %s)", name, config.Source)
+	}
+
+	update(&config)
+	if err := manager.configMgr.SaveConfig(config); err != nil {
+		return fmt.Errorf("failed to save configuration: %v", err)
+	}
+	return nil
+}
+
+// ShowConfiguration writes a saved configuration as JSON, optionally with
+// template variables resolved
+func ShowConfiguration(name string, resolve bool, w io.Writer) error {
+	manager, err := GetTunnelManager()
+	if err != nil {
+		return fmt.Errorf("failed to get tunnel manager: %v", err)
+	}
+
+	config, ok := manager.configMgr.FindConfig(name)
+	if !ok {
+		return fmt.Errorf("configuration '%s' not found", name)
+	}
+	if resolve {
+		if config, err = resolveSavedConfig(config); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal configuration: %v", err)
+	}
+	if _, err := fmt.Fprintf(w, "# Source: %s\n%s\n", describeSource(config), data); err != nil {
+		return fmt.Errorf("failed to write configuration: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package azure
+
+import (
+	"fmt"
+
+	"github.com/antnsn/BastionBuddy/internal/secrets"
+	"github.com/antnsn/BastionBuddy/internal/tunnels"
+	"github.com/antnsn/BastionBuddy/internal/utils"
+)
+
+// readSecret returns a secret from the vault, unlocking it if necessary
+func readSecret(name string) (string, error) {
+	vault, err := secrets.OpenUnlocked(utils.ReadPassword)
+	if err != nil {
+		return "", err
+	}
+	return vault.Get(name)
+}
+
+// SetConfigurationSecret references a vault secret from a saved configuration.
+// An empty secret name removes the reference.
+func SetConfigurationSecret(name string, secretName string) error {
+	if secretName != "" {
+		vault, err := secrets.OpenUnlocked(utils.ReadPassword)
+		if err != nil {
+			return err
+		}
+		if _, err := vault.Get(secretName); err != nil {
+			return err
+		}
+	}
+
+	return updateConfiguration(name, func(config *tunnels.Config) {
+		config.PasswordSecret = secretName
+		if secretName != "" && config.ConnectionType == "ssh" && config.AuthType == "" {
+			config.AuthType = "password"
+		}
+	})
+}
+
+// SetConfigurationKeyVaultSecret references a Key Vault secret from a saved
+// SSH configuration. A nil reference removes it.
+func SetConfigurationKeyVaultSecret(name string, ref *tunnels.KeyVaultSecret) error {
+	if ref != nil {
+		switch ref.Kind {
+		case "":
+			ref.Kind = "password"
+		case "password", "ssh-key":
+		default:
+			return fmt.Errorf("invalid Key Vault secret kind %q: expected password or ssh-key", ref.Kind)
+		}
+	}
+
+	return updateConfiguration(name, func(config *tunnels.Config) {
+		config.KeyVaultSecret = ref
+	})
+}
+
+// describeSecret returns how a configuration's credentials are supplied, for listings
+func describeSecret(config tunnels.Config) string {
+	if ref := config.KeyVaultSecret; ref != nil {
+		return fmt.Sprintf("keyvault:%s/%s (%s)", ref.Vault, ref.Secret, ref.Kind)
+	}
+	if config.PasswordSecret == "" {
+		return ""
+	}
+	return fmt.Sprintf("vault:%s", config.PasswordSecret)
+}
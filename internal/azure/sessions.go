@@ -0,0 +1,344 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/antnsn/BastionBuddy/internal/config"
+	"github.com/antnsn/BastionBuddy/internal/utils"
+)
+
+// bastionSession is an active session through a Bastion host
+type bastionSession struct {
+	SessionID        string      `json:"sessionId"`
+	StartTime        interface{} `json:"startTime"`
+	UserName         string      `json:"userName"`
+	Protocol         string      `json:"protocol"`
+	TargetHostName   string      `json:"targetHostName"`
+	TargetIPAddress  string      `json:"targetIpAddress"`
+	TargetResourceID string      `json:"targetResourceId"`
+	DurationInMins   float64     `json:"sessionDurationInMins"`
+}
+
+// target returns the name of the VM or address the session connects to
+func (s bastionSession) target() string {
+	switch {
+	case s.TargetHostName != "":
+		return s.TargetHostName
+	case s.TargetResourceID != "":
+		return resourceNameFromID(s.TargetResourceID)
+	default:
+		return s.TargetIPAddress
+	}
+}
+
+// started renders the start time; the API returns it as a string
+func (s bastionSession) started() string {
+	value, ok := s.StartTime.(string)
+	if !ok {
+		return ""
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Local().Format("2006-01-02 15:04")
+	}
+	return value
+}
+
+// duration renders how long the session has been active
+func (s bastionSession) duration() string {
+	return shortDuration(time.Duration(s.DurationInMins * float64(time.Minute)))
+}
+
+// label renders a session for the session picker
+func (s bastionSession) label() string {
+	return fmt.Sprintf("%s → %s (%s, since %s, %s)", s.UserName, s.target(), s.Protocol, s.started(), s.duration())
+}
+
+// bastionSessionDeleteResult is the outcome of disconnecting one session
+type bastionSessionDeleteResult struct {
+	SessionID string `json:"sessionId"`
+	Message   string `json:"message"`
+	State     string `json:"state"`
+}
+
+// listBastionSessions returns the active sessions of a Bastion host
+func listBastionSessions(ctx context.Context, cred azcore.TokenCredential, host *config.BastionHost) ([]bastionSession, error) {
+	var page struct {
+		Value    []bastionSession `json:"value"`
+		NextLink string           `json:"nextLink"`
+	}
+	path := bastionHostID(host) + "/getActiveSessions?api-version=" + networkAPIVersion
+	if err := armLongRunning(ctx, cred, http.MethodPost, path, nil, &page); err != nil {
+		return nil, fmt.Errorf("failed to list sessions of Bastion host %s: %v", host.Name, err)
+	}
+
+	sessions := page.Value
+	if page.NextLink != "" {
+		more, err := armList[bastionSession](ctx, cred, strings.TrimPrefix(page.NextLink, armEndpoint()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list sessions of Bastion host %s: %v", host.Name, err)
+		}
+		sessions = append(sessions, more...)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].DurationInMins > sessions[j].DurationInMins
+	})
+	return sessions, nil
+}
+
+// disconnectBastionSessions ends sessions on a Bastion host and reports
+// the outcome of each
+func disconnectBastionSessions(ctx context.Context, cred azcore.TokenCredential, host *config.BastionHost, sessionIDs []string) error {
+	body := struct {
+		SessionIDs []string `json:"sessionIds"`
+	}{sessionIDs}
+	results, err := armPostList[bastionSessionDeleteResult](ctx, cred, bastionHostID(host)+"/disconnectActiveSessions?api-version="+networkAPIVersion, body)
+	if err != nil {
+		return fmt.Errorf("failed to disconnect sessions on Bastion host %s: %v", host.Name, err)
+	}
+
+	failed := 0
+	for _, result := range results {
+		if strings.EqualFold(result.State, "Disconnected") {
+			fmt.Printf("✓ Disconnected session %s\n", result.SessionID)
+			continue
+		}
+		failed++
+		fmt.Printf("✗ Session %s: %s %s\n", result.SessionID, result.State, result.Message)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sessions could not be disconnected", failed, len(sessionIDs))
+	}
+	return nil
+}
+
+// findBastionHost resolves a Bastion host given by resource ID, portal URL or
+// name. A name is looked up in every subscription; if several hosts share
+// it, the user picks one.
+func findBastionHost(ctx context.Context, cred azcore.TokenCredential, bastion string) (*config.BastionHost, error) {
+	if strings.Contains(bastion, "/") {
+		id, err := parseResourceIDOfType(bastion, bastionHostType)
+		if err != nil {
+			return nil, err
+		}
+		return &config.BastionHost{Name: id.Name, ResourceGroup: id.ResourceGroup, SubscriptionID: id.SubscriptionID}, nil
+	}
+
+	records, err := searchBastionHosts(ctx, cred)
+	if err != nil {
+		return nil, err
+	}
+	var items []string
+	hostMap := make(map[string]*config.BastionHost)
+	for _, record := range records {
+		if !strings.EqualFold(record.Name, bastion) {
+			continue
+		}
+		item := fmt.Sprintf("%s (%s) | subscription %s", record.Name, record.ResourceGroup, record.SubscriptionID)
+		items = append(items, item)
+		hostMap[item] = &config.BastionHost{Name: record.Name, ResourceGroup: record.ResourceGroup, SubscriptionID: record.SubscriptionID}
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("bastion host '%s' not found; use its resource ID if it is in another tenant", bastion)
+	}
+	if len(items) == 1 {
+		return hostMap[items[0]], nil
+	}
+
+	selected, err := utils.SelectWithMenu(items, "Several Bastion hosts are named "+bastion+". Select one")
+	if err != nil {
+		return nil, err
+	}
+	return hostMap[selected], nil
+}
+
+// writeBastionSessions prints sessions as a table
+func writeBastionSessions(w io.Writer, host *config.BastionHost, sessions []bastionSession) error {
+	if len(sessions) == 0 {
+		_, err := fmt.Fprintf(w, "No active sessions on Bastion host %s\n", host.Name)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "SESSION ID\tUSER\tTARGET\tPROTOCOL\tSTARTED\tDURATION"); err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			session.SessionID, session.UserName, session.target(), session.Protocol, session.started(), session.duration()); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// ListBastionSessions prints the active sessions of a Bastion host, given by
+// name, resource ID or portal URL
+func ListBastionSessions(bastion string, w io.Writer) error {
+	cred, err := GetAzureCredential()
+	if err != nil {
+		return fmt.Errorf("failed to get Azure credentials: %v", err)
+	}
+	ctx := context.Background()
+
+	host, err := findBastionHost(ctx, cred, bastion)
+	if err != nil {
+		return err
+	}
+	sessions, err := listBastionSessions(ctx, cred, host)
+	if err != nil {
+		return err
+	}
+	return writeBastionSessions(w, host, sessions)
+}
+
+// DisconnectBastionSessions ends active Bastion sessions by ID. Without a
+// Bastion host, every host that supports session management is searched
+// for the sessions.
+func DisconnectBastionSessions(bastion string, sessionIDs []string) error {
+	cred, err := GetAzureCredential()
+	if err != nil {
+		return fmt.Errorf("failed to get Azure credentials: %v", err)
+	}
+	ctx := context.Background()
+
+	if bastion != "" {
+		host, err := findBastionHost(ctx, cred, bastion)
+		if err != nil {
+			return err
+		}
+		return disconnectBastionSessions(ctx, cred, host, sessionIDs)
+	}
+
+	records, err := searchBastionHosts(ctx, cred)
+	if err != nil {
+		return err
+	}
+	remaining := make(map[string]bool)
+	for _, id := range sessionIDs {
+		remaining[id] = true
+	}
+	fmt.Printf("Looking for the sessions on %d Bastion hosts...\n", len(records))
+	for _, record := range records {
+		if len(remaining) == 0 {
+			break
+		}
+		if record.features().supportsSessions() != nil {
+			continue
+		}
+		host := &config.BastionHost{Name: record.Name, ResourceGroup: record.ResourceGroup, SubscriptionID: record.SubscriptionID}
+		sessions, err := listBastionSessions(ctx, cred, host)
+		if err != nil {
+			debugPrintf("Skipping %s: %v\n", record.Name, err)
+			continue
+		}
+		var found []string
+		for _, session := range sessions {
+			if remaining[session.SessionID] {
+				found = append(found, session.SessionID)
+				delete(remaining, session.SessionID)
+			}
+		}
+		if len(found) > 0 {
+			if err := disconnectBastionSessions(ctx, cred, host, found); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(remaining) > 0 {
+		var missing []string
+		for id := range remaining {
+			missing = append(missing, id)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("no active session found with ID %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// selectBastionAction lets the user connect through the selected Bastion host
+// or first manage its active sessions. It returns nil to connect and
+// ErrReturnToMain when the user goes back to the main menu. Hosts that don't
+// support session management connect right away.
+func selectBastionAction(ctx context.Context, cred azcore.TokenCredential, host *config.BastionHost, connectionType ConnectionType) error {
+	features, err := getBastionFeatures(ctx, cred, bastionHostID(host))
+	if err != nil {
+		debugPrintf("Could not read features of %s: %v\n", host.Name, err)
+	} else if features.supportsSessions() != nil {
+		return nil
+	}
+
+	connect := fmt.Sprintf("Connect (%s) through %s", connectionName(connectionType), host.Name)
+	sessions := "Manage active sessions on " + host.Name
+	const returnToMain = "Return to main menu"
+	for {
+		selected, err := utils.SelectWithMenu([]string{connect, sessions, returnToMain}, "Bastion host "+host.Name)
+		if err == utils.ErrReturnToMain || selected == returnToMain {
+			return utils.ErrReturnToMain
+		}
+		if err != nil {
+			return fmt.Errorf("failed to select action: %v", err)
+		}
+		if selected == connect {
+			return nil
+		}
+		if err := manageBastionSessions(ctx, cred, host); err != nil {
+			return err
+		}
+	}
+}
+
+// manageBastionSessions lists the active sessions of a Bastion host to
+// disconnect one at a time, until the user goes back
+func manageBastionSessions(ctx context.Context, cred azcore.TokenCredential, host *config.BastionHost) error {
+	const (
+		refresh = "Refresh"
+		back    = "Back"
+	)
+	for {
+		sessions, err := listBastionSessions(ctx, cred, host)
+		if err != nil {
+			return err
+		}
+
+		items := make([]string, 0, len(sessions)+2)
+		sessionMap := make(map[string]bastionSession)
+		for _, session := range sessions {
+			item := "Disconnect: " + session.label()
+			items = append(items, item)
+			sessionMap[item] = session
+		}
+		items = append(items, refresh, back)
+
+		prompt := fmt.Sprintf("%d active sessions on %s", len(sessions), host.Name)
+		selected, err := utils.SelectWithMenu(items, prompt)
+		if err == utils.ErrReturnToMain || selected == back {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to select session: %v", err)
+		}
+
+		session, ok := sessionMap[selected]
+		if !ok {
+			continue
+		}
+		confirm, err := utils.SelectWithMenu([]string{"Disconnect " + session.UserName, "Cancel"}, "Disconnect "+session.label()+"?")
+		if err != nil && err != utils.ErrReturnToMain {
+			return err
+		}
+		if err == nil && confirm != "Cancel" {
+			if err := disconnectBastionSessions(ctx, cred, host, []string{session.SessionID}); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newStandIn starts a loopback Resource Manager stand-in serving mux and
+// makes it the cloud in use for the test
+func newStandIn(t *testing.T, mux *http.ServeMux) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(mux)
+	mux.HandleFunc("/metadata/endpoints", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `[{"name":"StandIn","resourceManager":%q,"authentication":{"loginEndpoint":"https://login.example/","audiences":[%q]},"suffixes":{"keyVaultDns":".vault.example"}}]`, srv.URL, srv.URL)
+	})
+
+	previous := sessionCloud
+	t.Cleanup(func() {
+		srv.Close()
+		sessionCloud = previous
+		customCloudsMu.Lock()
+		delete(customClouds, strings.ToLower(srv.URL))
+		customCloudsMu.Unlock()
+	})
+	if err := SetCloud(srv.URL); err != nil {
+		t.Fatalf("SetCloud: %v", err)
+	}
+	return srv
+}
+
+// requireBearer fails the request unless it carries the fake token
+func requireBearer(t *testing.T, r *http.Request) {
+	t.Helper()
+	if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
+		t.Errorf("%s %s: Authorization = %q", r.Method, r.URL.Path, got)
+	}
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	t.Helper()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Errorf("encode response: %v", err)
+	}
+}
@@ -1,13 +1,11 @@
 package azure
 
 import (
-	"encoding/json"
 	"fmt"
 	"sync"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/antnsn/BastionBuddy/internal/tunnels"
-	"github.com/antnsn/BastionBuddy/internal/utils"
 )
 
 var (
@@ -16,64 +14,37 @@ var (
 		sync.RWMutex
 		initialized    bool
 		initializeOnce sync.Once
-		cred           *azidentity.DefaultAzureCredential
-		tunnelManager  *TunnelManager
+		cred           azcore.TokenCredential
+		// credCloud is the cloud cred was created for
+		credCloud string
+		// tenantCreds holds the credentials for other tenants and clouds, by
+		// lower-cased cloud and tenant ID
+		tenantCreds   map[string]azcore.TokenCredential
+		tunnelManager *TunnelManager
 	}
 )
 
-// checkAzLogin checks if the user is logged in to Azure CLI
-func checkAzLogin() error {
-	cmd := utils.PrepareAzureCommand("account", "show")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		// Try to parse the error to see if it's an authentication error
-		var azError struct {
-			Error struct {
-				Code    string `json:"code"`
-				Message string `json:"message"`
-			} `json:"error"`
-		}
-		if jsonErr := json.Unmarshal(output, &azError); jsonErr == nil {
-			if azError.Error.Code == "InvalidAuthenticationTokenTenant" ||
-				azError.Error.Code == "ExpiredAuthenticationToken" {
-				return fmt.Errorf("azure authentication required: %s", azError.Error.Message)
-			}
-		}
-		return fmt.Errorf("failed to check Azure login status: %v", err)
+// initializeAzure sets up Azure credentials
+func initializeAzure() error {
+	// Apply the active profile before anything talks to Azure
+	if err := applyProfile(); err != nil {
+		return err
 	}
-	return nil
-}
-
-// ensureAzLogin ensures the user is logged in to Azure CLI
-func ensureAzLogin() error {
-	if err := checkAzLogin(); err != nil {
-		fmt.Println("Not logged in to Azure. Please follow the instructions to log in...")
-		cmd := utils.PrepareAzureCommand("login")
-		cmd.Stdout = nil // Let Azure CLI handle the output
-		cmd.Stderr = nil
-		cmd.Stdin = nil
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("azure login failed: %v", err)
-		}
 
-		// Verify login was successful
-		if err := checkAzLogin(); err != nil {
-			return fmt.Errorf("login verification failed: %v", err)
-		}
+	// Route requests through the configured proxy before the first one
+	if err := applyNetworkSettings(); err != nil {
+		return err
 	}
-	return nil
-}
 
-// initializeAzure sets up Azure credentials
-func initializeAzure() error {
-	// First ensure the user is logged in
-	if err := ensureAzLogin(); err != nil {
+	c, err := resolveCloud(cloudSetting())
+	if err != nil {
 		return err
 	}
 
-	// Then create the credential
-	var err error
-	globalState.cred, err = azidentity.NewDefaultAzureCredential(nil)
+	// Create the credential, bound to the profile's tenant if it has one.
+	// The Azure CLI login is only checked once a connection needs az.
+	globalState.credCloud = c.Name
+	globalState.cred, err = newCredential(profileTenantID())
 	if err != nil {
 		return fmt.Errorf("failed to create Azure credential: %v", err)
 	}
@@ -97,9 +68,11 @@ func initializeTunnelManager() error {
 	for _, t := range activeTunnels {
 		tunnel := &TunnelInfo{
 			ID:                    t.ID,
+			ConfigName:            t.ConfigName,
 			LocalPort:             t.LocalPort,
 			RemotePort:            t.RemotePort,
 			ResourceID:            t.ResourceID,
+			TargetIP:              t.TargetIP,
 			ResourceName:          t.ResourceName,
 			SubscriptionID:        t.SubscriptionID,
 			BastionName:           t.BastionName,
@@ -108,6 +81,8 @@ func initializeTunnelManager() error {
 			StartTime:             t.StartTime,
 			Status:                t.Status,
 			PID:                   t.PID,
+			StopVMOnClose:         t.StopVMOnClose,
+			TenantID:              t.TenantID,
 		}
 		globalState.tunnelManager.tunnels[t.ID] = tunnel
 	}
@@ -140,15 +115,9 @@ func initialize() error {
 	return initErr
 }
 
-// GetAzureCredential returns the Azure credential
-func GetAzureCredential() (*azidentity.DefaultAzureCredential, error) {
-	if err := initialize(); err != nil {
-		return nil, err
-	}
-
-	globalState.RLock()
-	defer globalState.RUnlock()
-	return globalState.cred, nil
+// GetAzureCredential returns the Azure credential for the tenant in use
+func GetAzureCredential() (azcore.TokenCredential, error) {
+	return credentialForTenant(currentTenantID())
 }
 
 // GetTunnelManager returns the singleton instance of TunnelManager
@@ -166,9 +135,3 @@ func GetTunnelManager() (*TunnelManager, error) {
 
 	return globalState.tunnelManager, nil
 }
-
-func init() {
-	if err := initialize(); err != nil {
-		fmt.Printf("Warning: initialization failed: %v\n", err)
-	}
-}
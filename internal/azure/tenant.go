@@ -0,0 +1,257 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/subscription/armsubscription"
+	"github.com/antnsn/BastionBuddy/internal/utils"
+)
+
+// tenantsAPIVersion is the API version used to list tenants with their names
+const tenantsAPIVersion = "2022-12-01"
+
+// allTenants is returned by selectTenant when the user wants to search every tenant
+const allTenants = "*"
+
+var (
+	// sessionTenantID is the tenant discovery and connections use, set by
+	// --tenant or by choosing a tenant. Empty means the profile's tenant.
+	sessionTenantID string
+	// tenantFixed is set when --tenant chose the tenant, so it isn't asked for
+	tenantFixed bool
+)
+
+// tenant is a Microsoft Entra tenant the signed-in identity can access
+type tenant struct {
+	TenantID      string `json:"tenantId"`
+	DisplayName   string `json:"displayName"`
+	DefaultDomain string `json:"defaultDomain"`
+}
+
+// label renders a tenant for the picker
+func (t tenant) label() string {
+	name := t.DisplayName
+	if name == "" {
+		name = t.TenantID
+	}
+	if t.DefaultDomain != "" {
+		return fmt.Sprintf("%s (%s) | ID: %s", name, t.DefaultDomain, t.TenantID)
+	}
+	return fmt.Sprintf("%s | ID: %s", name, t.TenantID)
+}
+
+// SetTenant makes discovery and connections use a tenant for this run (--tenant)
+func SetTenant(tenantID string) {
+	sessionTenantID = tenantID
+	tenantFixed = tenantID != ""
+}
+
+// currentTenantID returns the tenant in use, or "" for the credential's home tenant
+func currentTenantID() string {
+	if sessionTenantID != "" {
+		return sessionTenantID
+	}
+	return profileTenantID()
+}
+
+// useTenant switches the tenant used by GetAzureCredential and the discovery cache
+func useTenant(tenantID string) {
+	if tenantID != "" && !strings.EqualFold(tenantID, currentTenantID()) {
+		debugPrintf("Switching to tenant %s\n", tenantID)
+		sessionTenantID = tenantID
+	}
+}
+
+// credentialForTenant returns a credential that acquires tokens in a tenant
+// of the cloud in use. Credentials are created once per cloud and tenant and
+// reused.
+func credentialForTenant(tenantID string) (azcore.TokenCredential, error) {
+	if err := initialize(); err != nil {
+		return nil, err
+	}
+
+	globalState.Lock()
+	cloudName := activeCloud().Name
+	if cloudName == globalState.credCloud && (tenantID == "" || strings.EqualFold(tenantID, profileTenantID())) {
+		cred := globalState.cred
+		globalState.Unlock()
+		return cred, nil
+	}
+	key := strings.ToLower(cloudName + "/" + tenantID)
+	cred, ok := globalState.tenantCreds[key]
+	globalState.Unlock()
+	if ok {
+		return cred, nil
+	}
+
+	// Creating a credential can be slow (e.g. it may run a CLI), so it is
+	// done without holding the lock
+	cred, err := newCredential(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure credential for tenant %s: %v", tenantID, err)
+	}
+
+	globalState.Lock()
+	defer globalState.Unlock()
+	if existing, ok := globalState.tenantCreds[key]; ok {
+		return existing, nil
+	}
+	if globalState.tenantCreds == nil {
+		globalState.tenantCreds = make(map[string]azcore.TokenCredential)
+	}
+	globalState.tenantCreds[key] = cred
+	return cred, nil
+}
+
+// listTenants returns the tenants the signed-in identity can access, using the discovery cache
+func listTenants(ctx context.Context, cred azcore.TokenCredential) ([]tenant, error) {
+	return cachedDiscovery(ctx, "", "tenants", func(ctx context.Context) ([]tenant, error) {
+		tenants, err := armList[tenant](ctx, cred, "/tenants?api-version="+tenantsAPIVersion)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tenants: %v", err)
+		}
+		return tenants, nil
+	})
+}
+
+// selectTenant lets the user choose the tenant to work in when they can
+// access more than one, listing the current tenant first. It returns "" when
+// there is nothing to choose, or allTenants to search every tenant.
+func selectTenant(ctx context.Context, cred azcore.TokenCredential) (string, error) {
+	if tenantFixed {
+		return "", nil
+	}
+
+	tenants, err := listTenants(ctx, cred)
+	if err != nil {
+		debugPrintf("Could not list tenants: %v\n", err)
+		return "", nil
+	}
+	if len(tenants) < 2 {
+		return "", nil
+	}
+
+	const everyTenant = "All tenants"
+	var items []string
+	tenantMap := make(map[string]string)
+	current := currentTenantID()
+	for _, t := range tenants {
+		item := t.label()
+		if current != "" && strings.EqualFold(t.TenantID, current) {
+			item += " (current)"
+			items = append([]string{item}, items...)
+		} else {
+			items = append(items, item)
+		}
+		tenantMap[item] = t.TenantID
+	}
+	items = append(items, everyTenant)
+
+	selected, err := utils.SelectWithMenu(items, "Select tenant (type to filter)")
+	if err != nil {
+		if strings.Contains(err.Error(), "cancelled by user") {
+			fmt.Println("\nOperation cancelled by user")
+			os.Exit(0)
+		}
+		return "", fmt.Errorf("failed to select tenant: %v", err)
+	}
+	if selected == everyTenant {
+		return allTenants, nil
+	}
+	return tenantMap[selected], nil
+}
+
+// tenantSubscription is a subscription with the tenant it was listed in
+type tenantSubscription struct {
+	TenantID     string
+	Tenant       string
+	Subscription *armsubscription.Subscription
+}
+
+// allTenantSubscriptions lists the subscriptions of every accessible tenant.
+// Tenants that can't be read, for example because they require MFA, are
+// reported and skipped.
+func allTenantSubscriptions(ctx context.Context, cred azcore.TokenCredential) ([]tenantSubscription, error) {
+	tenants, err := listTenants(ctx, cred)
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []tenantSubscription
+	for _, t := range tenants {
+		tenantCred, err := credentialForTenant(t.TenantID)
+		if err != nil {
+			return nil, err
+		}
+		tenantSubs, err := tenantSubscriptions(ctx, t.TenantID, tenantCred)
+		if err != nil {
+			fmt.Printf("Warning: skipping tenant %s: %v\n", t.label(), err)
+			continue
+		}
+		for _, sub := range tenantSubs {
+			subs = append(subs, tenantSubscription{TenantID: t.TenantID, Tenant: t.label(), Subscription: sub})
+		}
+	}
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("no subscriptions found in any tenant")
+	}
+	return subs, nil
+}
+
+// selectSubscriptionAllTenants lets the user pick a subscription from any
+// tenant and switches to that tenant
+func selectSubscriptionAllTenants(ctx context.Context, cred azcore.TokenCredential, prompt string) (string, error) {
+	subs, err := allTenantSubscriptions(ctx, cred)
+	if err != nil {
+		return "", err
+	}
+
+	var items []string
+	subMap := make(map[string]tenantSubscription)
+	for _, sub := range subs {
+		item := fmt.Sprintf("%s | ID: %s | Tenant: %s",
+			*sub.Subscription.DisplayName,
+			*sub.Subscription.SubscriptionID,
+			sub.Tenant)
+		items = append(items, item)
+		subMap[item] = sub
+	}
+
+	selected, err := utils.SelectWithMenu(items, prompt+" (type to filter)")
+	if err != nil {
+		return "", fmt.Errorf("failed to select subscription: %v", err)
+	}
+
+	sub := subMap[selected]
+	useTenant(sub.TenantID)
+	return *sub.Subscription.SubscriptionID, nil
+}
+
+// ListTenants prints the tenants the signed-in identity can access
+func ListTenants(w io.Writer) error {
+	cred, err := GetAzureCredential()
+	if err != nil {
+		return fmt.Errorf("failed to get Azure credentials: %v", err)
+	}
+
+	tenants, err := listTenants(context.Background(), cred)
+	if err != nil {
+		return err
+	}
+	current := currentTenantID()
+	for _, t := range tenants {
+		line := t.label()
+		if current != "" && strings.EqualFold(t.TenantID, current) {
+			line += " (current)"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
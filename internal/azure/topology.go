@@ -0,0 +1,288 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/antnsn/BastionBuddy/internal/config"
+)
+
+const (
+	// computeAPIVersion is the API version for reading virtual machines through ARM
+	computeAPIVersion = "2023-03-01"
+	// networkAPIVersion is the API version for reading network resources through ARM
+	networkAPIVersion = "2023-05-01"
+)
+
+// bastionSearchQuery lists every Bastion host with the network it is deployed
+// into. Developer SKU hosts have no IP configuration and reference their
+// virtual network directly.
+const bastionSearchQuery = `Resources
+| where type =~ 'microsoft.network/bastionhosts'
+| project id, name, resourceGroup, subscriptionId, location,
+	sku = tostring(sku.name),
+	subnetId = tostring(properties.ipConfigurations[0].properties.subnet.id),
+	virtualNetworkId = tostring(properties.virtualNetwork.id),
+	enableTunneling = tobool(properties.enableTunneling),
+	enableIpConnect = tobool(properties.enableIpConnect),
+	enableShareableLink = tobool(properties.enableShareableLink)
+| order by name asc`
+
+// bastionRecord is a Bastion host found by a Resource Graph search
+type bastionRecord struct {
+	ID                  string `json:"id"`
+	Name                string `json:"name"`
+	ResourceGroup       string `json:"resourceGroup"`
+	SubscriptionID      string `json:"subscriptionId"`
+	Location            string `json:"location"`
+	SKU                 string `json:"sku"`
+	SubnetID            string `json:"subnetId"`
+	VirtualNetworkID    string `json:"virtualNetworkId"`
+	EnableTunneling     bool   `json:"enableTunneling"`
+	EnableIPConnect     bool   `json:"enableIpConnect"`
+	EnableShareableLink bool   `json:"enableShareableLink"`
+}
+
+// features returns the host's SKU and feature flags
+func (b bastionRecord) features() bastionFeatures {
+	return bastionFeatures{
+		SKU:                 b.SKU,
+		EnableTunneling:     b.EnableTunneling,
+		EnableIPConnect:     b.EnableIPConnect,
+		EnableShareableLink: b.EnableShareableLink,
+	}
+}
+
+// vnetID returns the lower-cased ID of the virtual network the host is deployed into
+func (b bastionRecord) vnetID() string {
+	if b.VirtualNetworkID != "" {
+		return strings.ToLower(b.VirtualNetworkID)
+	}
+	return vnetFromSubnet(b.SubnetID)
+}
+
+// bastionCandidate is a Bastion host that can reach the target
+type bastionCandidate struct {
+	Host   config.BastionHost
+	Record bastionRecord
+	// Via is the peered virtual network the host lives in, empty when it is
+	// in the target's own virtual network
+	Via string
+}
+
+// label renders a candidate for the Bastion picker
+func (c bastionCandidate) label() string {
+	label := fmt.Sprintf("%s (%s)", c.Host.Name, c.Host.ResourceGroup)
+	if c.Via == "" {
+		label += " - same VNet"
+	} else {
+		label += " - peered VNet " + resourceNameFromID(c.Via)
+	}
+	label += " | " + c.Record.features().String()
+	return label
+}
+
+// reachableBastions resolves the target VM's NIC -> subnet -> VNet and returns
+// the Bastion hosts deployed in that VNet or in a directly peered VNet. When
+// none is found, the returned explanation says why.
+func reachableBastions(ctx context.Context, cred azcore.TokenCredential, target *config.TargetResource) ([]bastionCandidate, string, error) {
+	if target == nil || target.ID == "" {
+		return nil, "", fmt.Errorf("no target resource")
+	}
+
+	vnets, err := vmVirtualNetworks(ctx, cred, target.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(vnets) == 0 {
+		return nil, fmt.Sprintf("%s has no network interface in a virtual network", resourceNameFromID(target.ID)), nil
+	}
+
+	// The VM's own networks, then every connected peering of them
+	reachable := make(map[string]string)
+	for _, vnet := range vnets {
+		reachable[vnet] = ""
+	}
+	for _, vnet := range vnets {
+		peers, err := peeredVirtualNetworks(ctx, cred, vnet)
+		if err != nil {
+			debugPrintf("Could not read peerings of %s: %v\n", vnet, err)
+			continue
+		}
+		for _, peer := range peers {
+			if _, ok := reachable[peer]; !ok {
+				reachable[peer] = peer
+			}
+		}
+	}
+
+	bastions, err := searchBastionHosts(ctx, cred)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var candidates []bastionCandidate
+	for _, bastion := range bastions {
+		via, ok := reachable[bastion.vnetID()]
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, bastionCandidate{
+			Host: config.BastionHost{
+				Name:           bastion.Name,
+				ResourceGroup:  bastion.ResourceGroup,
+				SubscriptionID: bastion.SubscriptionID,
+			},
+			Record: bastion,
+			Via:    via,
+		})
+	}
+
+	// Hosts in the VM's own network first
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Via == "" && candidates[j].Via != ""
+	})
+
+	if len(candidates) > 0 {
+		return candidates, "", nil
+	}
+
+	var names []string
+	for vnet := range reachable {
+		names = append(names, resourceNameFromID(vnet))
+	}
+	sort.Strings(names)
+	explanation := fmt.Sprintf("no Bastion host can reach %s: none of %d Bastion host(s) you can see is deployed in its virtual network or a peered one (checked: %s)",
+		target.Name, len(bastions), strings.Join(names, ", "))
+	return nil, explanation, nil
+}
+
+// vmVirtualNetworks returns the lower-cased IDs of the virtual networks the
+// VM's network interfaces are connected to
+func vmVirtualNetworks(ctx context.Context, cred azcore.TokenCredential, vmID string) ([]string, error) {
+	vm, err := getResourceProperties(ctx, cred, vmID, computeAPIVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read virtual machine: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	var vnets []string
+	for _, nic := range propertyList(vm, "networkProfile", "networkInterfaces") {
+		nicID := propertyString(nic, "id")
+		if nicID == "" {
+			continue
+		}
+		nicProps, err := getResourceProperties(ctx, cred, nicID, networkAPIVersion)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read network interface: %v", err)
+		}
+		for _, ipConfig := range propertyList(nicProps, "ipConfigurations") {
+			vnet := vnetFromSubnet(propertyString(ipConfig, "properties", "subnet", "id"))
+			if vnet != "" && !seen[vnet] {
+				seen[vnet] = true
+				vnets = append(vnets, vnet)
+			}
+		}
+	}
+	return vnets, nil
+}
+
+// peeredVirtualNetworks returns the lower-cased IDs of the virtual networks
+// connected to vnetID through an established peering
+func peeredVirtualNetworks(ctx context.Context, cred azcore.TokenCredential, vnetID string) ([]string, error) {
+	props, err := getResourceProperties(ctx, cred, vnetID, networkAPIVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []string
+	for _, peering := range propertyList(props, "virtualNetworkPeerings") {
+		if state := propertyString(peering, "properties", "peeringState"); !strings.EqualFold(state, "Connected") {
+			continue
+		}
+		if remote := propertyString(peering, "properties", "remoteVirtualNetwork", "id"); remote != "" {
+			peers = append(peers, strings.ToLower(remote))
+		}
+	}
+	return peers, nil
+}
+
+// searchBastionHosts lists Bastion hosts in every subscription the user can see
+func searchBastionHosts(ctx context.Context, cred azcore.TokenCredential) ([]bastionRecord, error) {
+	subs, err := getSubscriptions(ctx, cred)
+	if err != nil {
+		return nil, err
+	}
+	subscriptionIDs := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		subscriptionIDs = append(subscriptionIDs, *sub.SubscriptionID)
+	}
+
+	return cachedDiscovery(ctx, "", "bastion-search", func(ctx context.Context) ([]bastionRecord, error) {
+		return queryResourceGraph[bastionRecord](ctx, cred, subscriptionIDs, bastionSearchQuery)
+	})
+}
+
+// getResourceProperties reads the properties of any resource by ID
+func getResourceProperties(ctx context.Context, cred azcore.TokenCredential, resourceID string, apiVersion string) (map[string]interface{}, error) {
+	id, err := arm.ParseResourceID(resourceID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource ID %s: %v", resourceID, err)
+	}
+
+	client, err := armresources.NewClient(id.SubscriptionID, cred, armClientOptions())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resources client: %v", err)
+	}
+
+	debugPrintf("Reading %s\n", resourceID)
+	resource, err := client.GetByID(ctx, resourceID, apiVersion, nil)
+	if err != nil {
+		return nil, err
+	}
+	props, _ := resource.Properties.(map[string]interface{})
+	return props, nil
+}
+
+// propertyValue walks nested JSON objects along path
+func propertyValue(value interface{}, path ...string) interface{} {
+	for _, key := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// propertyString returns the string at path, or "" if there is none
+func propertyString(value interface{}, path ...string) string {
+	s, _ := propertyValue(value, path...).(string)
+	return s
+}
+
+// propertyList returns the array at path, or nil if there is none
+func propertyList(value interface{}, path ...string) []interface{} {
+	list, _ := propertyValue(value, path...).([]interface{})
+	return list
+}
+
+// vnetFromSubnet returns the lower-cased virtual network ID of a subnet ID
+func vnetFromSubnet(subnetID string) string {
+	lower := strings.ToLower(subnetID)
+	if i := strings.Index(lower, "/subnets/"); i >= 0 {
+		return lower[:i]
+	}
+	return ""
+}
+
+// resourceNameFromID returns the last segment of a resource ID
+func resourceNameFromID(resourceID string) string {
+	return resourceID[strings.LastIndex(resourceID, "/")+1:]
+}
@@ -16,9 +16,11 @@ import (
 // TunnelInfo contains information about a tunnel connection
 type TunnelInfo struct {
 	ID                    string
+	ConfigName            string
 	LocalPort             int
 	RemotePort            int
 	ResourceID            string
+	TargetIP              string
 	ResourceName          string
 	SubscriptionID        string
 	BastionName           string
@@ -28,6 +30,10 @@ type TunnelInfo struct {
 	Status                string
 	cmd                   *exec.Cmd
 	PID                   int
+	// StopVMOnClose deallocates the target VM when the tunnel is stopped
+	StopVMOnClose bool
+	// TenantID is the tenant the VM is deallocated in
+	TenantID string
 }
 
 // TunnelManager manages tunnel connections
@@ -66,6 +72,10 @@ func (tm *TunnelManager) StopTunnel(id string) error {
 		return fmt.Errorf("failed to stop tunnel process: %v", err)
 	}
 
+	if tunnel.StopVMOnClose {
+		deallocateVM(tunnel.ResourceID, tunnel.ResourceName, tunnel.TenantID)
+	}
+
 	// Remove from in-memory state
 	delete(tm.tunnels, id)
 
@@ -87,6 +97,10 @@ func (tm *TunnelManager) StopAllTunnels() error {
 			continue
 		}
 
+		if tunnel.StopVMOnClose {
+			deallocateVM(tunnel.ResourceID, tunnel.ResourceName, tunnel.TenantID)
+		}
+
 		// Remove from persistent storage
 		if err := tm.configMgr.RemoveActive(id); err != nil {
 			lastErr = fmt.Errorf("failed to remove tunnel %s from storage: %v", id, err)
@@ -121,13 +135,15 @@ func (tm *TunnelManager) stopTunnelProcess(tunnel *TunnelInfo) error {
 }
 
 // StartTunnel starts a new tunnel connection
-func (tm *TunnelManager) StartTunnel(subscriptionID string, resourceID string, resourceName string, localPort int, remotePort int, bastionName string, bastionResourceGroup string, bastionSubscriptionID string) (*TunnelInfo, error) {
+func (tm *TunnelManager) StartTunnel(configName string, subscriptionID string, resourceID string, targetIP string, resourceName string, localPort int, remotePort int, bastionName string, bastionResourceGroup string, bastionSubscriptionID string) (*TunnelInfo, error) {
 	// Create a new tunnel info
 	tunnel := &TunnelInfo{
 		ID:                    uuid.New().String(),
+		ConfigName:            configName,
 		LocalPort:             localPort,
 		RemotePort:            remotePort,
 		ResourceID:            resourceID,
+		TargetIP:              targetIP,
 		ResourceName:          resourceName,
 		SubscriptionID:        subscriptionID,
 		BastionName:           bastionName,
@@ -137,14 +153,23 @@ func (tm *TunnelManager) StartTunnel(subscriptionID string, resourceID string, r
 		Status:                "starting",
 	}
 
+	// Target either a resource or, with IP-based connection, a private IP
+	targetArgs := []string{"--target-resource-id", resourceID}
+	if targetIP != "" {
+		targetArgs = []string{"--target-ip-address", targetIP}
+	}
+
 	// Prepare the Azure command
-	cmd := utils.PrepareAzureCommand("network", "bastion", "tunnel",
+	args := []string{"network", "bastion", "tunnel",
 		"--subscription", bastionSubscriptionID, // Use bastion's subscription ID
-		"--target-resource-id", resourceID,
+	}
+	args = append(args, targetArgs...)
+	args = append(args,
 		"--resource-port", fmt.Sprintf("%d", remotePort),
 		"--port", fmt.Sprintf("%d", localPort),
 		"--name", bastionName,
 		"--resource-group", bastionResourceGroup)
+	cmd := utils.PrepareAzureCommand(args...)
 
 	// Print command for debugging
 	// fmt.Printf("Starting tunnel with command: %s %v\n", cmd.Path, cmd.Args)
@@ -202,9 +227,11 @@ func (tm *TunnelManager) StartTunnel(subscriptionID string, resourceID string, r
 	// Save the tunnel configuration
 	activeTunnel := &tunnels.Active{
 		ID:                    tunnel.ID,
+		ConfigName:            configName,
 		LocalPort:             localPort,
 		RemotePort:            remotePort,
 		ResourceID:            resourceID,
+		TargetIP:              targetIP,
 		ResourceName:          resourceName,
 		SubscriptionID:        subscriptionID,
 		BastionName:           bastionName,
@@ -221,27 +248,26 @@ func (tm *TunnelManager) StartTunnel(subscriptionID string, resourceID string, r
 		return nil, fmt.Errorf("failed to save tunnel configuration: %v", err)
 	}
 
-	// Save the tunnel config for future use
-	tunnelConfig := &tunnels.Config{
-		Name:                  fmt.Sprintf("tunnel-%s", resourceName),
-		SubscriptionID:        subscriptionID,
-		ResourceID:            resourceID,
-		ResourceName:          resourceName,
-		LocalPort:             localPort,
-		RemotePort:            remotePort,
-		BastionName:           bastionName,
-		BastionResourceGroup:  bastionResourceGroup,
-		BastionSubscriptionID: bastionSubscriptionID,
-		LastUsed:              time.Now(),
-	}
-	if err := tm.configMgr.SaveConfig(*tunnelConfig); err != nil {
-		// Log the error but don't fail the tunnel creation
-		fmt.Printf("Warning: failed to save tunnel configuration: %v\n", err)
-	}
-
+	// The tunnel configuration itself is saved by the caller, so read-only
+	// team configurations are never copied into the user's store here
 	return tunnel, nil
 }
 
+// markStopVMOnClose records that the tunnel's target VM is deallocated when
+// the tunnel stops, along with the tenant to deallocate it in
+func (tm *TunnelManager) markStopVMOnClose(tunnel *TunnelInfo) error {
+	tunnel.StopVMOnClose = true
+	tunnel.TenantID = currentTenantID()
+	for _, active := range tm.configMgr.GetActive() {
+		if active.ID == tunnel.ID {
+			active.StopVMOnClose = true
+			active.TenantID = tunnel.TenantID
+			return tm.configMgr.SaveActive(active)
+		}
+	}
+	return nil
+}
+
 // PrintConnectionCommand prints the command to connect to a tunnel
 func (tm *TunnelManager) PrintConnectionCommand(tunnel *TunnelInfo) {
 	// For localhost tunnels, we want to skip host key checking since the key will change
@@ -21,6 +21,10 @@ func StartTunnel(resourceConfig *config.ResourceConfig, tunnelConfig *tunnels.Co
 			SubscriptionID:        resourceConfig.TargetResource.SubscriptionID,
 			ResourceID:            resourceConfig.TargetResource.ID,
 			ResourceName:          resourceConfig.TargetResource.Name,
+			TargetKind:            targetKind(resourceConfig.TargetResource),
+			TargetIP:              resourceConfig.TargetResource.IPAddress,
+			TenantID:              resourceConfig.TargetResource.TenantID,
+			Cloud:                 cloudSetting(),
 			LocalPort:             resourceConfig.LocalPort,
 			RemotePort:            resourceConfig.RemotePort,
 			BastionName:           resourceConfig.BastionHost.Name,
@@ -46,18 +50,51 @@ func StartTunnel(resourceConfig *config.ResourceConfig, tunnelConfig *tunnels.Co
 		return nil, fmt.Errorf("failed to save tunnel configuration: %v", err)
 	}
 
-	// Start the tunnel
-	tunnelInfo, err := manager.StartTunnel(
-		tunnelConfig.SubscriptionID,
-		tunnelConfig.ResourceID,
-		tunnelConfig.ResourceName,
-		tunnelConfig.LocalPort,
-		tunnelConfig.RemotePort,
-		tunnelConfig.BastionName,
-		tunnelConfig.BastionResourceGroup,
-		tunnelConfig.BastionSubscriptionID,
-	)
-	return tunnelInfo, err
+	// A scale set target connects to the instance it resolved to, while the
+	// saved configuration keeps pointing at the scale set
+	launchConfig := *tunnelConfig
+	stopVM := false
+	if resourceConfig != nil && resourceConfig.TargetResource != nil {
+		launchConfig.ResourceID = resourceConfig.TargetResource.ConnectID()
+		stopVM = resourceConfig.StopVMOnDisconnect
+	}
+	return launchTunnel(manager, &launchConfig, stopVM)
+}
+
+// launchTunnel starts the tunnel process for a fully resolved configuration.
+// With stopVM the target VM is deallocated when the tunnel is stopped.
+func launchTunnel(manager *TunnelManager, tunnelConfig *tunnels.Config, stopVM bool) (*TunnelInfo, error) {
+	if err := ensureAzLogin(tunnelConfig.TenantID); err != nil {
+		return nil, err
+	}
+
+	var tunnelInfo *TunnelInfo
+	err := runAzConnection(tunnelConfig.TenantID, func() error {
+		var err error
+		tunnelInfo, err = manager.StartTunnel(
+			tunnelConfig.Name,
+			tunnelConfig.SubscriptionID,
+			tunnelConfig.ResourceID,
+			tunnelConfig.TargetIP,
+			tunnelConfig.ResourceName,
+			tunnelConfig.LocalPort,
+			tunnelConfig.RemotePort,
+			tunnelConfig.BastionName,
+			tunnelConfig.BastionResourceGroup,
+			tunnelConfig.BastionSubscriptionID,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if stopVM {
+		if err := manager.markStopVMOnClose(tunnelInfo); err != nil {
+			fmt.Printf("Warning: failed to record VM shutdown for tunnel: %v\n", err)
+		}
+	}
+	return tunnelInfo, nil
 }
 
 // StartSavedTunnel starts a tunnel using a saved configuration
@@ -87,8 +124,20 @@ func StartSavedTunnel(tunnelName string) (*TunnelInfo, error) {
 		return nil, fmt.Errorf("tunnel configuration '%s' not found", tunnelName)
 	}
 
+	resolved, target, stopVM, err := prepareSavedConnection(tunnelConfig)
+	if err != nil {
+		return nil, err
+	}
+	resolved.ResourceID = target.ConnectID()
+
+	// Update the last used time
+	tunnelConfig.LastUsed = time.Now()
+	if err := manager.configMgr.SaveConfig(*tunnelConfig); err != nil {
+		return nil, fmt.Errorf("failed to update last used time: %v", err)
+	}
+
 	// Start the tunnel with the saved configuration
-	tunnelInfo, err := StartTunnel(nil, tunnelConfig)
+	tunnelInfo, err := launchTunnel(manager, &resolved, stopVM)
 	if err != nil {
 		return nil, err
 	}
@@ -96,6 +145,81 @@ func StartSavedTunnel(tunnelName string) (*TunnelInfo, error) {
 	return tunnelInfo, nil
 }
 
+// savedTarget returns the target resource of a saved configuration
+func savedTarget(saved tunnels.Config) *config.TargetResource {
+	target := &config.TargetResource{
+		ID:             saved.ResourceID,
+		Name:           saved.ResourceName,
+		SubscriptionID: saved.SubscriptionID,
+		TenantID:       saved.TenantID,
+	}
+	switch saved.TargetKind {
+	case config.TargetTypeIP:
+		target.Type = config.TargetTypeIP
+		target.IPAddress = saved.TargetIP
+	case config.TargetTypeScaleSet:
+		target.Type = config.TargetTypeScaleSet
+	}
+	return target
+}
+
+// targetKind returns the saved configuration target kind for a target resource
+func targetKind(target *config.TargetResource) string {
+	if target.IsIP() || target.IsScaleSet() {
+		return target.Type
+	}
+	return ""
+}
+
+// withVMStop runs an interactive connection and deallocates the target VM
+// afterwards if the resource configuration asks for it
+func withVMStop(resourceConfig *config.ResourceConfig, connect func() error) error {
+	err := connect()
+	if resourceConfig.StopVMOnDisconnect && resourceConfig.TargetResource != nil {
+		target := resourceConfig.TargetResource
+		deallocateVM(target.ConnectID(), resourceNameFromID(target.ConnectID()), currentTenantID())
+	}
+	return err
+}
+
+// prepareSavedConnection gets a saved configuration ready to connect: it
+// resolves its template variables, switches to the cloud and tenant it was
+// saved in, makes sure its subscription is accessible, checks its Bastion
+// host, starts the target VM and requests just-in-time access. It returns the
+// resolved configuration, its target and whether to stop the VM afterwards.
+// A PIM role it activates is remembered in saved, which the caller stores.
+func prepareSavedConnection(saved *tunnels.Config) (tunnels.Config, *config.TargetResource, bool, error) {
+	resolved, err := resolveSavedConfig(*saved)
+	if err != nil {
+		return tunnels.Config{}, nil, false, err
+	}
+	if err := useCloud(resolved.Cloud); err != nil {
+		return tunnels.Config{}, nil, false, err
+	}
+	useTenant(resolved.TenantID)
+	if err := ensureSavedSubscriptionAccess(saved, resolved); err != nil {
+		return tunnels.Config{}, nil, false, err
+	}
+	if err := checkSavedBastion(resolved); err != nil {
+		return tunnels.Config{}, nil, false, err
+	}
+
+	target := savedTarget(resolved)
+	stopVM, err := prepareTargetVM(target, resolved.StopVMOnDisconnect)
+	if err != nil {
+		return tunnels.Config{}, nil, false, err
+	}
+	if err := requestSavedJITAccess(resolved, target); err != nil {
+		return tunnels.Config{}, nil, false, err
+	}
+	return resolved, target, stopVM, nil
+}
+
+// resolveSavedConfig substitutes ${name} placeholders in a saved configuration
+func resolveSavedConfig(saved tunnels.Config) (tunnels.Config, error) {
+	return tunnels.Resolve(saved, config.LookupVariable)
+}
+
 // StartSavedSSH starts an SSH connection using a saved configuration
 func StartSavedSSH(configName string) error {
 	if err := ensureAuthenticated(); err != nil {
@@ -123,20 +247,24 @@ func StartSavedSSH(configName string) error {
 		return fmt.Errorf("SSH configuration '%s' not found", configName)
 	}
 
+	resolved, target, stopVM, err := prepareSavedConnection(savedConfig)
+	if err != nil {
+		return err
+	}
+
 	// Create resource config from saved config
 	resourceConfig := &config.ResourceConfig{
 		BastionHost: &config.BastionHost{
-			Name:           savedConfig.BastionName,
-			ResourceGroup:  savedConfig.BastionResourceGroup,
-			SubscriptionID: savedConfig.BastionSubscriptionID,
-		},
-		TargetResource: &config.TargetResource{
-			ID:   savedConfig.ResourceID,
-			Name: savedConfig.ResourceName,
+			Name:           resolved.BastionName,
+			ResourceGroup:  resolved.BastionResourceGroup,
+			SubscriptionID: resolved.BastionSubscriptionID,
+			TenantID:       resolved.TenantID,
 		},
-		Username:   savedConfig.Username,
-		LocalPort:  savedConfig.LocalPort,
-		RemotePort: savedConfig.RemotePort,
+		TargetResource:     target,
+		Username:           resolved.Username,
+		LocalPort:          resolved.LocalPort,
+		RemotePort:         resolved.RemotePort,
+		StopVMOnDisconnect: stopVM,
 	}
 
 	// Update the last used time
@@ -146,7 +274,9 @@ func StartSavedSSH(configName string) error {
 	}
 
 	// Connect using the saved configuration and auth type
-	return connectSSH(resourceConfig, savedConfig.AuthType)
+	return withVMStop(resourceConfig, func() error {
+		return connectSSH(resourceConfig, &resolved)
+	})
 }
 
 // StartSavedRDP starts an RDP connection using a saved configuration
@@ -176,20 +306,24 @@ func StartSavedRDP(configName string) error {
 		return fmt.Errorf("RDP configuration '%s' not found", configName)
 	}
 
+	resolved, target, stopVM, err := prepareSavedConnection(savedConfig)
+	if err != nil {
+		return err
+	}
+
 	// Create resource config from saved config
 	resourceConfig := &config.ResourceConfig{
 		BastionHost: &config.BastionHost{
-			Name:           savedConfig.BastionName,
-			ResourceGroup:  savedConfig.BastionResourceGroup,
-			SubscriptionID: savedConfig.BastionSubscriptionID,
-		},
-		TargetResource: &config.TargetResource{
-			ID:   savedConfig.ResourceID,
-			Name: savedConfig.ResourceName,
+			Name:           resolved.BastionName,
+			ResourceGroup:  resolved.BastionResourceGroup,
+			SubscriptionID: resolved.BastionSubscriptionID,
+			TenantID:       resolved.TenantID,
 		},
-		Username:   savedConfig.Username,
-		LocalPort:  savedConfig.LocalPort,
-		RemotePort: savedConfig.RemotePort,
+		TargetResource:     target,
+		Username:           resolved.Username,
+		LocalPort:          resolved.LocalPort,
+		RemotePort:         resolved.RemotePort,
+		StopVMOnDisconnect: stopVM,
 	}
 
 	// Update the last used time
@@ -198,24 +332,26 @@ func StartSavedRDP(configName string) error {
 		return fmt.Errorf("failed to update last used time: %v", err)
 	}
 
-	return connectRDP(resourceConfig, savedConfig)
+	return withVMStop(resourceConfig, func() error {
+		return connectRDP(resourceConfig, &resolved)
+	})
 }
 
-// ListConfigurations lists saved configurations, optionally filtered by type
-func ListConfigurations(connectionType string) error {
+// ListConfigurations lists saved configurations, optionally filtered by type,
+// tags and a search term
+func ListConfigurations(filter tunnels.Filter) error {
 	manager, err := GetTunnelManager()
 	if err != nil {
 		return fmt.Errorf("failed to get tunnel manager: %v", err)
 	}
 
-	var configs []tunnels.Config
-	if connectionType != "" {
-		configs = manager.GetSavedConfigsByType(connectionType)
-	} else {
-		configs = manager.GetSavedConfigs()
-	}
+	configs := manager.configMgr.FilterConfigs(filter)
 
 	if len(configs) == 0 {
+		if !filter.IsEmpty() {
+			fmt.Println("No saved configurations match the filter")
+			return nil
+		}
 		fmt.Println("No saved configurations found")
 		return nil
 	}
@@ -237,7 +373,7 @@ func ListConfigurations(connectionType string) error {
 	}
 
 	// Print configurations by type
-	if len(tunnelConfigs) > 0 && (connectionType == "" || connectionType == "tunnel") {
+	if len(tunnelConfigs) > 0 {
 		fmt.Println("\nTunnel Configurations:")
 		fmt.Println("---------------------")
 		for _, config := range tunnelConfigs {
@@ -245,11 +381,13 @@ func ListConfigurations(connectionType string) error {
 			fmt.Printf("  Resource: %s\n", config.ResourceName)
 			fmt.Printf("  Ports: local=%d, remote=%d\n", config.LocalPort, config.RemotePort)
 			fmt.Printf("  Last Used: %s\n", config.LastUsed.Format("2006-01-02 15:04:05"))
+			printConfigMetadata(config)
+			fmt.Printf("  Source: %s\n", describeSource(config))
 			fmt.Println()
 		}
 	}
 
-	if len(sshConfigs) > 0 && (connectionType == "" || connectionType == "ssh") {
+	if len(sshConfigs) > 0 {
 		fmt.Println("\nSSH Configurations:")
 		fmt.Println("-----------------")
 		for _, config := range sshConfigs {
@@ -257,11 +395,13 @@ func ListConfigurations(connectionType string) error {
 			fmt.Printf("  Resource: %s\n", config.ResourceName)
 			fmt.Printf("  Username: %s\n", config.Username)
 			fmt.Printf("  Last Used: %s\n", config.LastUsed.Format("2006-01-02 15:04:05"))
+			printConfigMetadata(config)
+			fmt.Printf("  Source: %s\n", describeSource(config))
 			fmt.Println()
 		}
 	}
 
-	if len(rdpConfigs) > 0 && (connectionType == "" || connectionType == "rdp") {
+	if len(rdpConfigs) > 0 {
 		fmt.Println("\nRDP Configurations:")
 		fmt.Println("-----------------")
 		for _, config := range rdpConfigs {
@@ -269,6 +409,8 @@ func ListConfigurations(connectionType string) error {
 			fmt.Printf("  Resource: %s\n", config.ResourceName)
 			fmt.Printf("  Username: %s\n", config.Username)
 			fmt.Printf("  Last Used: %s\n", config.LastUsed.Format("2006-01-02 15:04:05"))
+			printConfigMetadata(config)
+			fmt.Printf("  Source: %s\n", describeSource(config))
 			fmt.Println()
 		}
 	}
@@ -276,6 +418,36 @@ func ListConfigurations(connectionType string) error {
 	return nil
 }
 
+// printConfigMetadata prints the description and tags of a configuration, if any
+func printConfigMetadata(config tunnels.Config) {
+	if config.Description != "" {
+		fmt.Printf("  Description: %s\n", config.Description)
+	}
+	if len(config.Tags) > 0 {
+		fmt.Printf("  Tags: %s\n", tunnels.FormatTags(config.Tags))
+	}
+	if credentials := describeSecret(config); credentials != "" {
+		fmt.Printf("  Credentials: %s\n", credentials)
+	}
+	if config.JIT != nil {
+		fmt.Printf("  JIT access: %s\n", describeJIT(config))
+	}
+	if config.PIMRole != nil {
+		fmt.Printf("  PIM role: %s\n", config.PIMRole.Role)
+	}
+}
+
+// describeSource returns a short description of where a configuration came from
+func describeSource(config tunnels.Config) string {
+	if config.ReadOnly {
+		return fmt.Sprintf("team (%s, read-only)", config.Source)
+	}
+	if config.Source == "" {
+		return "user"
+	}
+	return fmt.Sprintf("user (%s)", config.Source)
+}
+
 // RunTunnelAction executes the specified tunnel action
 func RunTunnelAction(_ *config.ResourceConfig, tunnelID string, action string) error {
 	if err := ensureAuthenticated(); err != nil {
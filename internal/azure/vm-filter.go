@@ -0,0 +1,304 @@
+package azure
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/antnsn/BastionBuddy/internal/tunnels"
+	"github.com/antnsn/BastionBuddy/internal/utils"
+)
+
+// VMFilter narrows the virtual machines offered by the VM pickers
+type VMFilter struct {
+	ResourceGroup string
+	// Tags maps tag keys to values; an empty value matches any value
+	Tags       map[string]string
+	OSType     string
+	PowerState string
+	// GroupByResourceGroup asks for the resource group before the VM
+	GroupByResourceGroup bool
+}
+
+// vmFilter is the filter the pickers start with, set from the command line
+var vmFilter VMFilter
+
+// SetVMFilter sets the filter the VM pickers start with
+func SetVMFilter(filter VMFilter) {
+	vmFilter = filter
+}
+
+// IsEmpty reports whether the filter matches every virtual machine
+func (f VMFilter) IsEmpty() bool {
+	return f.ResourceGroup == "" && len(f.Tags) == 0 && f.OSType == "" && f.PowerState == ""
+}
+
+// String describes the active filter for the picker prompt
+func (f VMFilter) String() string {
+	var parts []string
+	if f.ResourceGroup != "" {
+		parts = append(parts, "group "+f.ResourceGroup)
+	}
+	keys := make([]string, 0, len(f.Tags))
+	for key := range f.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value := f.Tags[key]; value != "" {
+			parts = append(parts, fmt.Sprintf("tag %s=%s", key, value))
+		} else {
+			parts = append(parts, "tag "+key)
+		}
+	}
+	if f.OSType != "" {
+		parts = append(parts, f.OSType)
+	}
+	if f.PowerState != "" {
+		parts = append(parts, f.PowerState)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// matches reports whether a VM satisfies the filter. Tag keys are matched
+// without regard to case, as Azure does.
+func (f VMFilter) matches(vm vmEntry) bool {
+	if f.ResourceGroup != "" && !strings.EqualFold(vm.ResourceGroup, f.ResourceGroup) {
+		return false
+	}
+	if f.OSType != "" && !strings.EqualFold(vm.OSType, f.OSType) {
+		return false
+	}
+	if f.PowerState != "" && !strings.EqualFold(powerStateName(vm.PowerState), f.PowerState) {
+		return false
+	}
+	for key, value := range f.Tags {
+		found := false
+		for actualKey, actual := range vm.Tags {
+			if strings.EqualFold(actualKey, key) && (value == "" || strings.EqualFold(actual, value)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// vmEntry is a virtual machine or scale set offered by a VM picker
+type vmEntry struct {
+	ID             string
+	Name           string
+	ResourceGroup  string
+	SubscriptionID string
+	TenantID       string
+	OSType         string
+	PowerState     string
+	Tags           map[string]string
+	// label is the text shown in the picker
+	label string
+	// scaleSet marks a scale set, whose instances are picked next
+	scaleSet bool
+}
+
+// Picker entries that change the filter instead of selecting a VM
+const (
+	filterByGroup   = "[Filter by resource group]"
+	filterByTag     = "[Filter by tag]"
+	filterByOS      = "[Filter by OS type]"
+	filterByState   = "[Filter by power state]"
+	toggleGrouping  = "[Group by resource group]"
+	stopGrouping    = "[Don't group by resource group]"
+	clearFilters    = "[Clear filters]"
+	allGroupsOption = "[All resource groups]"
+)
+
+// selectVM lets the user pick one of vms, narrowed by the current filter.
+// The filter and grouping can be changed from the picker; the changes last
+// for the rest of the session.
+func selectVM(vms []vmEntry, prompt string) (*vmEntry, error) {
+	for {
+		var shown []vmEntry
+		for _, vm := range vms {
+			if vmFilter.matches(vm) {
+				shown = append(shown, vm)
+			}
+		}
+
+		label := fmt.Sprintf("%s (%d of %d shown, type to filter)", prompt, len(shown), len(vms))
+		if !vmFilter.IsEmpty() {
+			label = fmt.Sprintf("%s (%s: %d of %d shown, type to filter)", prompt, vmFilter, len(shown), len(vms))
+		}
+
+		if vmFilter.GroupByResourceGroup {
+			group, err := selectVMResourceGroup(shown, vms, label)
+			if err != nil {
+				return nil, err
+			}
+			if group == "" {
+				continue
+			}
+			if group != allGroupsOption {
+				var inGroup []vmEntry
+				for _, vm := range shown {
+					if vm.ResourceGroup == group {
+						inGroup = append(inGroup, vm)
+					}
+				}
+				shown = inGroup
+				label = fmt.Sprintf("%s in %s", prompt, group)
+			}
+		}
+
+		var items []string
+		vmMap := make(map[string]vmEntry)
+		for _, vm := range shown {
+			items = append(items, vm.label)
+			vmMap[vm.label] = vm
+		}
+		items = append(items, filterOptions()...)
+
+		selected, err := utils.SelectWithMenu(items, label)
+		if err != nil {
+			if strings.Contains(err.Error(), "cancelled by user") {
+				fmt.Println("\nOperation cancelled by user")
+				os.Exit(0)
+			}
+			return nil, fmt.Errorf("failed to select resource: %v", err)
+		}
+
+		if vm, ok := vmMap[selected]; ok {
+			return &vm, nil
+		}
+		if err := changeVMFilter(selected, vms); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// selectVMResourceGroup asks for the resource group of the shown VMs to pick
+// a VM from. It returns "" when the filter was changed instead.
+func selectVMResourceGroup(vms []vmEntry, all []vmEntry, label string) (string, error) {
+	counts := make(map[string]int)
+	var groups []string
+	for _, vm := range vms {
+		if counts[vm.ResourceGroup] == 0 {
+			groups = append(groups, vm.ResourceGroup)
+		}
+		counts[vm.ResourceGroup]++
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return strings.ToLower(groups[i]) < strings.ToLower(groups[j])
+	})
+
+	items := []string{allGroupsOption}
+	groupMap := make(map[string]string)
+	for _, group := range groups {
+		item := fmt.Sprintf("%s (%d)", group, counts[group])
+		items = append(items, item)
+		groupMap[item] = group
+	}
+	items = append(items, filterOptions()...)
+
+	selected, err := utils.SelectWithMenu(items, label)
+	if err != nil {
+		if strings.Contains(err.Error(), "cancelled by user") {
+			fmt.Println("\nOperation cancelled by user")
+			os.Exit(0)
+		}
+		return "", fmt.Errorf("failed to select resource group: %v", err)
+	}
+
+	if selected == allGroupsOption {
+		return selected, nil
+	}
+	if group, ok := groupMap[selected]; ok {
+		return group, nil
+	}
+	return "", changeVMFilter(selected, all)
+}
+
+// filterOptions returns the picker entries that change the filter
+func filterOptions() []string {
+	options := []string{filterByGroup, filterByTag, filterByOS, filterByState}
+	if vmFilter.GroupByResourceGroup {
+		options = append(options, stopGrouping)
+	} else {
+		options = append(options, toggleGrouping)
+	}
+	if !vmFilter.IsEmpty() {
+		options = append(options, clearFilters)
+	}
+	return options
+}
+
+// changeVMFilter applies a filter option chosen in a picker. The choices
+// offered come from all VMs, not just the ones the filter currently shows.
+func changeVMFilter(option string, vms []vmEntry) error {
+	var err error
+	switch option {
+	case filterByGroup:
+		vmFilter.ResourceGroup, err = selectFilterValue(vms, "resource group", func(vm vmEntry) string { return vm.ResourceGroup })
+	case filterByOS:
+		vmFilter.OSType, err = selectFilterValue(vms, "OS type", func(vm vmEntry) string { return vm.OSType })
+	case filterByState:
+		vmFilter.PowerState, err = selectFilterValue(vms, "power state", func(vm vmEntry) string { return powerStateName(vm.PowerState) })
+	case filterByTag:
+		var input string
+		input, err = utils.ReadInput("Enter tag as key=value or key (empty to clear tag filters)")
+		if err != nil {
+			break
+		}
+		if strings.TrimSpace(input) == "" {
+			vmFilter.Tags = nil
+			break
+		}
+		key, value, parseErr := tunnels.ParseTag(input)
+		if parseErr != nil {
+			fmt.Printf("Error: %v\n", parseErr)
+			break
+		}
+		if vmFilter.Tags == nil {
+			vmFilter.Tags = make(map[string]string)
+		}
+		vmFilter.Tags[key] = value
+	case toggleGrouping, stopGrouping:
+		vmFilter.GroupByResourceGroup = !vmFilter.GroupByResourceGroup
+	case clearFilters:
+		vmFilter = VMFilter{GroupByResourceGroup: vmFilter.GroupByResourceGroup}
+	}
+
+	if err != nil {
+		if strings.Contains(err.Error(), "cancelled by user") {
+			fmt.Println("\nOperation cancelled by user")
+			os.Exit(0)
+		}
+		return fmt.Errorf("failed to change filter: %v", err)
+	}
+	return nil
+}
+
+// selectFilterValue offers the distinct values of a VM field, or any value
+func selectFilterValue(vms []vmEntry, name string, field func(vmEntry) string) (string, error) {
+	const anyValue = "(any)"
+	seen := make(map[string]bool)
+	var values []string
+	for _, vm := range vms {
+		value := field(vm)
+		if value == "" || seen[strings.ToLower(value)] {
+			continue
+		}
+		seen[strings.ToLower(value)] = true
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	selected, err := utils.SelectWithMenu(append([]string{anyValue}, values...), fmt.Sprintf("Filter by %s", name))
+	if err != nil || selected == anyValue {
+		return "", err
+	}
+	return selected, nil
+}
@@ -0,0 +1,217 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/antnsn/BastionBuddy/internal/config"
+	"github.com/antnsn/BastionBuddy/internal/utils"
+)
+
+const (
+	// vmStartTimeout bounds how long to wait for a started VM to be running
+	vmStartTimeout = 10 * time.Minute
+	// vmPollInterval is how often the power state is checked while starting
+	vmPollInterval = 5 * time.Second
+)
+
+// vmStateQuery returns the power state and OS type of every VM in the queried subscriptions
+const vmStateQuery = `Resources
+| where type =~ 'microsoft.compute/virtualmachines'
+| project id = tolower(id), powerState = tostring(properties.extended.instanceView.powerState.code),
+	osType = tostring(properties.storageProfile.osDisk.osType)`
+
+// isVirtualMachineID reports whether a resource ID refers to a virtual machine
+// or a scale set instance, which share the power operations
+func isVirtualMachineID(resourceID string) bool {
+	return strings.Contains(strings.ToLower(resourceID), "/virtualmachines/")
+}
+
+// powerStateName turns "PowerState/deallocated" or "VM deallocated" into "deallocated"
+func powerStateName(state string) string {
+	state = strings.TrimPrefix(state, "PowerState/")
+	return strings.TrimPrefix(state, "VM ")
+}
+
+// vmState is the live state of a VM shown in the pickers
+type vmState struct {
+	PowerState string
+	OSType     string
+}
+
+// subscriptionVMStates returns the power state and OS type of each VM in a
+// subscription keyed by lower-cased resource ID. Power states change too
+// often to cache.
+func subscriptionVMStates(ctx context.Context, cred azcore.TokenCredential, subscriptionID string) (map[string]vmState, error) {
+	type row struct {
+		ID         string `json:"id"`
+		PowerState string `json:"powerState"`
+		OSType     string `json:"osType"`
+	}
+	rows, err := queryResourceGraph[row](ctx, cred, []string{subscriptionID}, vmStateQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]vmState, len(rows))
+	for _, r := range rows {
+		states[r.ID] = vmState{PowerState: powerStateName(r.PowerState), OSType: r.OSType}
+	}
+	return states, nil
+}
+
+// vmPowerState reads the current power state of a VM from its instance view
+func vmPowerState(ctx context.Context, cred azcore.TokenCredential, vmID string) (string, error) {
+	var instanceView struct {
+		Statuses []struct {
+			Code string `json:"code"`
+		} `json:"statuses"`
+	}
+	path := fmt.Sprintf("%s/instanceView?api-version=%s", vmID, computeAPIVersion)
+	if err := armRequest(ctx, cred, http.MethodGet, path, nil, &instanceView); err != nil {
+		return "", err
+	}
+
+	for _, status := range instanceView.Statuses {
+		if strings.HasPrefix(status.Code, "PowerState/") {
+			return powerStateName(status.Code), nil
+		}
+	}
+	return "unknown", nil
+}
+
+// vmAction runs a power action such as start or deallocate on a VM
+func vmAction(ctx context.Context, cred azcore.TokenCredential, vmID string, action string) error {
+	path := fmt.Sprintf("%s/%s?api-version=%s", vmID, action, computeAPIVersion)
+	return armRequest(ctx, cred, http.MethodPost, path, nil, nil)
+}
+
+// prepareTargetVM checks the target VM's power state before connecting and,
+// if it isn't running, offers to start it and waits until it is. It returns
+// whether the VM should be deallocated again when the connection ends.
+// stopOnDisconnect comes from the saved configuration.
+func prepareTargetVM(target *config.TargetResource, stopOnDisconnect bool) (bool, error) {
+	if target == nil {
+		return false, nil
+	}
+
+	cred, err := GetAzureCredential()
+	if err != nil {
+		return false, fmt.Errorf("failed to get Azure credentials: %v", err)
+	}
+
+	ctx := context.Background()
+	if target.IsScaleSet() {
+		if err := resolveScaleSetTarget(ctx, cred, target); err != nil {
+			return false, err
+		}
+	}
+
+	vmID := target.ConnectID()
+	if !isVirtualMachineID(vmID) {
+		return false, nil
+	}
+
+	state, err := vmPowerState(ctx, cred, vmID)
+	if err != nil {
+		debugPrintf("Could not read power state of %s: %v\n", target.Name, err)
+		return false, nil
+	}
+	if state == "running" || state == "starting" {
+		return false, nil
+	}
+
+	const (
+		startVM        = "Start the VM and connect"
+		startAndStopVM = "Start the VM and deallocate it again when I disconnect"
+		connectAnyway  = "Connect anyway"
+	)
+	items := []string{startVM, startAndStopVM, connectAnyway}
+	if stopOnDisconnect {
+		items = []string{startAndStopVM, startVM, connectAnyway}
+	}
+
+	name := target.Name
+	if name == "" || target.IsScaleSet() {
+		name = resourceNameFromID(vmID)
+	}
+	selected, err := utils.SelectWithMenu(items, fmt.Sprintf("%s is %s", name, state))
+	if err != nil {
+		if strings.Contains(err.Error(), "cancelled by user") {
+			fmt.Println("\nOperation cancelled by user")
+			os.Exit(0)
+		}
+		return false, err
+	}
+	if selected == connectAnyway {
+		return false, nil
+	}
+
+	if err := vmAction(ctx, cred, vmID, "start"); err != nil {
+		return false, fmt.Errorf("failed to start %s: %v", name, err)
+	}
+	if err := waitForVMRunning(ctx, cred, vmID, name); err != nil {
+		return false, err
+	}
+	return selected == startAndStopVM, nil
+}
+
+// waitForVMRunning polls the power state with a progress indicator until the VM runs
+func waitForVMRunning(ctx context.Context, cred azcore.TokenCredential, vmID string, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, vmStartTimeout)
+	defer cancel()
+
+	frames := []string{"|", "/", "-", "\\"}
+	start := time.Now()
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	state := "starting"
+	lastPoll := time.Time{}
+	for frame := 0; ; frame++ {
+		if time.Since(lastPoll) >= vmPollInterval {
+			lastPoll = time.Now()
+			current, err := vmPowerState(ctx, cred, vmID)
+			if err != nil {
+				debugPrintf("\nFailed to read power state: %v\n", err)
+			} else {
+				state = current
+			}
+			if state == "running" {
+				fmt.Printf("\r%s is running (%s)          \n", name, time.Since(start).Round(time.Second))
+				return nil
+			}
+		}
+
+		fmt.Printf("\r%s Starting %s: %s (%s)   ", frames[frame%len(frames)], name, state, time.Since(start).Round(time.Second))
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return fmt.Errorf("%s did not reach the running state within %s", name, vmStartTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// deallocateVM deallocates a VM that was started for a connection in the
+// given tenant, without waiting for the operation to finish
+func deallocateVM(vmID string, name string, tenantID string) {
+	cred, err := credentialForTenant(tenantID)
+	if err != nil {
+		fmt.Printf("Warning: failed to deallocate %s: %v\n", name, err)
+		return
+	}
+	if name == "" {
+		name = resourceNameFromID(vmID)
+	}
+
+	fmt.Printf("Deallocating %s...\n", name)
+	if err := vmAction(context.Background(), cred, vmID, "deallocate"); err != nil {
+		fmt.Printf("Warning: failed to deallocate %s: %v\n", name, err)
+	}
+}
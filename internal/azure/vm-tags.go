@@ -0,0 +1,160 @@
+package azure
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/antnsn/BastionBuddy/internal/config"
+	"github.com/antnsn/BastionBuddy/internal/utils"
+)
+
+// Tags that set connection defaults on a VM or scale set
+const (
+	usernameTag = "bastionbuddy-username"
+	portsTag    = "bastionbuddy-ports"
+	bastionTag  = "bastionbuddy-bastion"
+	authTag     = "bastionbuddy-auth"
+)
+
+// targetDefaultsFromTags reads the bastionbuddy-* tags of a VM. Tag keys are
+// matched without regard to case. Invalid values are reported and ignored.
+func targetDefaultsFromTags(name string, tags map[string]string) config.TargetDefaults {
+	var defaults config.TargetDefaults
+	for key, value := range tags {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case usernameTag:
+			defaults.Username = value
+		case portsTag:
+			for _, field := range strings.Split(value, ",") {
+				port, err := strconv.Atoi(strings.TrimSpace(field))
+				if err != nil || port < 1 || port > 65535 {
+					fmt.Printf("Warning: ignoring invalid port %q in the %s tag of %s\n", field, portsTag, name)
+					continue
+				}
+				defaults.Ports = append(defaults.Ports, port)
+			}
+		case bastionTag:
+			if _, err := parseResourceIDOfType(value, bastionHostType); err != nil {
+				fmt.Printf("Warning: ignoring the %s tag of %s: %v\n", bastionTag, name, err)
+				continue
+			}
+			defaults.BastionID = value
+		case authTag:
+			switch strings.ToLower(value) {
+			case "aad":
+				defaults.AuthType = "AAD"
+			case "password":
+				defaults.AuthType = "password"
+			default:
+				fmt.Printf("Warning: ignoring the %s tag of %s: unknown auth type %q\n", authTag, name, value)
+			}
+		}
+	}
+	return defaults
+}
+
+// selectTaggedBastion offers the Bastion host named by the target's
+// bastionbuddy-bastion tag. It returns nil without an error when there is no
+// such tag, the host can't serve the connection, or the user wants another.
+func selectTaggedBastion(target *config.TargetResource, connectionType ConnectionType) (*config.BastionHost, error) {
+	if target == nil || target.Defaults.BastionID == "" {
+		return nil, nil
+	}
+
+	id, err := parseResourceIDOfType(target.Defaults.BastionID, bastionHostType)
+	if err != nil {
+		return nil, nil
+	}
+	host := &config.BastionHost{
+		Name:           id.Name,
+		ResourceGroup:  id.ResourceGroup,
+		SubscriptionID: id.SubscriptionID,
+	}
+	if err := checkBastion(host, connectionType, target.IsIP()); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return nil, nil
+	}
+
+	useTagged := fmt.Sprintf("%s (%s) - set by the %s tag", host.Name, host.ResourceGroup, bastionTag)
+	const otherHost = "Choose another Bastion host"
+	selected, err := utils.SelectWithMenu([]string{useTagged, otherHost}, fmt.Sprintf("Select Bastion host for %s", target.Name))
+	if err != nil {
+		if strings.Contains(err.Error(), "cancelled by user") {
+			fmt.Println("\nOperation cancelled by user")
+			os.Exit(0)
+		}
+		return nil, fmt.Errorf("failed to select Bastion host: %v", err)
+	}
+	if selected == otherHost {
+		return nil, nil
+	}
+	return host, nil
+}
+
+// readUsername prompts for a username, offering the target's tagged default
+func readUsername(prompt string, target *config.TargetResource) (string, error) {
+	if target == nil || target.Defaults.Username == "" {
+		return utils.ReadInput(prompt)
+	}
+
+	username, err := utils.ReadInput(fmt.Sprintf("%s (default: %s)", prompt, target.Defaults.Username))
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(username) == "" {
+		return target.Defaults.Username, nil
+	}
+	return username, nil
+}
+
+// selectRemotePort asks for the port to tunnel to. The ports in the target's
+// bastionbuddy-ports tag are offered first; otherwise the port suggested by
+// its OS type is the default.
+func selectRemotePort(target *config.TargetResource) (int, error) {
+	if target != nil && len(target.Defaults.Ports) > 0 {
+		const otherPort = "Enter another port"
+		var items []string
+		for _, port := range target.Defaults.Ports {
+			items = append(items, strconv.Itoa(port))
+		}
+		items = append(items, otherPort)
+
+		selected, err := utils.SelectWithMenu(items, fmt.Sprintf("Select target port for %s", target.Name))
+		if err != nil {
+			return 0, err
+		}
+		if selected != otherPort {
+			return strconv.Atoi(selected)
+		}
+	}
+
+	defaultPort := suggestedRemotePort(target)
+	if defaultPort > 0 {
+		return utils.GetUserInputIntWithDefault(fmt.Sprintf("Enter target resource port (default: %d)", defaultPort), defaultPort)
+	}
+	return utils.GetUserInputInt("Enter target resource port (e.g., 22 for SSH, 3389 for RDP, 80 for HTTP, 443 for HTTPS)")
+}
+
+// authTypeItems returns the SSH authentication types with the target's
+// tagged default first
+func authTypeItems(target *config.TargetResource) []string {
+	items := []string{"AAD", "password"}
+	if target == nil || target.Defaults.AuthType == "" {
+		return items
+	}
+
+	ordered := []string{target.Defaults.AuthType}
+	for _, item := range items {
+		if item != target.Defaults.AuthType {
+			ordered = append(ordered, item)
+		}
+	}
+	return ordered
+}
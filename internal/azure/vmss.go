@@ -0,0 +1,192 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/antnsn/BastionBuddy/internal/config"
+	"github.com/antnsn/BastionBuddy/internal/utils"
+)
+
+// flexibleInstancesQuery lists the VMs of a Flexible scale set. The scale set
+// ID is filled in with fmt.
+const flexibleInstancesQuery = `Resources
+| where type =~ 'microsoft.compute/virtualmachines'
+| where tolower(tostring(properties.virtualMachineScaleSet.id)) == '%s'
+| project id, name,
+	powerState = tostring(properties.extended.instanceView.powerState.code),
+	provisioningState = tostring(properties.provisioningState)
+| order by name asc`
+
+// scaleSetInstance is an instance of a virtual machine scale set
+type scaleSetInstance struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	PowerState        string `json:"powerState"`
+	ProvisioningState string `json:"provisioningState"`
+	// Health is the application health reported by the health extension, if any
+	Health string `json:"health,omitempty"`
+}
+
+// healthy reports whether the instance is running, provisioned and not
+// reported unhealthy by the application health extension
+func (i scaleSetInstance) healthy() bool {
+	return i.PowerState == "running" &&
+		strings.EqualFold(i.ProvisioningState, "Succeeded") &&
+		(i.Health == "" || i.Health == "healthy")
+}
+
+// label renders an instance for the picker
+func (i scaleSetInstance) label() string {
+	label := fmt.Sprintf("%s | %s", i.Name, i.PowerState)
+	if i.Health != "" {
+		label += " | " + i.Health
+	}
+	return label
+}
+
+// listScaleSetInstances returns the instances of a Uniform or Flexible scale set
+func listScaleSetInstances(ctx context.Context, cred azcore.TokenCredential, scaleSetID string) ([]scaleSetInstance, error) {
+	props, err := getResourceProperties(ctx, cred, scaleSetID, computeAPIVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scale set: %v", err)
+	}
+
+	if strings.EqualFold(propertyString(props, "orchestrationMode"), "Flexible") {
+		query := fmt.Sprintf(flexibleInstancesQuery, strings.ToLower(scaleSetID))
+		instances, err := queryResourceGraph[scaleSetInstance](ctx, cred, []string{subscriptionFromID(scaleSetID)}, query)
+		if err != nil {
+			return nil, err
+		}
+		for i := range instances {
+			instances[i].PowerState = powerStateName(instances[i].PowerState)
+		}
+		return instances, nil
+	}
+
+	// Uniform instances live under the scale set and report their health in the instance view
+	type uniformInstance struct {
+		ID         string `json:"id"`
+		Name       string `json:"name"`
+		Properties struct {
+			ProvisioningState string `json:"provisioningState"`
+			InstanceView      struct {
+				Statuses []struct {
+					Code string `json:"code"`
+				} `json:"statuses"`
+				VMHealth struct {
+					Status struct {
+						Code string `json:"code"`
+					} `json:"status"`
+				} `json:"vmHealth"`
+			} `json:"instanceView"`
+		} `json:"properties"`
+	}
+	path := fmt.Sprintf("%s/virtualMachines?$expand=instanceView&api-version=%s", scaleSetID, computeAPIVersion)
+	rows, err := armList[uniformInstance](ctx, cred, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scale set instances: %v", err)
+	}
+
+	instances := make([]scaleSetInstance, 0, len(rows))
+	for _, row := range rows {
+		instance := scaleSetInstance{
+			ID:                row.ID,
+			Name:              row.Name,
+			PowerState:        "unknown",
+			ProvisioningState: row.Properties.ProvisioningState,
+		}
+		for _, status := range row.Properties.InstanceView.Statuses {
+			if strings.HasPrefix(status.Code, "PowerState/") {
+				instance.PowerState = powerStateName(status.Code)
+			}
+		}
+		if code := row.Properties.InstanceView.VMHealth.Status.Code; code != "" {
+			instance.Health = strings.ToLower(strings.TrimPrefix(code, "HealthState/"))
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// resolveScaleSetTarget picks a healthy instance for an "any healthy
+// instance" target
+func resolveScaleSetTarget(ctx context.Context, cred azcore.TokenCredential, target *config.TargetResource) error {
+	if target.InstanceID != "" {
+		return nil
+	}
+
+	instances, err := listScaleSetInstances(ctx, cred, target.ID)
+	if err != nil {
+		return err
+	}
+
+	var healthy []scaleSetInstance
+	for _, instance := range instances {
+		if instance.healthy() {
+			healthy = append(healthy, instance)
+		}
+	}
+	if len(healthy) == 0 {
+		return fmt.Errorf("no healthy instance in scale set %s (%d instance(s))", resourceNameFromID(target.ID), len(instances))
+	}
+
+	// Spread connections over the healthy instances
+	instance := healthy[rand.Intn(len(healthy))]
+	target.InstanceID = instance.ID
+	fmt.Printf("Using instance %s of scale set %s\n", instance.Name, resourceNameFromID(target.ID))
+	return nil
+}
+
+// selectScaleSetInstance lets the user pick an instance of a scale set, or
+// any healthy instance resolved at connect time
+func selectScaleSetInstance(ctx context.Context, cred azcore.TokenCredential, scaleSetID string, subscriptionID string) (*config.TargetResource, error) {
+	scaleSetName := resourceNameFromID(scaleSetID)
+	instances, err := listScaleSetInstances(ctx, cred, scaleSetID)
+	if err != nil {
+		return nil, err
+	}
+
+	const anyInstance = "Any healthy instance (chosen when connecting)"
+	items := []string{anyInstance}
+	instanceMap := make(map[string]scaleSetInstance)
+	for _, instance := range instances {
+		item := instance.label()
+		items = append(items, item)
+		instanceMap[item] = instance
+	}
+
+	selected, err := utils.SelectWithMenu(items, fmt.Sprintf("Select instance of %s", scaleSetName))
+	if err != nil {
+		if strings.Contains(err.Error(), "cancelled by user") {
+			fmt.Println("\nOperation cancelled by user")
+			os.Exit(0)
+		}
+		return nil, fmt.Errorf("failed to select instance: %v", err)
+	}
+
+	if selected == anyInstance {
+		return &config.TargetResource{
+			ID:             scaleSetID,
+			Name:           scaleSetName,
+			Type:           config.TargetTypeScaleSet,
+			SubscriptionID: subscriptionID,
+		}, nil
+	}
+
+	instance := instanceMap[selected]
+	instanceType := virtualMachineType
+	if strings.Contains(strings.ToLower(instance.ID), "/virtualmachinescalesets/") {
+		instanceType = scaleSetVMType
+	}
+	return &config.TargetResource{
+		ID:             instance.ID,
+		Name:           instance.Name,
+		Type:           instanceType,
+		SubscriptionID: subscriptionID,
+	}, nil
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const (
+	// ConfigDirEnv is the environment variable overriding the configuration directory
+	ConfigDirEnv = "BASTIONBUDDY_CONFIG_DIR"
+	// StateDirEnv is the environment variable overriding the state directory
+	StateDirEnv = "BASTIONBUDDY_STATE_DIR"
+)
+
+var configDirOverride string
+
+// SetConfigDir overrides the configuration directory, taking precedence over
+// BASTIONBUDDY_CONFIG_DIR and the XDG base directories
+func SetConfigDir(dir string) {
+	configDirOverride = dir
+}
+
+// BaseDir returns the root BastionBuddy configuration directory
+func BaseDir() (string, error) {
+	dir, _, err := resolveBaseDir()
+	return dir, err
+}
+
+// BaseDirSource describes how the configuration directory was resolved
+func BaseDirSource() string {
+	_, source, _ := resolveBaseDir()
+	return source
+}
+
+// resolveBaseDir resolves the configuration directory and where it came from
+func resolveBaseDir() (string, string, error) {
+	if configDirOverride != "" {
+		return configDirOverride, "--config-dir", nil
+	}
+	if dir := os.Getenv(ConfigDirEnv); dir != "" {
+		return dir, ConfigDirEnv, nil
+	}
+	if runtime.GOOS == "linux" {
+		if dir := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(dir) {
+			return filepath.Join(dir, "bastionbuddy"), "XDG_CONFIG_HOME", nil
+		}
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get user home directory: %v", err)
+	}
+	return filepath.Join(homeDir, ".config", "bastionbuddy"), "default", nil
+}
+
+// BaseStateDir returns the root directory for runtime state such as active tunnels.
+// On Linux this follows XDG_STATE_HOME; elsewhere, and whenever the
+// configuration directory is set explicitly, state lives alongside the configuration.
+func BaseStateDir() (string, error) {
+	if dir := os.Getenv(StateDirEnv); dir != "" {
+		return dir, nil
+	}
+
+	baseDir, source, err := resolveBaseDir()
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "linux" || source == "--config-dir" || source == ConfigDirEnv {
+		return baseDir, nil
+	}
+
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "bastionbuddy"), nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %v", err)
+	}
+	return filepath.Join(homeDir, ".local", "state", "bastionbuddy"), nil
+}
+
+// ConfigDir returns the configuration directory of the active profile
+func ConfigDir() (string, error) {
+	baseDir, err := BaseDir()
+	if err != nil {
+		return "", err
+	}
+	return profileDir(baseDir)
+}
+
+// StateDir returns the state directory of the active profile
+func StateDir() (string, error) {
+	baseDir, err := BaseStateDir()
+	if err != nil {
+		return "", err
+	}
+	return profileDir(baseDir)
+}
+
+// WritePrivateFile writes data readable only by the owner, tightening the
+// permissions of files created by earlier versions
+func WritePrivateFile(path string, data []byte) error {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+// profileDir returns the per-profile subdirectory of a base directory
+func profileDir(baseDir string) (string, error) {
+	name := ProfileName()
+	if name == "" {
+		return baseDir, nil
+	}
+	if !profileNameRe.MatchString(name) {
+		return "", fmt.Errorf("invalid profile name: %s", name)
+	}
+	return filepath.Join(baseDir, "profiles", name), nil
+}
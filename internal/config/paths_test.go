@@ -0,0 +1,106 @@
+package config
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDirPrecedence(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("XDG base directories apply on Linux only")
+	}
+	home := t.TempDir()
+
+	tests := []struct {
+		name       string
+		override   string
+		env        map[string]string
+		wantConfig string
+		wantSource string
+		wantState  string
+	}{
+		{
+			name:       "default",
+			wantConfig: filepath.Join(home, ".config", "bastionbuddy"),
+			wantSource: "default",
+			wantState:  filepath.Join(home, ".local", "state", "bastionbuddy"),
+		},
+		{
+			name:       "XDG",
+			env:        map[string]string{"XDG_CONFIG_HOME": "/xdg/config", "XDG_STATE_HOME": "/xdg/state"},
+			wantConfig: "/xdg/config/bastionbuddy",
+			wantSource: "XDG_CONFIG_HOME",
+			wantState:  "/xdg/state/bastionbuddy",
+		},
+		{
+			name:       "relative XDG paths are ignored",
+			env:        map[string]string{"XDG_CONFIG_HOME": "xdg/config", "XDG_STATE_HOME": "xdg/state"},
+			wantConfig: filepath.Join(home, ".config", "bastionbuddy"),
+			wantSource: "default",
+			wantState:  filepath.Join(home, ".local", "state", "bastionbuddy"),
+		},
+		{
+			name:       "environment over XDG, state alongside",
+			env:        map[string]string{ConfigDirEnv: "/env/bb", "XDG_CONFIG_HOME": "/xdg/config", "XDG_STATE_HOME": "/xdg/state"},
+			wantConfig: "/env/bb",
+			wantSource: ConfigDirEnv,
+			wantState:  "/env/bb",
+		},
+		{
+			name:       "flag over environment",
+			override:   "/flag/bb",
+			env:        map[string]string{ConfigDirEnv: "/env/bb", "XDG_STATE_HOME": "/xdg/state"},
+			wantConfig: "/flag/bb",
+			wantSource: "--config-dir",
+			wantState:  "/flag/bb",
+		},
+		{
+			name:       "explicit state dir",
+			override:   "/flag/bb",
+			env:        map[string]string{StateDirEnv: "/state/bb"},
+			wantConfig: "/flag/bb",
+			wantSource: "--config-dir",
+			wantState:  "/state/bb",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", home)
+			for _, name := range []string{ConfigDirEnv, StateDirEnv, "XDG_CONFIG_HOME", "XDG_STATE_HOME", ProfileEnv} {
+				t.Setenv(name, tt.env[name])
+			}
+			SetConfigDir(tt.override)
+			t.Cleanup(func() { SetConfigDir("") })
+
+			if dir, err := BaseDir(); err != nil || dir != tt.wantConfig {
+				t.Errorf("BaseDir = %q, %v, want %q", dir, err, tt.wantConfig)
+			}
+			if source := BaseDirSource(); source != tt.wantSource {
+				t.Errorf("BaseDirSource = %q, want %q", source, tt.wantSource)
+			}
+			if dir, err := BaseStateDir(); err != nil || dir != tt.wantState {
+				t.Errorf("BaseStateDir = %q, %v, want %q", dir, err, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestProfileDirs(t *testing.T) {
+	t.Setenv(ConfigDirEnv, "/env/bb")
+	t.Setenv(StateDirEnv, "/state/bb")
+	t.Setenv(ProfileEnv, "prod")
+	t.Cleanup(func() { SetProfileName("") })
+
+	if dir, err := ConfigDir(); err != nil || dir != filepath.Join("/env/bb", "profiles", "prod") {
+		t.Errorf("ConfigDir = %q, %v", dir, err)
+	}
+	if dir, err := StateDir(); err != nil || dir != filepath.Join("/state/bb", "profiles", "prod") {
+		t.Errorf("StateDir = %q, %v", dir, err)
+	}
+
+	SetProfileName("../escape")
+	if dir, err := ConfigDir(); err == nil {
+		t.Errorf("ConfigDir = %q for an invalid profile name, want an error", dir)
+	}
+}
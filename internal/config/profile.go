@@ -0,0 +1,165 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// ProfileEnv is the environment variable selecting the active profile
+const ProfileEnv = "BASTIONBUDDY_PROFILE"
+
+// Profile represents a named environment with its own configuration store,
+// default tenant and subscription
+type Profile struct {
+	Name            string `json:"-"`
+	TenantID        string `json:"tenant_id,omitempty"`
+	SubscriptionID  string `json:"subscription_id,omitempty"`
+	Color           string `json:"color,omitempty"`
+	Banner          string `json:"banner,omitempty"`
+	SeparateAzLogin bool   `json:"separate_az_login,omitempty"`
+	// Auth is the credential source used for discovery, e.g. managed-identity
+	Auth string `json:"auth,omitempty"`
+	// Cloud is the Azure cloud, e.g. AzureUSGovernment, or a custom ARM endpoint
+	Cloud string `json:"cloud,omitempty"`
+	// Proxy and CABundle override the global settings for this profile
+	Proxy    string `json:"proxy,omitempty"`
+	CABundle string `json:"ca_bundle,omitempty"`
+	// PIM lists subscriptions reachable through eligible PIM roles when picking one
+	PIM bool `json:"pim,omitempty"`
+	// Variables are substituted for ${name} placeholders in saved configurations
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+var (
+	profileName   string
+	profileNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+)
+
+// SetProfileName selects the active profile, overriding BASTIONBUDDY_PROFILE
+func SetProfileName(name string) {
+	profileName = name
+}
+
+// ProfileName returns the name of the active profile, or "" for the default store
+func ProfileName() string {
+	if profileName != "" {
+		return profileName
+	}
+	return os.Getenv(ProfileEnv)
+}
+
+// profilesFile returns the path of the file holding all profile definitions
+func profilesFile() (string, error) {
+	baseDir, err := BaseDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(baseDir, "profiles.json"), nil
+}
+
+// LoadProfiles returns all defined profiles, sorted by name
+func LoadProfiles() ([]Profile, error) {
+	profiles, err := readProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Profile, 0, len(profiles))
+	for name, profile := range profiles {
+		profile.Name = name
+		result = append(result, profile)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// ActiveProfile returns the active profile, or nil when no profile is selected.
+// Selecting a profile that has not been defined yields an empty profile so it
+// still gets its own configuration store.
+func ActiveProfile() (*Profile, error) {
+	name := ProfileName()
+	if name == "" {
+		return nil, nil
+	}
+
+	profiles, err := readProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	profile := profiles[name]
+	profile.Name = name
+	return &profile, nil
+}
+
+// SaveProfile creates or replaces a profile definition
+func SaveProfile(profile Profile) error {
+	if !profileNameRe.MatchString(profile.Name) {
+		return fmt.Errorf("invalid profile name: %s", profile.Name)
+	}
+
+	profiles, err := readProfiles()
+	if err != nil {
+		return err
+	}
+	profiles[profile.Name] = profile
+	return writeProfiles(profiles)
+}
+
+// RemoveProfile deletes a profile definition. Its configuration store is kept.
+func RemoveProfile(name string) error {
+	profiles, err := readProfiles()
+	if err != nil {
+		return err
+	}
+	if _, ok := profiles[name]; !ok {
+		return fmt.Errorf("profile '%s' not found", name)
+	}
+	delete(profiles, name)
+	return writeProfiles(profiles)
+}
+
+// readProfiles loads the profile definitions from disk
+func readProfiles() (map[string]Profile, error) {
+	file, err := profilesFile()
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make(map[string]Profile)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return profiles, nil
+		}
+		return nil, fmt.Errorf("failed to read profiles: %v", err)
+	}
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse profiles: %v", err)
+	}
+	return profiles, nil
+}
+
+// writeProfiles saves the profile definitions to disk
+func writeProfiles(profiles map[string]Profile) error {
+	file, err := profilesFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal profiles: %v", err)
+	}
+	if err := WritePrivateFile(file, data); err != nil {
+		return fmt.Errorf("failed to save profiles: %v", err)
+	}
+	return nil
+}
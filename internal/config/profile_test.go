@@ -0,0 +1,41 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveProfileRestrictsPermissions(t *testing.T) {
+	dir := t.TempDir()
+	SetConfigDir(dir)
+	t.Cleanup(func() { SetConfigDir("") })
+
+	// A profiles file left readable by an earlier version is tightened
+	file := filepath.Join(dir, "profiles.json")
+	if err := os.WriteFile(file, []byte(`{"dev":{"tenant_id":"tenant-1"}}`), 0644); err != nil {
+		t.Fatalf("write profiles: %v", err)
+	}
+	if err := os.Chmod(file, 0644); err != nil {
+		t.Fatalf("chmod profiles: %v", err)
+	}
+
+	if err := SaveProfile(Profile{Name: "prod", TenantID: "tenant-2"}); err != nil {
+		t.Fatalf("SaveProfile: %v", err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("stat profiles: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("profiles.json mode = %o, want 600", mode)
+	}
+
+	profiles, err := LoadProfiles()
+	if err != nil {
+		t.Fatalf("LoadProfiles: %v", err)
+	}
+	if len(profiles) != 2 || profiles[0].Name != "dev" || profiles[1].TenantID != "tenant-2" {
+		t.Errorf("profiles = %+v", profiles)
+	}
+}
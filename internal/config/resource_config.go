@@ -1,10 +1,21 @@
 package config
 
+const (
+	// TargetTypeIP is the TargetResource type for targets reached by IP address
+	// through Bastion's IP-based connection
+	TargetTypeIP = "ip"
+	// TargetTypeScaleSet is the TargetResource type for "any healthy instance"
+	// of a virtual machine scale set, resolved at connect time
+	TargetTypeScaleSet = "vmss"
+)
+
 // BastionHost represents an Azure Bastion host
 type BastionHost struct {
 	Name           string
 	ResourceGroup  string
 	SubscriptionID string
+	// TenantID is the tenant the host was found in, empty for the default tenant
+	TenantID string
 }
 
 // TargetResource represents an Azure resource that can be connected to via Bastion
@@ -13,6 +24,52 @@ type TargetResource struct {
 	Name           string
 	Type           string
 	SubscriptionID string
+	// IPAddress is the private IP of an "ip" target
+	IPAddress string
+	// InstanceID is the scale set instance a "vmss" target resolved to
+	InstanceID string
+	// OSType is Linux or Windows when known
+	OSType string
+	// Defaults are the connection defaults read from the VM's tags
+	Defaults TargetDefaults
+	// TenantID is the tenant the target was found in, empty for the default tenant
+	TenantID string
+}
+
+// TargetDefaults are connection defaults an operations team sets on a VM
+// with bastionbuddy-* tags
+type TargetDefaults struct {
+	Username  string
+	Ports     []int
+	BastionID string
+	AuthType  string
+}
+
+// IsIP reports whether the target is reached by IP address
+func (t *TargetResource) IsIP() bool {
+	return t.Type == TargetTypeIP
+}
+
+// IsScaleSet reports whether the target is any healthy instance of a scale set
+func (t *TargetResource) IsScaleSet() bool {
+	return t.Type == TargetTypeScaleSet
+}
+
+// ConnectID returns the resource ID to connect to: the resolved instance for
+// a scale set target, otherwise the target's own ID
+func (t *TargetResource) ConnectID() string {
+	if t.IsScaleSet() && t.InstanceID != "" {
+		return t.InstanceID
+	}
+	return t.ID
+}
+
+// TargetArgs returns the az network bastion arguments that select the target
+func (t *TargetResource) TargetArgs() []string {
+	if t.IsIP() {
+		return []string{"--target-ip-address", t.IPAddress}
+	}
+	return []string{"--target-resource-id", t.ConnectID()}
 }
 
 // ResourceConfig represents the configuration for connecting to an Azure resource
@@ -22,4 +79,6 @@ type ResourceConfig struct {
 	Username       string
 	LocalPort      int
 	RemotePort     int
+	// StopVMOnDisconnect deallocates the target VM when the connection ends
+	StopVMOnDisconnect bool
 }
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Settings holds options that apply to every profile
+type Settings struct {
+	// Proxy is the URL of the HTTPS proxy for Azure requests, e.g. http://proxy:8080
+	Proxy string `json:"proxy,omitempty"`
+	// NoProxy lists hosts reached without the proxy, as in NO_PROXY
+	NoProxy string `json:"no_proxy,omitempty"`
+	// CABundle is a PEM file with additional trusted certificate authorities,
+	// e.g. for a TLS-inspecting proxy
+	CABundle string `json:"ca_bundle,omitempty"`
+}
+
+// settingsFile returns the path of the global settings file
+func settingsFile() (string, error) {
+	baseDir, err := BaseDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(baseDir, "settings.json"), nil
+}
+
+// LoadSettings reads the global settings. A missing file yields empty settings.
+func LoadSettings() (*Settings, error) {
+	file, err := settingsFile()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &Settings{}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return settings, nil
+		}
+		return nil, fmt.Errorf("failed to read settings: %v", err)
+	}
+	if err := json.Unmarshal(data, settings); err != nil {
+		return nil, fmt.Errorf("failed to parse settings: %v", err)
+	}
+	return settings, nil
+}
+
+// SaveSettings writes the global settings
+func SaveSettings(settings *Settings) error {
+	file, err := settingsFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal settings: %v", err)
+	}
+	if err := WritePrivateFile(file, data); err != nil {
+		return fmt.Errorf("failed to save settings: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package config
+
+import "os"
+
+// variableOverrides holds values set with --set for this invocation
+var variableOverrides = make(map[string]string)
+
+// SetVariable sets a template variable for this invocation, taking
+// precedence over profile variables and the environment
+func SetVariable(name, value string) {
+	variableOverrides[name] = value
+}
+
+// LookupVariable resolves a template variable from --set overrides, the
+// active profile's variables and the environment, in that order
+func LookupVariable(name string) (string, bool) {
+	if value, ok := variableOverrides[name]; ok {
+		return value, true
+	}
+
+	if profile, err := ActiveProfile(); err == nil && profile != nil {
+		if value, ok := profile.Variables[name]; ok {
+			return value, true
+		}
+	}
+
+	return os.LookupEnv(name)
+}
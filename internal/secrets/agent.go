@@ -0,0 +1,147 @@
+package secrets
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// agentInfo is written next to the vault state so clients can reach the agent
+type agentInfo struct {
+	Address string    `json:"address"`
+	Token   string    `json:"token"`
+	PID     int       `json:"pid"`
+	Expires time.Time `json:"expires"`
+}
+
+// ServeAgent holds the vault key in memory and hands it out to local clients
+// presenting the token from infoFile, until ttl elapses or it is stopped.
+func ServeAgent(infoFile string, key []byte, ttl time.Duration) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("failed to start agent: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return fmt.Errorf("failed to generate agent token: %v", err)
+	}
+
+	info := agentInfo{
+		Address: listener.Addr().String(),
+		Token:   hex.EncodeToString(tokenBytes),
+		PID:     os.Getpid(),
+		Expires: time.Now().Add(ttl),
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent info: %v", err)
+	}
+	if err := os.WriteFile(infoFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to write agent info: %v", err)
+	}
+	defer func() { _ = os.Remove(infoFile) }()
+	// The file may have been left behind by an earlier agent with looser permissions
+	if err := os.Chmod(infoFile, 0600); err != nil {
+		return fmt.Errorf("failed to restrict agent info: %v", err)
+	}
+
+	// Stop accepting once the key expires
+	timer := time.AfterFunc(ttl, func() { _ = listener.Close() })
+	defer timer.Stop()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return nil
+		}
+		if stop := handleAgentConn(conn, info.Token, key); stop {
+			return nil
+		}
+	}
+}
+
+// handleAgentConn answers a single request and reports whether the agent should stop
+func handleAgentConn(conn net.Conn, token string, key []byte) bool {
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	presented, command, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+		return false
+	}
+
+	switch command {
+	case "get":
+		_, _ = fmt.Fprintln(conn, hex.EncodeToString(key))
+		return false
+	case "stop":
+		_, _ = fmt.Fprintln(conn, "ok")
+		return true
+	default:
+		return false
+	}
+}
+
+// AgentKey asks a running agent for the vault key
+func AgentKey(infoFile string) ([]byte, error) {
+	response, err := agentRequest(infoFile, "get")
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response from agent: %v", err)
+	}
+	return key, nil
+}
+
+// StopAgent asks a running agent to forget the key and exit
+func StopAgent(infoFile string) error {
+	_, err := agentRequest(infoFile, "stop")
+	return err
+}
+
+// agentRequest sends an authenticated command to the agent and returns its reply
+func agentRequest(infoFile string, command string) (string, error) {
+	data, err := os.ReadFile(infoFile)
+	if err != nil {
+		return "", fmt.Errorf("no secret agent running")
+	}
+
+	var info agentInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return "", fmt.Errorf("failed to parse agent info: %v", err)
+	}
+	if time.Now().After(info.Expires) {
+		return "", fmt.Errorf("secret agent expired")
+	}
+
+	conn, err := net.DialTimeout("tcp", info.Address, time.Second)
+	if err != nil {
+		return "", fmt.Errorf("secret agent not reachable: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := fmt.Fprintf(conn, "%s %s\n", info.Token, command); err != nil {
+		return "", fmt.Errorf("failed to contact secret agent: %v", err)
+	}
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read from secret agent: %v", err)
+	}
+	return strings.TrimSpace(response), nil
+}
@@ -0,0 +1,94 @@
+package secrets
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/antnsn/BastionBuddy/internal/config"
+)
+
+// sessionKeys caches unlocked vault keys for the lifetime of the process
+var sessionKeys = make(map[string][]byte)
+
+// VaultPath returns the vault location for the active profile
+func VaultPath() (string, error) {
+	configDir, err := config.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "secrets.vault"), nil
+}
+
+// AgentInfoPath returns where a running secret agent advertises itself
+func AgentInfoPath() (string, error) {
+	stateDir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, "secret-agent.json"), nil
+}
+
+// OpenUnlocked opens the vault of the active profile and unlocks it with the
+// key cached for this session, the key held by a running agent, or a
+// passphrase read through prompt, in that order.
+func OpenUnlocked(prompt func(label string) (string, error)) (*Vault, error) {
+	path, err := VaultPath()
+	if err != nil {
+		return nil, err
+	}
+	vault, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, ok := sessionKeys[path]; ok {
+		if err := vault.Unlock(key); err == nil {
+			return vault, nil
+		}
+	}
+
+	if infoFile, err := AgentInfoPath(); err == nil {
+		if key, err := AgentKey(infoFile); err == nil {
+			if err := vault.Unlock(key); err == nil {
+				sessionKeys[path] = key
+				return vault, nil
+			}
+		}
+	}
+
+	label := "Vault passphrase"
+	if vault.IsNew() {
+		label = "New vault passphrase"
+	}
+	passphrase, err := prompt(label)
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+	if vault.IsNew() {
+		confirm, err := prompt("Confirm vault passphrase")
+		if err != nil {
+			return nil, err
+		}
+		if confirm != passphrase {
+			return nil, fmt.Errorf("passphrases do not match")
+		}
+	}
+
+	key, err := vault.DeriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := vault.Unlock(key); err != nil {
+		return nil, err
+	}
+	sessionKeys[path] = key
+	return vault, nil
+}
+
+// SessionKey returns the key the vault was unlocked with in this session
+func SessionKey(vault *Vault) []byte {
+	return sessionKeys[vault.Path()]
+}
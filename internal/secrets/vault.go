@@ -0,0 +1,220 @@
+// Package secrets provides an encrypted, passphrase-protected vault for
+// connection credentials such as SSH passwords.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrWrongPassphrase is returned when the vault cannot be decrypted with the given key
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted vault")
+
+const (
+	vaultVersion = 1
+	keyLength    = 32
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+)
+
+// vaultFile is the on-disk representation of a vault
+type vaultFile struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce,omitempty"`
+	Data    []byte `json:"data,omitempty"`
+}
+
+// Vault holds named secrets encrypted with a passphrase-derived key
+type Vault struct {
+	path    string
+	file    vaultFile
+	key     []byte
+	secrets map[string]string
+}
+
+// Open reads the vault at path. A missing file yields a new, empty vault
+// that is created on the first Save.
+func Open(path string) (*Vault, error) {
+	v := &Vault{path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read vault: %v", err)
+		}
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("failed to generate salt: %v", err)
+		}
+		v.file = vaultFile{Version: vaultVersion, KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP, Salt: salt}
+		v.secrets = make(map[string]string)
+		return v, nil
+	}
+
+	if err := json.Unmarshal(data, &v.file); err != nil {
+		return nil, fmt.Errorf("failed to parse vault: %v", err)
+	}
+	if v.file.Version != vaultVersion || v.file.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported vault format (version %d, kdf %s)", v.file.Version, v.file.KDF)
+	}
+	return v, nil
+}
+
+// Path returns the location of the vault file
+func (v *Vault) Path() string {
+	return v.path
+}
+
+// IsNew reports whether the vault has never been saved
+func (v *Vault) IsNew() bool {
+	return v.file.Data == nil
+}
+
+// DeriveKey derives the vault key from a passphrase
+func (v *Vault) DeriveKey(passphrase string) ([]byte, error) {
+	key, err := scrypt.Key([]byte(passphrase), v.file.Salt, v.file.N, v.file.R, v.file.P, keyLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+	return key, nil
+}
+
+// Unlock decrypts the vault with a previously derived key
+func (v *Vault) Unlock(key []byte) error {
+	if v.IsNew() {
+		v.key = key
+		v.secrets = make(map[string]string)
+		return nil
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	plaintext, err := gcm.Open(nil, v.file.Nonce, v.file.Data, nil)
+	if err != nil {
+		return ErrWrongPassphrase
+	}
+
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return fmt.Errorf("failed to parse vault contents: %v", err)
+	}
+
+	v.key = key
+	v.secrets = secrets
+	return nil
+}
+
+// Get returns the named secret
+func (v *Vault) Get(name string) (string, error) {
+	if v.key == nil {
+		return "", fmt.Errorf("vault is locked")
+	}
+	value, ok := v.secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret '%s' not found", name)
+	}
+	return value, nil
+}
+
+// Set stores a secret; call Save to persist it
+func (v *Vault) Set(name, value string) error {
+	if v.key == nil {
+		return fmt.Errorf("vault is locked")
+	}
+	v.secrets[name] = value
+	return nil
+}
+
+// Delete removes a secret; call Save to persist the change
+func (v *Vault) Delete(name string) error {
+	if v.key == nil {
+		return fmt.Errorf("vault is locked")
+	}
+	if _, ok := v.secrets[name]; !ok {
+		return fmt.Errorf("secret '%s' not found", name)
+	}
+	delete(v.secrets, name)
+	return nil
+}
+
+// Names returns the names of all stored secrets, sorted
+func (v *Vault) Names() []string {
+	names := make([]string, 0, len(v.secrets))
+	for name := range v.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save encrypts the vault and writes it to disk with owner-only permissions
+func (v *Vault) Save() error {
+	if v.key == nil {
+		return fmt.Errorf("vault is locked")
+	}
+
+	plaintext, err := json.Marshal(v.secrets)
+	if err != nil {
+		return fmt.Errorf("failed to marshal secrets: %v", err)
+	}
+
+	gcm, err := newGCM(v.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	file := v.file
+	file.Nonce = nonce
+	file.Data = gcm.Seal(nil, nonce, plaintext, nil)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal vault: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(v.path), 0700); err != nil {
+		return fmt.Errorf("failed to create vault directory: %v", err)
+	}
+	if err := os.WriteFile(v.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save vault: %v", err)
+	}
+	if err := os.Chmod(v.path, 0600); err != nil {
+		return fmt.Errorf("failed to restrict vault permissions: %v", err)
+	}
+
+	v.file = file
+	return nil
+}
+
+// newGCM creates an AES-GCM cipher for the given key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return gcm, nil
+}
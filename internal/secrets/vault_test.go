@@ -0,0 +1,102 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openUnlocked opens the vault at path and unlocks it with passphrase
+func openUnlocked(t *testing.T, path, passphrase string) (*Vault, error) {
+	t.Helper()
+	v, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	key, err := v.DeriveKey(passphrase)
+	if err != nil {
+		t.Fatalf("DeriveKey: %v", err)
+	}
+	return v, v.Unlock(key)
+}
+
+func TestVaultRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault", "secrets.json")
+
+	v, err := openUnlocked(t, path, "correct horse")
+	if err != nil {
+		t.Fatalf("Unlock new vault: %v", err)
+	}
+	if !v.IsNew() {
+		t.Fatal("vault without a file should be new")
+	}
+	if err := v.Set("vm-password", "s3cret"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := v.Set("db-password", "hunter2"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read vault: %v", err)
+	}
+	if bytes.Contains(data, []byte("s3cret")) || bytes.Contains(data, []byte("vm-password")) {
+		t.Error("vault file contains a secret or its name in plain text")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat vault: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("vault mode = %o, want 600", mode)
+	}
+
+	reopened, err := openUnlocked(t, path, "correct horse")
+	if err != nil {
+		t.Fatalf("Unlock saved vault: %v", err)
+	}
+	if reopened.IsNew() {
+		t.Error("saved vault reported as new")
+	}
+	if got, err := reopened.Get("vm-password"); err != nil || got != "s3cret" {
+		t.Errorf("Get = %q, %v, want s3cret", got, err)
+	}
+	if names := reopened.Names(); len(names) != 2 || names[0] != "db-password" || names[1] != "vm-password" {
+		t.Errorf("Names = %v", names)
+	}
+	if _, err := reopened.Get("missing"); err == nil {
+		t.Error("Get of a missing secret succeeded")
+	}
+}
+
+func TestVaultWrongPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+
+	v, err := openUnlocked(t, path, "correct horse")
+	if err != nil {
+		t.Fatalf("Unlock new vault: %v", err)
+	}
+	if err := v.Set("vm-password", "s3cret"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	locked, err := openUnlocked(t, path, "battery staple")
+	if !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("Unlock with wrong passphrase = %v, want ErrWrongPassphrase", err)
+	}
+	if _, err := locked.Get("vm-password"); err == nil {
+		t.Error("Get succeeded on a vault that failed to unlock")
+	}
+	if err := locked.Set("vm-password", "other"); err == nil {
+		t.Error("Set succeeded on a vault that failed to unlock")
+	}
+}
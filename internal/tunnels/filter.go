@@ -0,0 +1,98 @@
+package tunnels
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Filter selects saved configurations by connection type, tags and free text
+type Filter struct {
+	ConnectionType string
+	Tags           map[string]string
+	Search         string
+}
+
+// ParseTag parses a "key=value" tag selector. A bare "key" matches any value.
+func ParseTag(tag string) (string, string, error) {
+	key, value, _ := strings.Cut(tag, "=")
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", "", fmt.Errorf("invalid tag %q: expected key=value", tag)
+	}
+	return key, strings.TrimSpace(value), nil
+}
+
+// IsEmpty reports whether the filter matches every configuration
+func (f Filter) IsEmpty() bool {
+	return f.ConnectionType == "" && len(f.Tags) == 0 && f.Search == ""
+}
+
+// Matches reports whether a configuration satisfies the filter
+func (f Filter) Matches(config Config) bool {
+	if f.ConnectionType != "" && normalizeType(config.ConnectionType) != normalizeType(f.ConnectionType) {
+		return false
+	}
+
+	for key, value := range f.Tags {
+		actual, ok := config.Tags[key]
+		if !ok || (value != "" && !strings.EqualFold(actual, value)) {
+			return false
+		}
+	}
+
+	if f.Search != "" {
+		search := strings.ToLower(f.Search)
+		fields := []string{config.Name, config.ResourceName, config.Description, config.BastionName, config.Username}
+		for key, value := range config.Tags {
+			fields = append(fields, key+"="+value)
+		}
+		found := false
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(field), search) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FormatTags renders tags as a stable, comma separated "key=value" list
+func FormatTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, key+"="+tags[key])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ParseConnectionType parses a connection type given on the command line,
+// also accepting the plural forms such as "tunnels"
+func ParseConnectionType(word string) (string, error) {
+	switch connectionType := strings.TrimSuffix(strings.ToLower(word), "s"); connectionType {
+	case "tunnel", "ssh", "rdp":
+		return connectionType, nil
+	}
+	return "", fmt.Errorf("unknown connection type %q: expected tunnel, ssh or rdp", word)
+}
+
+// normalizeType maps the legacy empty and plural connection types to "tunnel"
+func normalizeType(connectionType string) string {
+	switch connectionType {
+	case "", "tunnels":
+		return "tunnel"
+	default:
+		return connectionType
+	}
+}
@@ -0,0 +1,97 @@
+package tunnels
+
+import "testing"
+
+func TestParseTag(t *testing.T) {
+	tests := []struct {
+		tag       string
+		key       string
+		value     string
+		wantError bool
+	}{
+		{tag: "env=prod", key: "env", value: "prod"},
+		{tag: " env = prod ", key: "env", value: "prod"},
+		{tag: "env", key: "env"},
+		{tag: "env=", key: "env"},
+		{tag: "url=https://a.example/?x=1", key: "url", value: "https://a.example/?x=1"},
+		{tag: "=prod", wantError: true},
+		{tag: "", wantError: true},
+		{tag: "  =  ", wantError: true},
+	}
+	for _, tt := range tests {
+		key, value, err := ParseTag(tt.tag)
+		if (err != nil) != tt.wantError {
+			t.Errorf("ParseTag(%q) error = %v, want error %v", tt.tag, err, tt.wantError)
+			continue
+		}
+		if key != tt.key || value != tt.value {
+			t.Errorf("ParseTag(%q) = %q, %q, want %q, %q", tt.tag, key, value, tt.key, tt.value)
+		}
+	}
+}
+
+func TestParseConnectionType(t *testing.T) {
+	tests := map[string]string{
+		"tunnel":  "tunnel",
+		"tunnels": "tunnel",
+		"ssh":     "ssh",
+		"SSH":     "ssh",
+		"rdp":     "rdp",
+		"rdps":    "rdp",
+		"foo":     "",
+		"":        "",
+		"s":       "",
+	}
+	for word, want := range tests {
+		got, err := ParseConnectionType(word)
+		if got != want || (err != nil) != (want == "") {
+			t.Errorf("ParseConnectionType(%q) = %q, %v, want %q", word, got, err, want)
+		}
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	db := Config{
+		Name:           "prod-db",
+		ResourceName:   "vm-postgres-01",
+		Description:    "Primary Postgres",
+		BastionName:    "bastion-hub",
+		Username:       "dba",
+		ConnectionType: "tunnel",
+		Tags:           map[string]string{"env": "Prod", "team": "payments"},
+	}
+	legacy := Config{Name: "old", ConnectionType: ""}
+	jump := Config{Name: "jump", ConnectionType: "ssh", Tags: map[string]string{"env": "dev"}}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		config Config
+		want   bool
+	}{
+		{"empty filter", Filter{}, db, true},
+		{"type", Filter{ConnectionType: "tunnel"}, db, true},
+		{"legacy empty type is a tunnel", Filter{ConnectionType: "tunnel"}, legacy, true},
+		{"other type", Filter{ConnectionType: "ssh"}, db, false},
+		{"ssh type", Filter{ConnectionType: "ssh"}, jump, true},
+		{"tag value ignores case", Filter{Tags: map[string]string{"env": "prod"}}, db, true},
+		{"tag value differs", Filter{Tags: map[string]string{"env": "dev"}}, db, false},
+		{"bare tag key", Filter{Tags: map[string]string{"team": ""}}, db, true},
+		{"missing tag", Filter{Tags: map[string]string{"owner": ""}}, db, false},
+		{"all tags required", Filter{Tags: map[string]string{"env": "prod", "team": "search"}}, db, false},
+		{"search name", Filter{Search: "PROD"}, db, true},
+		{"search resource", Filter{Search: "postgres-01"}, db, true},
+		{"search description", Filter{Search: "primary"}, db, true},
+		{"search bastion", Filter{Search: "hub"}, db, true},
+		{"search username", Filter{Search: "dba"}, db, true},
+		{"search tag", Filter{Search: "team=pay"}, db, true},
+		{"search miss", Filter{Search: "redis"}, db, false},
+		{"everything but search", Filter{ConnectionType: "tunnel", Tags: map[string]string{"env": ""}, Search: "pg"}, db, false},
+		{"everything matching", Filter{ConnectionType: "tunnel", Tags: map[string]string{"env": ""}, Search: "postgres"}, db, true},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Matches(tt.config); got != tt.want {
+			t.Errorf("%s: Matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -7,11 +7,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/antnsn/BastionBuddy/internal/config"
 )
 
 // Manager handles saving and loading tunnel configurations
 type Manager struct {
 	configDir     string
+	stateDir      string
 	tunnelFile    string
 	sshFile       string
 	rdpFile       string
@@ -20,26 +23,37 @@ type Manager struct {
 	sshConfigs    []Config
 	rdpConfigs    []Config
 	active        []Active
+	teamSources   []string
+	teamConfigs   []Config
 }
 
 // NewManager creates a new tunnel configuration manager
 func NewManager() (*Manager, error) {
-	homeDir, err := os.UserHomeDir()
+	configDir, err := config.ConfigDir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user home directory: %v", err)
+		return nil, err
 	}
 
-	configDir := filepath.Join(homeDir, ".config", "bastionbuddy")
 	if err := os.MkdirAll(configDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create config directory: %v", err)
 	}
 
+	stateDir, err := config.StateDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %v", err)
+	}
+
 	manager := &Manager{
 		configDir:     configDir,
+		stateDir:      stateDir,
 		tunnelFile:    filepath.Join(configDir, "tunnels.json"),
 		sshFile:       filepath.Join(configDir, "ssh.json"),
 		rdpFile:       filepath.Join(configDir, "rdp.json"),
-		activeTunnels: filepath.Join(configDir, "active.json"),
+		activeTunnels: filepath.Join(stateDir, "active.json"),
+		teamSources:   TeamConfigDirs(),
 	}
 
 	if err := manager.load(); err != nil {
@@ -51,6 +65,17 @@ func NewManager() (*Manager, error) {
 
 // SaveConfig saves a tunnel configuration for future use
 func (m *Manager) SaveConfig(config Config) error {
+	// Team configurations are read-only; only track their usage in memory
+	if config.ReadOnly {
+		for i, existing := range m.teamConfigs {
+			if existing.Name == config.Name {
+				m.teamConfigs[i].LastUsed = config.LastUsed
+			}
+		}
+		return nil
+	}
+
+	config.Source = m.fileForType(config.ConnectionType)
 	switch config.ConnectionType {
 	case "ssh":
 		// Check if configuration with same name exists
@@ -89,21 +114,85 @@ func (m *Manager) SaveConfig(config Config) error {
 	return m.save()
 }
 
-// GetSavedConfigs returns all saved tunnel configurations
+// fileForType returns the user configuration file for a connection type
+func (m *Manager) fileForType(connectionType string) string {
+	switch connectionType {
+	case "ssh":
+		return m.sshFile
+	case "rdp":
+		return m.rdpFile
+	default:
+		return m.tunnelFile
+	}
+}
+
+// GetSavedConfigs returns all saved tunnel configurations, including team
+// configurations that are not shadowed by a user configuration
 func (m *Manager) GetSavedConfigs() []Config {
-	return append(append(m.tunnelConfigs, m.sshConfigs...), m.rdpConfigs...)
+	configs := make([]Config, 0, len(m.tunnelConfigs)+len(m.sshConfigs)+len(m.rdpConfigs))
+	configs = append(configs, m.tunnelConfigs...)
+	configs = append(configs, m.sshConfigs...)
+	configs = append(configs, m.rdpConfigs...)
+	return append(configs, m.unshadowedTeamConfigs("")...)
 }
 
 // GetSavedConfigsByType returns saved configurations of a specific type
 func (m *Manager) GetSavedConfigsByType(connectionType string) []Config {
+	var configs []Config
 	switch connectionType {
 	case "ssh":
-		return m.sshConfigs
+		configs = append(configs, m.sshConfigs...)
 	case "rdp":
-		return m.rdpConfigs
+		configs = append(configs, m.rdpConfigs...)
 	default:
-		return m.tunnelConfigs
+		connectionType = "tunnel"
+		configs = append(configs, m.tunnelConfigs...)
 	}
+	return append(configs, m.unshadowedTeamConfigs(connectionType)...)
+}
+
+// FilterConfigs returns the saved configurations that match the filter
+func (m *Manager) FilterConfigs(filter Filter) []Config {
+	var configs []Config
+	for _, config := range m.GetSavedConfigs() {
+		if filter.Matches(config) {
+			configs = append(configs, config)
+		}
+	}
+	return configs
+}
+
+// FindConfig returns the saved configuration with the given name
+func (m *Manager) FindConfig(name string) (Config, bool) {
+	for _, config := range m.GetSavedConfigs() {
+		if config.Name == name {
+			return config, true
+		}
+	}
+	return Config{}, false
+}
+
+// unshadowedTeamConfigs returns the team configurations of the given type
+// (or all types when empty) whose name is not used by a user configuration
+func (m *Manager) unshadowedTeamConfigs(connectionType string) []Config {
+	userNames := make(map[string]bool)
+	for _, list := range [][]Config{m.tunnelConfigs, m.sshConfigs, m.rdpConfigs} {
+		for _, config := range list {
+			userNames[config.Name] = true
+		}
+	}
+
+	var configs []Config
+	for _, config := range m.teamConfigs {
+		if userNames[config.Name] {
+			continue
+		}
+		if connectionType != "" && config.ConnectionType != connectionType {
+			continue
+		}
+		configs = append(configs, config)
+	}
+	return configs
 }
 
 // SaveActive saves information about a currently active tunnel
@@ -163,16 +252,39 @@ func (m *Manager) load() error {
 		}
 	}
 
-	// Load active tunnels
-	if data, err := os.ReadFile(m.activeTunnels); err == nil {
+	// Load active tunnels, falling back to the pre-XDG location inside the config directory
+	activeFile := m.activeTunnels
+	if _, err := os.Stat(activeFile); os.IsNotExist(err) && m.stateDir != m.configDir {
+		activeFile = filepath.Join(m.configDir, "active.json")
+	}
+	if data, err := os.ReadFile(activeFile); err == nil {
 		if err := json.Unmarshal(data, &m.active); err != nil {
 			return fmt.Errorf("failed to parse active tunnels: %v", err)
 		}
 	}
 
+	// Record where each user configuration came from
+	setSource(m.tunnelConfigs, m.tunnelFile)
+	setSource(m.sshConfigs, m.sshFile)
+	setSource(m.rdpConfigs, m.rdpFile)
+
+	// Load read-only team configurations
+	teamConfigs, err := loadTeamConfigs(m.teamSources)
+	if err != nil {
+		return err
+	}
+	m.teamConfigs = teamConfigs
+
 	return nil
 }
 
+// setSource sets the source file on each configuration in the list
+func setSource(configs []Config, file string) {
+	for i := range configs {
+		configs[i].Source = file
+	}
+}
+
 // save saves the current configurations and active tunnels to disk
 func (m *Manager) save() error {
 	// Save tunnel configurations
@@ -180,7 +292,7 @@ func (m *Manager) save() error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal tunnel configurations: %v", err)
 	}
-	if err := os.WriteFile(m.tunnelFile, data, 0644); err != nil {
+	if err := config.WritePrivateFile(m.tunnelFile, data); err != nil {
 		return fmt.Errorf("failed to save tunnel configurations: %v", err)
 	}
 
@@ -189,7 +301,7 @@ func (m *Manager) save() error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal SSH configurations: %v", err)
 	}
-	if err := os.WriteFile(m.sshFile, data, 0644); err != nil {
+	if err := config.WritePrivateFile(m.sshFile, data); err != nil {
 		return fmt.Errorf("failed to save SSH configurations: %v", err)
 	}
 
@@ -198,7 +310,7 @@ func (m *Manager) save() error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal RDP configurations: %v", err)
 	}
-	if err := os.WriteFile(m.rdpFile, data, 0644); err != nil {
+	if err := config.WritePrivateFile(m.rdpFile, data); err != nil {
 		return fmt.Errorf("failed to save RDP configurations: %v", err)
 	}
 
@@ -207,7 +319,7 @@ func (m *Manager) save() error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal active tunnels: %v", err)
 	}
-	if err := os.WriteFile(m.activeTunnels, data, 0644); err != nil {
+	if err := config.WritePrivateFile(m.activeTunnels, data); err != nil {
 		return fmt.Errorf("failed to save active tunnels: %v", err)
 	}
 
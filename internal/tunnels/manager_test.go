@@ -0,0 +1,64 @@
+package tunnels
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadActiveLegacyFallback(t *testing.T) {
+	tests := []struct {
+		name   string
+		legacy string
+		state  string
+		want   string
+	}{
+		{name: "state dir", legacy: `[{"id":"legacy"}]`, state: `[{"id":"current"}]`, want: "current"},
+		{name: "legacy config dir", legacy: `[{"id":"legacy"}]`, want: "legacy"},
+		{name: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t)
+			if tt.legacy != "" {
+				writeFile(t, m.configDir, "active.json", tt.legacy)
+			}
+			if tt.state != "" {
+				writeFile(t, m.stateDir, "active.json", tt.state)
+			}
+			m = newTestManagerAt(t, m)
+
+			var got string
+			if active := m.GetActive(); len(active) == 1 {
+				got = active[0].ID
+			} else if len(active) > 1 {
+				t.Fatalf("active = %+v", active)
+			}
+			if got != tt.want {
+				t.Errorf("active tunnel = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveActiveWritesStateDir(t *testing.T) {
+	m := newTestManager(t)
+	writeFile(t, m.configDir, "active.json", `[{"id":"legacy"}]`)
+	m = newTestManagerAt(t, m)
+
+	if err := m.SaveActive(Active{ID: "new"}); err != nil {
+		t.Fatalf("SaveActive: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(m.stateDir, "active.json"))
+	if err != nil {
+		t.Fatalf("active tunnels not written to the state dir: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("active.json mode = %o, want 600", mode)
+	}
+
+	reloaded := newTestManagerAt(t, m)
+	if active := reloaded.GetActive(); len(active) != 2 {
+		t.Errorf("active = %+v, want the legacy and the new tunnel", active)
+	}
+}
@@ -0,0 +1,130 @@
+package tunnels
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// TeamConfigEnv is the environment variable listing additional read-only
+// configuration directories or files, separated by the OS path list separator.
+const TeamConfigEnv = "BASTIONBUDDY_TEAM_CONFIG"
+
+// TeamConfigDirs returns the read-only configuration sources that are layered
+// underneath the user's own configurations, in order of increasing precedence.
+func TeamConfigDirs() []string {
+	var dirs []string
+
+	if runtime.GOOS == "windows" {
+		if programData := os.Getenv("ProgramData"); programData != "" {
+			dirs = append(dirs, filepath.Join(programData, "bastionbuddy", "conf.d"))
+		}
+	} else {
+		dirs = append(dirs, filepath.Join("/etc", "bastionbuddy", "conf.d"))
+	}
+
+	for _, dir := range filepath.SplitList(os.Getenv(TeamConfigEnv)) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// loadTeamConfigs loads configurations from the given read-only sources. A
+// source may be a directory, in which case every *.json file in it is read,
+// or a single JSON file. Missing sources are skipped; unreadable sources and
+// malformed files are skipped with a warning, so one bad file does not hide
+// every other configuration.
+func loadTeamConfigs(sources []string) ([]Config, error) {
+	var configs []Config
+
+	for _, source := range sources {
+		info, err := os.Stat(source)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Warning: skipping team configuration %s: %v\n", source, err)
+			continue
+		}
+
+		files := []string{source}
+		if info.IsDir() {
+			files, err = filepath.Glob(filepath.Join(source, "*.json"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to list team configurations in %s: %v", source, err)
+			}
+			sort.Strings(files)
+		}
+
+		for _, file := range files {
+			// Runtime state never belongs to a shared source
+			if filepath.Base(file) == "active.json" {
+				continue
+			}
+
+			loaded, err := loadTeamFile(file)
+			if err != nil {
+				fmt.Printf("Warning: %v; skipping it\n", err)
+				continue
+			}
+			configs = append(configs, loaded...)
+		}
+	}
+
+	// Later sources take precedence over earlier ones with the same name
+	merged := make([]Config, 0, len(configs))
+	for _, config := range configs {
+		replaced := false
+		for i, existing := range merged {
+			if existing.Name == config.Name {
+				merged[i] = config
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, config)
+		}
+	}
+
+	return merged, nil
+}
+
+// loadTeamFile reads a single team configuration file. Entries without a
+// connection type inherit it from the file name (ssh.json, rdp.json).
+func loadTeamFile(file string) ([]Config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read team configuration %s: %v", file, err)
+	}
+
+	var configs []Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse team configuration %s: %v", file, err)
+	}
+
+	defaultType := "tunnel"
+	switch strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)) {
+	case "ssh":
+		defaultType = "ssh"
+	case "rdp":
+		defaultType = "rdp"
+	}
+
+	for i := range configs {
+		if configs[i].ConnectionType == "" {
+			configs[i].ConnectionType = defaultType
+		}
+		configs[i].Source = file
+		configs[i].ReadOnly = true
+	}
+
+	return configs, nil
+}
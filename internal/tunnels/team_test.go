@@ -0,0 +1,187 @@
+package tunnels
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeFile writes content to dir/name, creating dir
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return path
+}
+
+// newTestManager returns a manager with its own config and state directories
+// layered over the given team sources
+func newTestManager(t *testing.T, teamSources ...string) *Manager {
+	t.Helper()
+	configDir := t.TempDir()
+	stateDir := t.TempDir()
+	m := &Manager{
+		configDir:     configDir,
+		stateDir:      stateDir,
+		tunnelFile:    filepath.Join(configDir, "tunnels.json"),
+		sshFile:       filepath.Join(configDir, "ssh.json"),
+		rdpFile:       filepath.Join(configDir, "rdp.json"),
+		activeTunnels: filepath.Join(stateDir, "active.json"),
+		teamSources:   teamSources,
+	}
+	if err := m.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return m
+}
+
+// summary renders configurations as name:type@source-file
+func summary(configs []Config) []string {
+	var out []string
+	for _, config := range configs {
+		out = append(out, config.Name+":"+config.ConnectionType+"@"+filepath.Base(config.Source))
+	}
+	return out
+}
+
+func TestLoadTeamConfigs(t *testing.T) {
+	root := t.TempDir()
+	shared := filepath.Join(root, "shared")
+	writeFile(t, shared, "ssh.json", `[{"name":"jump"},{"name":"db","connection_type":"tunnel"}]`)
+	writeFile(t, shared, "rdp.json", `[{"name":"desktop"}]`)
+	writeFile(t, shared, "tunnels.json", `[{"name":"web"}]`)
+	writeFile(t, shared, "active.json", `[{"name":"not-a-config"}]`)
+	writeFile(t, shared, "notes.txt", `not json`)
+	team := writeFile(t, filepath.Join(root, "team"), "payments.json", `[{"name":"jump","connection_type":"ssh","description":"team jump host"}]`)
+	broken := writeFile(t, filepath.Join(root, "broken"), "bad.json", `{not json`)
+	writeFile(t, filepath.Join(root, "broken"), "good.json", `[{"name":"still-loaded"}]`)
+
+	tests := []struct {
+		name    string
+		sources []string
+		want    []string
+	}{
+		{
+			name:    "directory",
+			sources: []string{shared},
+			want:    []string{"desktop:rdp@rdp.json", "jump:ssh@ssh.json", "db:tunnel@ssh.json", "web:tunnel@tunnels.json"},
+		},
+		{
+			name:    "later source shadows by name",
+			sources: []string{shared, team},
+			want:    []string{"desktop:rdp@rdp.json", "jump:ssh@payments.json", "db:tunnel@ssh.json", "web:tunnel@tunnels.json"},
+		},
+		{
+			name:    "missing sources are skipped",
+			sources: []string{filepath.Join(root, "missing"), team, filepath.Join(root, "missing.json")},
+			want:    []string{"jump:ssh@payments.json"},
+		},
+		{
+			name:    "malformed files are skipped",
+			sources: []string{broken, filepath.Join(root, "broken")},
+			want:    []string{"still-loaded:tunnel@good.json"},
+		},
+		{
+			name:    "nothing",
+			sources: nil,
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs, err := loadTeamConfigs(tt.sources)
+			if err != nil {
+				t.Fatalf("loadTeamConfigs: %v", err)
+			}
+			if got := summary(configs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("configs = %v, want %v", got, tt.want)
+			}
+			for _, config := range configs {
+				if !config.ReadOnly {
+					t.Errorf("team configuration %s is not read-only", config.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestManagerTeamLayering(t *testing.T) {
+	team := t.TempDir()
+	teamFile := writeFile(t, team, "ssh.json", `[{"name":"jump","username":"team"},{"name":"bastion-admin","username":"team"}]`)
+	writeFile(t, team, "tunnels.json", `[{"name":"db","local_port":5432}]`)
+	m := newTestManager(t, team)
+
+	// A user configuration shadows the team one with the same name
+	if err := m.SaveConfig(Config{Name: "jump", ConnectionType: "ssh", Username: "me"}); err != nil {
+		t.Fatalf("SaveConfig: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  []Config
+		want []string
+	}{
+		{"all", m.GetSavedConfigs(), []string{"jump:ssh@ssh.json", "bastion-admin:ssh@ssh.json", "db:tunnel@tunnels.json"}},
+		{"ssh", m.GetSavedConfigsByType("ssh"), []string{"jump:ssh@ssh.json", "bastion-admin:ssh@ssh.json"}},
+		{"tunnel", m.GetSavedConfigsByType("tunnel"), []string{"db:tunnel@tunnels.json"}},
+		{"rdp", m.GetSavedConfigsByType("rdp"), nil},
+	}
+	for _, tt := range tests {
+		if got := summary(tt.got); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: configs = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	jump, ok := m.FindConfig("jump")
+	if !ok || jump.ReadOnly || jump.Username != "me" || jump.Source != m.sshFile {
+		t.Errorf("jump = %+v, want the user configuration", jump)
+	}
+
+	// Using a read-only team configuration records it in memory only
+	admin, ok := m.FindConfig("bastion-admin")
+	if !ok || !admin.ReadOnly || admin.Source != teamFile {
+		t.Fatalf("bastion-admin = %+v, want the team configuration", admin)
+	}
+	before, err := os.ReadFile(teamFile)
+	if err != nil {
+		t.Fatalf("read team file: %v", err)
+	}
+	admin.LastUsed = time.Now()
+	admin.Username = "changed"
+	if err := m.SaveConfig(admin); err != nil {
+		t.Fatalf("SaveConfig: %v", err)
+	}
+	after, err := os.ReadFile(teamFile)
+	if err != nil {
+		t.Fatalf("read team file: %v", err)
+	}
+	if string(before) != string(after) {
+		t.Error("saving a read-only configuration rewrote the team file")
+	}
+	admin, _ = m.FindConfig("bastion-admin")
+	if admin.LastUsed.IsZero() || admin.Username != "team" {
+		t.Errorf("bastion-admin = %+v, want only LastUsed updated", admin)
+	}
+	if reloaded := newTestManagerAt(t, m); len(reloaded.sshConfigs) != 1 {
+		t.Errorf("user ssh configurations = %v, want only jump", summary(reloaded.sshConfigs))
+	}
+}
+
+// newTestManagerAt reloads a manager's directories from disk
+func newTestManagerAt(t *testing.T, m *Manager) *Manager {
+	t.Helper()
+	reloaded := *m
+	reloaded.tunnelConfigs, reloaded.sshConfigs, reloaded.rdpConfigs, reloaded.teamConfigs = nil, nil, nil, nil
+	reloaded.active = nil
+	if err := reloaded.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return &reloaded
+}
@@ -0,0 +1,102 @@
+package tunnels
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// placeholderRe matches ${name} and ${name:-default} placeholders
+var placeholderRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_.-]*)(?::-([^}]*))?\}`)
+
+// UnresolvedError reports placeholders that had no value
+type UnresolvedError struct {
+	Config    string
+	Variables map[string][]string
+}
+
+// Error implements the error interface
+func (e *UnresolvedError) Error() string {
+	names := make([]string, 0, len(e.Variables))
+	for name := range e.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("${%s} (in %s)", name, strings.Join(e.Variables[name], ", ")))
+	}
+	return fmt.Sprintf("unresolved variables in configuration '%s': %s; set them with --set name=value, a profile variable or an environment variable",
+		e.Config, strings.Join(parts, "; "))
+}
+
+// HasPlaceholders reports whether any field of the configuration uses a placeholder
+func HasPlaceholders(config Config) bool {
+	found := false
+	forEachTemplateField(&config, func(_ string, value *string) {
+		if placeholderRe.MatchString(*value) {
+			found = true
+		}
+	})
+	return found
+}
+
+// Resolve returns a copy of the configuration with ${name} placeholders
+// replaced using lookup. ${name:-default} falls back to default. All missing
+// variables are reported together in an *UnresolvedError.
+func Resolve(config Config, lookup func(name string) (string, bool)) (Config, error) {
+	resolved := config
+	resolved.Args = append([]string(nil), config.Args...)
+	if config.KeyVaultSecret != nil {
+		ref := *config.KeyVaultSecret
+		resolved.KeyVaultSecret = &ref
+	}
+
+	missing := make(map[string][]string)
+	forEachTemplateField(&resolved, func(field string, value *string) {
+		*value = placeholderRe.ReplaceAllStringFunc(*value, func(placeholder string) string {
+			match := placeholderRe.FindStringSubmatch(placeholder)
+			name := match[1]
+			if value, ok := lookup(name); ok {
+				return value
+			}
+			if strings.Contains(placeholder, ":-") {
+				return match[2]
+			}
+			missing[name] = append(missing[name], field)
+			return placeholder
+		})
+	})
+
+	if len(missing) > 0 {
+		return config, &UnresolvedError{Config: config.Name, Variables: missing}
+	}
+	return resolved, nil
+}
+
+// forEachTemplateField calls fn for every string field that may hold placeholders
+func forEachTemplateField(config *Config, fn func(field string, value *string)) {
+	fn("cloud", &config.Cloud)
+	fn("tenant_id", &config.TenantID)
+	fn("subscription_id", &config.SubscriptionID)
+	fn("resource_id", &config.ResourceID)
+	fn("resource_name", &config.ResourceName)
+	fn("target_ip", &config.TargetIP)
+	fn("bastion_name", &config.BastionName)
+	fn("bastion_resource_group", &config.BastionResourceGroup)
+	fn("bastion_subscription_id", &config.BastionSubscriptionID)
+	fn("username", &config.Username)
+	fn("description", &config.Description)
+	fn("password_secret", &config.PasswordSecret)
+	for i := range config.Args {
+		fn(fmt.Sprintf("args[%d]", i), &config.Args[i])
+	}
+	if ref := config.KeyVaultSecret; ref != nil {
+		fn("key_vault_secret.vault", &ref.Vault)
+		fn("key_vault_secret.secret", &ref.Secret)
+		fn("key_vault_secret.subscription_id", &ref.SubscriptionID)
+		fn("key_vault_secret.vault_url", &ref.VaultURL)
+	}
+}
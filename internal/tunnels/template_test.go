@@ -0,0 +1,168 @@
+package tunnels
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mapLookup looks variables up in a map
+func mapLookup(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+// templateConfig is a saved configuration using placeholders in several fields
+func templateConfig() Config {
+	return Config{
+		Name:           "db-${env}",
+		SubscriptionID: "${sub}",
+		ResourceID:     "/subscriptions/${sub}/resourceGroups/rg-${env}/providers/Microsoft.Compute/virtualMachines/vm-db",
+		BastionName:    "bastion-${region:-weu}",
+		Username:       "${admin:-azureuser}",
+		Args:           []string{"--port", "${port}"},
+		KeyVaultSecret: &KeyVaultSecret{Vault: "kv-${env}", Secret: "db-password"},
+		Tags:           map[string]string{"env": "${env}"},
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		vars    map[string]string
+		check   func(t *testing.T, resolved Config)
+		missing map[string][]string
+	}{
+		{
+			name: "all set, defaults used",
+			vars: map[string]string{"env": "prod", "sub": "sub-1", "port": "5432"},
+			check: func(t *testing.T, resolved Config) {
+				want := templateConfig()
+				want.SubscriptionID = "sub-1"
+				want.ResourceID = "/subscriptions/sub-1/resourceGroups/rg-prod/providers/Microsoft.Compute/virtualMachines/vm-db"
+				want.BastionName = "bastion-weu"
+				want.Username = "azureuser"
+				want.Args = []string{"--port", "5432"}
+				want.KeyVaultSecret = &KeyVaultSecret{Vault: "kv-prod", Secret: "db-password"}
+				if !reflect.DeepEqual(resolved, want) {
+					t.Errorf("resolved = %+v\nwant %+v", resolved, want)
+				}
+			},
+		},
+		{
+			name: "variables override defaults",
+			vars: map[string]string{"env": "dev", "sub": "sub-2", "port": "22", "region": "neu", "admin": "ops"},
+			check: func(t *testing.T, resolved Config) {
+				if resolved.BastionName != "bastion-neu" || resolved.Username != "ops" {
+					t.Errorf("bastion %q, username %q", resolved.BastionName, resolved.Username)
+				}
+			},
+		},
+		{
+			name: "empty values are values",
+			vars: map[string]string{"env": "", "sub": "sub-1", "port": "1", "admin": ""},
+			check: func(t *testing.T, resolved Config) {
+				if resolved.Username != "" || resolved.KeyVaultSecret.Vault != "kv-" {
+					t.Errorf("username %q, vault %q", resolved.Username, resolved.KeyVaultSecret.Vault)
+				}
+			},
+		},
+		{
+			name: "unknown variables",
+			vars: map[string]string{"env": "prod"},
+			missing: map[string][]string{
+				"sub":  {"subscription_id", "resource_id"},
+				"port": {"args[1]"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := templateConfig()
+			resolved, err := Resolve(saved, mapLookup(tt.vars))
+			if tt.missing != nil {
+				var unresolved *UnresolvedError
+				if !errors.As(err, &unresolved) {
+					t.Fatalf("err = %v, want an UnresolvedError", err)
+				}
+				if unresolved.Config != "db-${env}" || !reflect.DeepEqual(unresolved.Variables, tt.missing) {
+					t.Errorf("unresolved = %+v, want %v", unresolved, tt.missing)
+				}
+				if !strings.Contains(err.Error(), "${sub} (in subscription_id, resource_id)") {
+					t.Errorf("error = %v", err)
+				}
+				if !reflect.DeepEqual(resolved, templateConfig()) {
+					t.Errorf("returned configuration was changed: %+v", resolved)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve: %v", err)
+			}
+			tt.check(t, resolved)
+			if !reflect.DeepEqual(saved, templateConfig()) {
+				t.Errorf("saved configuration was changed: %+v", saved)
+			}
+		})
+	}
+}
+
+func TestResolveWithoutPlaceholders(t *testing.T) {
+	config := Config{Name: "plain", SubscriptionID: "sub-1", Args: []string{"-v"}, Description: "costs $5 {each}"}
+	if HasPlaceholders(config) {
+		t.Error("HasPlaceholders = true for a plain configuration")
+	}
+	resolved, err := Resolve(config, mapLookup(nil))
+	if err != nil || !reflect.DeepEqual(resolved, config) {
+		t.Errorf("Resolve = %+v, %v", resolved, err)
+	}
+	if !HasPlaceholders(templateConfig()) {
+		t.Error("HasPlaceholders = false for a templated configuration")
+	}
+}
+
+func TestSavedConfigStaysUnresolved(t *testing.T) {
+	m := newTestManager(t)
+	saved := templateConfig()
+	saved.ConnectionType = "tunnel"
+	if err := m.SaveConfig(saved); err != nil {
+		t.Fatalf("SaveConfig: %v", err)
+	}
+
+	// Connecting resolves a copy; the saved configuration is stored back
+	// with its usage recorded and its placeholders intact
+	stored, ok := m.FindConfig("db-${env}")
+	if !ok {
+		t.Fatal("saved configuration not found")
+	}
+	resolved, err := Resolve(stored, mapLookup(map[string]string{"env": "prod", "sub": "sub-1", "port": "5432"}))
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	resolved.Args[1] = "changed"
+	resolved.KeyVaultSecret.Vault = "changed"
+	stored.LastUsed = time.Now()
+	if err := m.SaveConfig(stored); err != nil {
+		t.Fatalf("SaveConfig: %v", err)
+	}
+
+	data, err := os.ReadFile(m.tunnelFile)
+	if err != nil {
+		t.Fatalf("read tunnels.json: %v", err)
+	}
+	for _, want := range []string{`"subscription_id": "${sub}"`, `"${port}"`, `"vault": "kv-${env}"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("tunnels.json lacks %s:\n%s", want, data)
+		}
+	}
+	for _, leaked := range []string{"sub-1", "5432", "changed"} {
+		if strings.Contains(string(data), leaked) {
+			t.Errorf("tunnels.json contains resolved value %q", leaked)
+		}
+	}
+}
@@ -4,22 +4,74 @@ import "time"
 
 // Config represents a tunnel configuration
 type Config struct {
-	Name                  string    `json:"name"`
-	SubscriptionID        string    `json:"subscription_id"`
-	ResourceID            string    `json:"resource_id"`
-	ResourceName          string    `json:"resource_name"`
-	LocalPort             int       `json:"local_port"`
-	RemotePort            int       `json:"remote_port"`
-	Command               string    `json:"command"`
-	Args                  []string  `json:"args"`
-	LastUsed              time.Time `json:"last_used"`
-	BastionName           string    `json:"bastion_name"`
-	BastionResourceGroup  string    `json:"bastion_resource_group"`
-	BastionSubscriptionID string    `json:"bastion_subscription_id"`
-	ConnectionType        string    `json:"connection_type"`
-	Username              string    `json:"username"`
-	AuthType              string    `json:"auth_type"`
-	EnableMFA             bool      `json:"enable_mfa,omitempty"`
+	Name                  string            `json:"name"`
+	SubscriptionID        string            `json:"subscription_id"`
+	ResourceID            string            `json:"resource_id"`
+	ResourceName          string            `json:"resource_name"`
+	LocalPort             int               `json:"local_port"`
+	RemotePort            int               `json:"remote_port"`
+	Command               string            `json:"command"`
+	Args                  []string          `json:"args"`
+	LastUsed              time.Time         `json:"last_used"`
+	BastionName           string            `json:"bastion_name"`
+	BastionResourceGroup  string            `json:"bastion_resource_group"`
+	BastionSubscriptionID string            `json:"bastion_subscription_id"`
+	ConnectionType        string            `json:"connection_type"`
+	Username              string            `json:"username"`
+	AuthType              string            `json:"auth_type"`
+	EnableMFA             bool              `json:"enable_mfa,omitempty"`
+	Description           string            `json:"description,omitempty"`
+	Tags                  map[string]string `json:"tags,omitempty"`
+	PasswordSecret        string            `json:"password_secret,omitempty"`
+	KeyVaultSecret        *KeyVaultSecret   `json:"key_vault_secret,omitempty"`
+	StopVMOnDisconnect    bool              `json:"stop_vm_on_disconnect,omitempty"`
+	TargetKind            string            `json:"target_kind,omitempty"`
+	TargetIP              string            `json:"target_ip,omitempty"`
+	TenantID              string            `json:"tenant_id,omitempty"`
+	Cloud                 string            `json:"cloud,omitempty"`
+	JIT                   *JITAccess        `json:"jit,omitempty"`
+	PIMRole               *PIMRole          `json:"pim_role,omitempty"`
+
+	// Source is the file the configuration was loaded from
+	Source string `json:"-"`
+	// ReadOnly is set for configurations loaded from a team source
+	ReadOnly bool `json:"-"`
+}
+
+// KeyVaultSecret references an Azure Key Vault secret holding a connection credential
+type KeyVaultSecret struct {
+	// Vault is the Key Vault name
+	Vault string `json:"vault"`
+	// Secret is the secret name within the vault
+	Secret string `json:"secret"`
+	// Kind is "password" or "ssh-key"
+	Kind string `json:"kind"`
+	// SubscriptionID is the vault's subscription, used to look up its URI
+	SubscriptionID string `json:"subscription_id,omitempty"`
+	// VaultURL overrides the vault URI, e.g. for private endpoints. It must
+	// be https under the cloud's Key Vault DNS suffix or its private link zone.
+	VaultURL string `json:"vault_url,omitempty"`
+}
+
+// JITAccess asks for a Defender for Cloud just-in-time VM access request
+// before connecting
+type JITAccess struct {
+	// Ports are the VM ports to open; empty means the port the connection uses
+	Ports []int `json:"ports,omitempty"`
+	// Duration is how long access is requested for, e.g. "2h"; empty means
+	// one hour, or the policy maximum if that is shorter
+	Duration string `json:"duration,omitempty"`
+	// Justification is recorded with the request
+	Justification string `json:"justification,omitempty"`
+}
+
+// PIMRole is the eligible Privileged Identity Management role a saved
+// configuration activates when its subscription is not accessible
+type PIMRole struct {
+	// Role is the role definition name, e.g. Contributor
+	Role string `json:"role"`
+	// Scope is the scope of the eligibility, e.g. /subscriptions/<id>
+	Scope string `json:"scope"`
 }
 
 // SavedConfig represents a saved tunnel configuration
@@ -43,6 +95,7 @@ type SavedConfig struct {
 // Active represents a currently running tunnel
 type Active struct {
 	ID                    string    `json:"id"`
+	ConfigName            string    `json:"config_name,omitempty"`
 	LocalPort             int       `json:"local_port"`
 	RemotePort            int       `json:"remote_port"`
 	ResourceID            string    `json:"resource_id"`
@@ -54,4 +107,7 @@ type Active struct {
 	StartTime             time.Time `json:"start_time"`
 	Status                string    `json:"status"`
 	PID                   int       `json:"pid"`
+	StopVMOnClose         bool      `json:"stop_vm_on_close,omitempty"`
+	TenantID              string    `json:"tenant_id,omitempty"`
+	TargetIP              string    `json:"target_ip,omitempty"`
 }
@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// askPassEnv names the socket the askpass helper reads the secret from. Only
+// this handle is put in ssh's environment, never the secret itself.
+const askPassEnv = "BASTIONBUDDY_ASKPASS_SOCKET"
+
+// askPassTimeout bounds how long the helper waits for the secret
+const askPassTimeout = 10 * time.Second
+
+// opensshVersion matches the version in `ssh -V` output, e.g.
+// OpenSSH_9.6p1 or OpenSSH_for_Windows_8.6p1
+var opensshVersion = regexp.MustCompile(`OpenSSH_(?:for_Windows_)?(\d+)\.(\d+)`)
+
+// AskPassEnv returns environment variables that make ssh read its password
+// from this executable instead of the terminal, and a cleanup function to
+// call once ssh has exited. The secret is served once over a socket only the
+// current user can open.
+func AskPassEnv(secret string) ([]string, func(), error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to locate executable for askpass: %v", err)
+	}
+
+	dir, err := os.MkdirTemp("", "bastionbuddy-askpass-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create askpass directory: %v", err)
+	}
+	socket := filepath.Join(dir, "askpass.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, nil, fmt.Errorf("failed to create askpass socket: %v", err)
+	}
+	cleanup := func() {
+		_ = listener.Close()
+		_ = os.RemoveAll(dir)
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to restrict askpass socket: %v", err)
+	}
+	go serveAskPass(listener, secret)
+
+	env := []string{
+		"SSH_ASKPASS=" + exe,
+		askPassEnv + "=" + socket,
+	}
+	if sshSupportsAskPassRequire() {
+		env = append(env, "SSH_ASKPASS_REQUIRE=force")
+	} else if os.Getenv("DISPLAY") == "" {
+		// Older OpenSSH versions only use SSH_ASKPASS when DISPLAY is set
+		env = append(env, "DISPLAY=:0")
+	}
+	return env, cleanup, nil
+}
+
+// serveAskPass hands the secret to the first connection and stops listening,
+// so it can be read only once
+func serveAskPass(listener net.Listener, secret string) {
+	conn, err := listener.Accept()
+	_ = listener.Close()
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(askPassTimeout))
+	_, _ = io.WriteString(conn, secret)
+}
+
+// sshSupportsAskPassRequire reports whether the installed OpenSSH honours
+// SSH_ASKPASS_REQUIRE, which was added in 8.4
+func sshSupportsAskPassRequire() bool {
+	// ssh -V prints its version to stderr
+	output, err := exec.Command("ssh", "-V").CombinedOutput()
+	if err != nil {
+		return false
+	}
+	match := opensshVersion.FindStringSubmatch(string(output))
+	if match == nil {
+		return false
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	return major > 8 || major == 8 && minor >= 4
+}
+
+// isSecretPrompt reports whether ssh is asking for a password or key
+// passphrase, as opposed to e.g. confirming a host key
+func isSecretPrompt(prompt string) bool {
+	prompt = strings.ToLower(prompt)
+	return strings.Contains(prompt, "password") || strings.Contains(prompt, "passphrase")
+}
+
+// HandleAskPass answers an ssh askpass request and reports whether this run
+// was one. Prompts other than for a password or passphrase are refused with
+// an error, so ssh does not take the secret as an answer to them. It must be
+// called before any other argument handling.
+func HandleAskPass() (bool, error) {
+	socket, ok := os.LookupEnv(askPassEnv)
+	if !ok {
+		return false, nil
+	}
+
+	prompt := strings.Join(os.Args[1:], " ")
+	if !isSecretPrompt(prompt) {
+		return true, fmt.Errorf("not answering ssh prompt %q", strings.TrimSpace(prompt))
+	}
+
+	conn, err := net.DialTimeout("unix", socket, askPassTimeout)
+	if err != nil {
+		return true, fmt.Errorf("failed to read password for ssh: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(askPassTimeout))
+	secret, err := io.ReadAll(conn)
+	if err != nil {
+		return true, fmt.Errorf("failed to read password for ssh: %v", err)
+	}
+	if len(secret) == 0 {
+		return true, fmt.Errorf("no password available for ssh; it is handed out only once")
+	}
+	if _, err := fmt.Fprintln(os.Stdout, string(secret)); err != nil {
+		return true, err
+	}
+	return true, nil
+}
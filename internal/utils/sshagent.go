@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AddKeyToAgent loads a private key into the running ssh-agent for the given
+// lifetime, so it never touches the disk. It returns the path of a temporary
+// file holding only the public key, which ssh accepts as an identity file and
+// matches against the agent, and a function that removes the key from the
+// agent and deletes that file.
+func AddKeyToAgent(privateKey []byte, lifetime time.Duration) (string, func(), error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return "", nil, fmt.Errorf("no ssh-agent available (SSH_AUTH_SOCK is not set)")
+	}
+
+	rawKey, err := ssh.ParseRawPrivateKey(privateKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(rawKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load private key: %v", err)
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to connect to ssh-agent: %v", err)
+	}
+	client := agent.NewClient(conn)
+
+	if err := client.Add(agent.AddedKey{
+		PrivateKey:   rawKey,
+		Comment:      "bastionbuddy",
+		LifetimeSecs: uint32(lifetime.Seconds()),
+	}); err != nil {
+		_ = conn.Close()
+		return "", nil, fmt.Errorf("failed to add key to ssh-agent: %v", err)
+	}
+	// The lifetime only bounds how long the key outlives a crash; once the
+	// connection is done it is removed right away
+	removeKey := func() {
+		_ = client.Remove(signer.PublicKey())
+		_ = conn.Close()
+	}
+
+	file, err := os.CreateTemp("", "bastionbuddy-*.pub")
+	if err != nil {
+		removeKey()
+		return "", nil, fmt.Errorf("failed to create public key file: %v", err)
+	}
+	cleanup := func() {
+		removeKey()
+		_ = os.Remove(file.Name())
+	}
+
+	if _, err := file.Write(ssh.MarshalAuthorizedKey(signer.PublicKey())); err != nil {
+		_ = file.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("failed to write public key file: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to write public key file: %v", err)
+	}
+
+	return file.Name(), cleanup, nil
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startAgent serves an in-memory keyring on a unix socket and points
+// SSH_AUTH_SOCK at it
+func startAgent(t *testing.T) agent.Agent {
+	t.Helper()
+	keyring := agent.NewKeyring()
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+	return keyring
+}
+
+func TestAddKeyToAgent(t *testing.T) {
+	keyring := startAgent(t)
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	publicKeyFile, cleanup, err := AddKeyToAgent(pem.EncodeToMemory(block), time.Minute)
+	if err != nil {
+		t.Fatalf("AddKeyToAgent: %v", err)
+	}
+	keys, err := keyring.List()
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("agent keys = %v, %v, want one", keys, err)
+	}
+	data, err := os.ReadFile(publicKeyFile)
+	if err != nil {
+		t.Fatalf("read public key file: %v", err)
+	}
+	public, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil || string(public.Marshal()) != string(keys[0].Marshal()) {
+		t.Errorf("public key file does not match the agent key: %v", err)
+	}
+
+	cleanup()
+	if keys, _ := keyring.List(); len(keys) != 0 {
+		t.Errorf("agent still holds %d keys after cleanup", len(keys))
+	}
+	if _, err := os.Stat(publicKeyFile); !os.IsNotExist(err) {
+		t.Errorf("public key file not removed: %v", err)
+	}
+}
+
+func TestAddKeyToAgentWithoutAgent(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if _, _, err := AddKeyToAgent([]byte("unused"), time.Minute); err == nil {
+		t.Fatal("expected an error without an ssh-agent")
+	}
+}
@@ -77,6 +77,17 @@ func AzureInteractiveCommand(args ...string) error {
 	return cmd.Run()
 }
 
+// AzureInteractiveCommandWithEnv executes an interactive Azure CLI command with
+// additional environment variables, e.g. for supplying stored credentials.
+func AzureInteractiveCommandWithEnv(env []string, args ...string) error {
+	cmd := exec.Command("az", args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
 // AzureSetSubscription sets the active Azure subscription.
 // It returns any error that occurred during the operation.
 func AzureSetSubscription(subscriptionID string) error {
@@ -120,3 +131,33 @@ func GetUserInputInt(prompt string) (int, error) {
 
 	return result, nil
 }
+
+// GetUserInputIntWithDefault prompts the user for an integer and returns
+// defaultValue when the input is left empty.
+func GetUserInputIntWithDefault(prompt string, defaultValue int) (int, error) {
+	input, err := ReadInput(prompt)
+	if err != nil {
+		return 0, err
+	}
+	if strings.TrimSpace(input) == "" {
+		return defaultValue, nil
+	}
+
+	var result int
+	_, err = fmt.Sscanf(input, "%d", &result)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer input: %v", err)
+	}
+
+	return result, nil
+}
+
+// ReadPassword prompts the user for a secret value without echoing it.
+func ReadPassword(prompt string) (string, error) {
+	prompter := promptui.Prompt{
+		Label: prompt,
+		Mask:  '*',
+	}
+
+	return prompter.Run()
+}
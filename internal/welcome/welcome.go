@@ -4,13 +4,12 @@ package welcome
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
 
 	"github.com/antnsn/BastionBuddy/internal/azure"
+	"github.com/antnsn/BastionBuddy/internal/config"
 	"github.com/fatih/color"
 )
 
@@ -39,11 +38,24 @@ func ShowWelcome() {
  ██████╔╝██║  ██║███████║   ██║   ██║╚██████╔╝██║ ╚████║██████╔╝╚██████╔╝██████╔╝██████╔╝   ██║
  ╚═════╝ ╚═╝  ╚═╝╚══════╝   ╚═╝   ╚═╝ ╚═════╝ ╚═╝  ╚═══╝╚═════╝  ╚═════╝ ╚═════╝ ╚═════╝    ╚═╝
 `
-	// Print logo
-	if _, err := cyan.Println(logo); err != nil {
+	// Print logo, tinted with the active profile's color
+	logoColor := cyan
+	profile, profileErr := config.ActiveProfile()
+	if profile != nil {
+		logoColor = profileColor(profile.Color)
+	}
+	if _, err := logoColor.Println(logo); err != nil {
 		fmt.Println(logo)
 	}
 
+	// Make it obvious which environment we are working in
+	if profileErr != nil {
+		fmt.Printf("Warning: failed to load profile: %v\n", profileErr)
+	}
+	if profile != nil {
+		showProfileBanner(profile)
+	}
+
 	// Print version
 	if _, err := magenta.Print("Version: "); err != nil {
 		fmt.Print("Version: ")
@@ -86,8 +98,7 @@ func ShowWelcome() {
 	if _, err := cyan.Print("📂 Config Location: "); err != nil {
 		fmt.Print("📂 Config Location: ")
 	}
-	if homeDir, err := os.UserHomeDir(); err == nil {
-		configPath := filepath.Join(homeDir, ".config", "bastionbuddy")
+	if configPath, err := config.ConfigDir(); err == nil {
 		if runtime.GOOS == "windows" {
 			// Convert to Windows path style for display
 			configPath = strings.ReplaceAll(configPath, "/", "\\")
@@ -96,6 +107,7 @@ func ShowWelcome() {
 	} else {
 		fmt.Printf("~/.config/bastionbuddy/\n")
 	}
+	showIdentity()
 
 	printSeparator()
 
@@ -106,6 +118,77 @@ func ShowWelcome() {
 	printSeparator()
 }
 
+// showProfileBanner displays the active profile in its own color
+func showProfileBanner(profile *config.Profile) {
+	c := profileColor(profile.Color).Add(color.Bold)
+
+	banner := fmt.Sprintf("🏷️  Profile: %s", profile.Name)
+	if profile.TenantID != "" {
+		banner += fmt.Sprintf(" | Tenant: %s", profile.TenantID)
+	}
+	if profile.Banner != "" {
+		banner += fmt.Sprintf(" | %s", profile.Banner)
+	}
+
+	if _, err := c.Println(banner); err != nil {
+		fmt.Println(banner)
+	}
+	fmt.Println()
+}
+
+// profileColor maps a profile color name to a terminal color
+func profileColor(name string) *color.Color {
+	switch strings.ToLower(name) {
+	case "red":
+		return color.New(color.FgRed)
+	case "green":
+		return color.New(color.FgGreen)
+	case "yellow":
+		return color.New(color.FgYellow)
+	case "blue":
+		return color.New(color.FgBlue)
+	case "magenta":
+		return color.New(color.FgMagenta)
+	case "white":
+		return color.New(color.FgWhite)
+	default:
+		return color.New(color.FgCyan)
+	}
+}
+
+// showIdentity displays the signed-in identity and when its token expires
+func showIdentity() {
+	if _, err := cyan.Print("👤 Signed in as: "); err != nil {
+		fmt.Print("👤 Signed in as: ")
+	}
+
+	identity, err := azure.CurrentIdentity()
+	if err != nil {
+		fmt.Println("not signed in (you will be asked to sign in when needed)")
+		return
+	}
+	if identity == nil {
+		fmt.Println("not signed in yet")
+		return
+	}
+
+	line := identity.Name
+	if identity.TenantID != "" {
+		line += fmt.Sprintf(" | Tenant: %s", identity.TenantID)
+	}
+	remaining := time.Until(identity.ExpiresOn).Round(time.Minute)
+	if remaining > 0 {
+		expiresIn := fmt.Sprintf("%dm", int(remaining.Minutes()))
+		if remaining >= time.Hour {
+			expiresIn = fmt.Sprintf("%dh%02dm", int(remaining.Hours()), int(remaining.Minutes())%60)
+		}
+		line += fmt.Sprintf(" | Token expires in %s (%s)", expiresIn, identity.ExpiresOn.Local().Format("15:04"))
+	} else {
+		line += " | Token expired, renewed when needed"
+	}
+	fmt.Println(line)
+}
+
 // showActiveTunnels displays the list of active tunnels
 func showActiveTunnels() {
 	manager, err := azure.GetTunnelManager()